@@ -0,0 +1,321 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+)
+
+var (
+	// ErrInvalidPrincipal if the principal of the binding is neither subject nor group
+	ErrInvalidPrincipal = errors.New(`invalid principal`)
+
+	// ErrGroupCycle if the group contains itself directly or transitively
+	ErrGroupCycle = errors.New(`group cycle`)
+)
+
+// Principal is the holder of the role binding and the member of the group: the subject or the group
+type Principal struct {
+	// SubjectID of the subject principal
+	SubjectID uint64
+
+	// Group name of the group principal
+	Group string
+}
+
+// SubjectPrincipal returns principal of the subject
+func SubjectPrincipal(subjectID uint64) Principal {
+	return Principal{SubjectID: subjectID}
+}
+
+// GroupPrincipal returns principal of the group
+func GroupPrincipal(group string) Principal {
+	return Principal{Group: group}
+}
+
+// IsGroup returns true if the principal is the group
+func (p Principal) IsGroup() bool {
+	return p.Group != ``
+}
+
+// String returns `subject:<id>` or `group:<name>`
+func (p Principal) String() string {
+	if p.IsGroup() {
+		return `group:` + p.Group
+	}
+	return `subject:` + strconv.FormatUint(p.SubjectID, 10)
+}
+
+// BindingStore of the role bindings of subjects and groups and the group memberships
+//
+// Bindings are scoped by the tenant of the context (see WithTenant).
+// Methods return direct bindings and memberships only, groups are resolved by Bindings.
+type BindingStore interface {
+	// BindRoles assigns roles to the principal, bound roles are skipped
+	BindRoles(ctx context.Context, principal Principal, roles ...string) error
+
+	// UnbindRoles removes roles from the principal
+	UnbindRoles(ctx context.Context, principal Principal, roles ...string) error
+
+	// AddMembers to the group, members are subjects or groups, existing members are skipped
+	AddMembers(ctx context.Context, group string, members ...Principal) error
+
+	// RemoveMembers from the group
+	RemoveMembers(ctx context.Context, group string, members ...Principal) error
+
+	// PrincipalRoles returns names of the roles bound to the principal
+	PrincipalRoles(ctx context.Context, principal Principal) ([]string, error)
+
+	// RoleBindings returns principals the role is bound to
+	RoleBindings(ctx context.Context, role string) ([]Principal, error)
+
+	// Groups returns names of the groups which contain the principal
+	Groups(ctx context.Context, member Principal) ([]string, error)
+
+	// Members returns subjects and groups of the group
+	Members(ctx context.Context, group string) ([]Principal, error)
+}
+
+// SubjectRoleResolver is the BindingStore which resolves roles of the subject bound directly
+// and through the nested groups by the single request to the storage
+type SubjectRoleResolver interface {
+	// SubjectRoles returns names of the roles of the subject and its groups in the tenant of the context
+	SubjectRoles(ctx context.Context, subjectID uint64) ([]string, error)
+}
+
+// Bindings registry of the roles held by subjects and groups
+//
+// Subjects get roles bound to them directly and roles of the groups they belong to,
+// groups can be nested: members of the child group are members of the parent groups.
+type Bindings struct {
+	store BindingStore
+}
+
+// NewBindings returns registry of the store, in-memory store is used if nil
+func NewBindings(store BindingStore) *Bindings {
+	if store == nil {
+		store = NewMemoryBindingStore()
+	}
+	return &Bindings{store: store}
+}
+
+// Store returns the storage of the bindings
+func (b *Bindings) Store() BindingStore {
+	return b.store
+}
+
+// Assign roles to the subject
+func (b *Bindings) Assign(ctx context.Context, subjectID uint64, roles ...string) error {
+	return b.store.BindRoles(ctx, SubjectPrincipal(subjectID), roles...)
+}
+
+// Revoke roles from the subject, roles of the subject groups are not affected
+func (b *Bindings) Revoke(ctx context.Context, subjectID uint64, roles ...string) error {
+	return b.store.UnbindRoles(ctx, SubjectPrincipal(subjectID), roles...)
+}
+
+// AssignGroup roles to the group and all its members
+func (b *Bindings) AssignGroup(ctx context.Context, group string, roles ...string) error {
+	if group == `` {
+		return wrapError(ErrInvalidPrincipal, `AssignGroup (empty group)`)
+	}
+	return b.store.BindRoles(ctx, GroupPrincipal(group), roles...)
+}
+
+// RevokeGroup roles from the group
+func (b *Bindings) RevokeGroup(ctx context.Context, group string, roles ...string) error {
+	return b.store.UnbindRoles(ctx, GroupPrincipal(group), roles...)
+}
+
+// AddToGroup subjects as members of the group
+func (b *Bindings) AddToGroup(ctx context.Context, group string, subjectIDs ...uint64) error {
+	if group == `` {
+		return wrapError(ErrInvalidPrincipal, `AddToGroup (empty group)`)
+	}
+	return b.store.AddMembers(ctx, group, subjectPrincipals(subjectIDs)...)
+}
+
+// RemoveFromGroup subjects which are members of the group
+func (b *Bindings) RemoveFromGroup(ctx context.Context, group string, subjectIDs ...uint64) error {
+	return b.store.RemoveMembers(ctx, group, subjectPrincipals(subjectIDs)...)
+}
+
+// AddSubgroups to the group, members of the subgroups get roles of the group,
+// returns ErrGroupCycle if the group is a subgroup of the children
+func (b *Bindings) AddSubgroups(ctx context.Context, group string, children ...string) error {
+	if group == `` {
+		return wrapError(ErrInvalidPrincipal, `AddSubgroups (empty group)`)
+	}
+	members := make([]Principal, 0, len(children))
+	for _, child := range children {
+		if child == `` {
+			return wrapError(ErrInvalidPrincipal, `AddSubgroups (empty subgroup)`)
+		}
+		// The group must not be reachable from the subgroup
+		nested, err := b.subgroups(ctx, child)
+		if err != nil {
+			return err
+		}
+		if child == group || slices.Contains(nested, group) {
+			return wrapError(ErrGroupCycle, group+` -> `+child)
+		}
+		members = append(members, GroupPrincipal(child))
+	}
+	return b.store.AddMembers(ctx, group, members...)
+}
+
+// RemoveSubgroups from the group
+func (b *Bindings) RemoveSubgroups(ctx context.Context, group string, children ...string) error {
+	members := make([]Principal, 0, len(children))
+	for _, child := range children {
+		members = append(members, GroupPrincipal(child))
+	}
+	return b.store.RemoveMembers(ctx, group, members...)
+}
+
+// SubjectGroups returns groups of the subject including parent groups of the nested groups
+func (b *Bindings) SubjectGroups(ctx context.Context, subjectID uint64) ([]string, error) {
+	return b.principalGroups(ctx, SubjectPrincipal(subjectID))
+}
+
+// SubjectRoles returns effective roles of the subject: bound directly and through the groups,
+// the names are sorted and unique
+//
+// Stores which implement SubjectRoleResolver resolve the roles by the single request,
+// the result is memoized in the context of WithDecisionCache.
+func (b *Bindings) SubjectRoles(ctx context.Context, subjectID uint64) ([]string, error) {
+	cache := decisionCacheFromContext(ctx)
+	key := boundRolesKey{bindings: b, tenant: TenantFromContext(ctx), subject: subjectID}
+	if cache != nil {
+		if names, ok := cache.boundRoles(key); ok {
+			return slices.Clone(names), nil
+		}
+	}
+	names, err := b.subjectRoles(ctx, subjectID)
+	if err == nil && cache != nil {
+		cache.setBoundRoles(key, slices.Clone(names))
+	}
+	return names, err
+}
+
+func (b *Bindings) subjectRoles(ctx context.Context, subjectID uint64) ([]string, error) {
+	if resolver, ok := b.store.(SubjectRoleResolver); ok {
+		names, err := resolver.SubjectRoles(ctx, subjectID)
+		if err != nil {
+			return nil, err
+		}
+		return sortedUnique(names), nil
+	}
+	principal := SubjectPrincipal(subjectID)
+	groups, err := b.principalGroups(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+	principals := []Principal{principal}
+	for _, group := range groups {
+		principals = append(principals, GroupPrincipal(group))
+	}
+	var names []string
+	for _, p := range principals {
+		roles, err := b.store.PrincipalRoles(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, roles...)
+	}
+	return sortedUnique(names), nil
+}
+
+// RoleMembers returns subjects which hold the role directly or through the groups,
+// identifiers are sorted and unique
+func (b *Bindings) RoleMembers(ctx context.Context, role string) ([]uint64, error) {
+	principals, err := b.store.RoleBindings(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ids     []uint64
+		visited = map[string]bool{}
+	)
+	for len(principals) > 0 {
+		p := principals[0]
+		principals = principals[1:]
+		if !p.IsGroup() {
+			ids = append(ids, p.SubjectID)
+			continue
+		}
+		if visited[p.Group] {
+			continue
+		}
+		visited[p.Group] = true
+		members, err := b.store.Members(ctx, p.Group)
+		if err != nil {
+			return nil, err
+		}
+		principals = append(principals, members...)
+	}
+	return sortedUnique(ids), nil
+}
+
+// principalGroups returns groups which contain the principal directly or transitively
+func (b *Bindings) principalGroups(ctx context.Context, principal Principal) ([]string, error) {
+	var (
+		groups []string
+		queue  = []Principal{principal}
+	)
+	for len(queue) > 0 {
+		parents, err := b.store.Groups(ctx, queue[0])
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+		for _, parent := range parents {
+			if !slices.Contains(groups, parent) {
+				groups = append(groups, parent)
+				queue = append(queue, GroupPrincipal(parent))
+			}
+		}
+	}
+	return sortedUnique(groups), nil
+}
+
+// subgroups returns groups which are contained in the group directly or transitively
+func (b *Bindings) subgroups(ctx context.Context, group string) ([]string, error) {
+	var (
+		groups []string
+		queue  = []string{group}
+	)
+	for len(queue) > 0 {
+		members, err := b.store.Members(ctx, queue[0])
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+		for _, member := range members {
+			if member.IsGroup() && !slices.Contains(groups, member.Group) {
+				groups = append(groups, member.Group)
+				queue = append(queue, member.Group)
+			}
+		}
+	}
+	return groups, nil
+}
+
+func subjectPrincipals(ids []uint64) []Principal {
+	principals := make([]Principal, 0, len(ids))
+	for _, id := range ids {
+		principals = append(principals, SubjectPrincipal(id))
+	}
+	return principals
+}
+
+func sortedUnique[T string | uint64](list []T) []T {
+	slices.Sort(list)
+	return slices.Compact(list)
+}
+
+// Bindings returns the registry of the subject role bindings of the manager
+func (mng *Manager) Bindings() *Bindings {
+	return mng.bindings
+}
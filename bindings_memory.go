@@ -0,0 +1,147 @@
+package rbac
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// MemoryBindingStore keeps the role bindings and the group memberships in memory
+type MemoryBindingStore struct {
+	mx      sync.RWMutex
+	tenants map[string]*memoryBindings
+}
+
+type memoryBindings struct {
+	roles   map[Principal][]string
+	members map[string][]Principal
+}
+
+// NewMemoryBindingStore returns empty in-memory binding store
+func NewMemoryBindingStore() *MemoryBindingStore {
+	return &MemoryBindingStore{tenants: map[string]*memoryBindings{}}
+}
+
+// BindRoles assigns roles to the principal, bound roles are skipped
+func (s *MemoryBindingStore) BindRoles(ctx context.Context, principal Principal, roles ...string) error {
+	if principal == (Principal{}) && len(roles) > 0 {
+		return wrapError(ErrInvalidPrincipal, `BindRoles`)
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	bindings := s.tenant(ctx, true)
+	bindings.roles[principal] = appendUnique(bindings.roles[principal], roles...)
+	return nil
+}
+
+// UnbindRoles removes roles from the principal
+func (s *MemoryBindingStore) UnbindRoles(ctx context.Context, principal Principal, roles ...string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if bindings := s.tenant(ctx, false); bindings != nil {
+		bindings.roles[principal] = removeItems(bindings.roles[principal], roles)
+		if len(bindings.roles[principal]) == 0 {
+			delete(bindings.roles, principal)
+		}
+	}
+	return nil
+}
+
+// AddMembers to the group, members are subjects or groups, existing members are skipped
+func (s *MemoryBindingStore) AddMembers(ctx context.Context, group string, members ...Principal) error {
+	if group == `` && len(members) > 0 {
+		return wrapError(ErrInvalidPrincipal, `AddMembers (empty group)`)
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	bindings := s.tenant(ctx, true)
+	bindings.members[group] = appendUnique(bindings.members[group], members...)
+	return nil
+}
+
+// RemoveMembers from the group
+func (s *MemoryBindingStore) RemoveMembers(ctx context.Context, group string, members ...Principal) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if bindings := s.tenant(ctx, false); bindings != nil {
+		bindings.members[group] = removeItems(bindings.members[group], members)
+		if len(bindings.members[group]) == 0 {
+			delete(bindings.members, group)
+		}
+	}
+	return nil
+}
+
+// PrincipalRoles returns names of the roles bound to the principal in order of binding
+func (s *MemoryBindingStore) PrincipalRoles(ctx context.Context, principal Principal) ([]string, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	if bindings := s.tenant(ctx, false); bindings != nil {
+		return slices.Clone(bindings.roles[principal]), nil
+	}
+	return nil, nil
+}
+
+// RoleBindings returns principals the role is bound to
+func (s *MemoryBindingStore) RoleBindings(ctx context.Context, role string) ([]Principal, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	var principals []Principal
+	if bindings := s.tenant(ctx, false); bindings != nil {
+		for principal, roles := range bindings.roles {
+			if slices.Contains(roles, role) {
+				principals = append(principals, principal)
+			}
+		}
+	}
+	return principals, nil
+}
+
+// Groups returns names of the groups which contain the principal
+func (s *MemoryBindingStore) Groups(ctx context.Context, member Principal) ([]string, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	var groups []string
+	if bindings := s.tenant(ctx, false); bindings != nil {
+		for group, members := range bindings.members {
+			if slices.Contains(members, member) {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// Members returns subjects and groups of the group in order of adding
+func (s *MemoryBindingStore) Members(ctx context.Context, group string) ([]Principal, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	if bindings := s.tenant(ctx, false); bindings != nil {
+		return slices.Clone(bindings.members[group]), nil
+	}
+	return nil, nil
+}
+
+// tenant returns bindings of the tenant of the context, creates them if create is true
+func (s *MemoryBindingStore) tenant(ctx context.Context, create bool) *memoryBindings {
+	tenant := TenantFromContext(ctx)
+	bindings := s.tenants[tenant]
+	if bindings == nil && create {
+		bindings = &memoryBindings{roles: map[Principal][]string{}, members: map[string][]Principal{}}
+		s.tenants[tenant] = bindings
+	}
+	return bindings
+}
+
+func appendUnique[T comparable](list []T, items ...T) []T {
+	for _, item := range items {
+		if !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func removeItems[T comparable](list, items []T) []T {
+	return slices.DeleteFunc(list, func(item T) bool { return slices.Contains(items, item) })
+}
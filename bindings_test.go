@@ -0,0 +1,139 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingBindingStore struct {
+	*MemoryBindingStore
+}
+
+func (failingBindingStore) PrincipalRoles(context.Context, Principal) ([]string, error) {
+	return nil, errors.New(`store is down`)
+}
+
+// countingBindingStore resolves subject roles by the single call and counts the calls
+type countingBindingStore struct {
+	*MemoryBindingStore
+	calls int
+}
+
+func (s *countingBindingStore) SubjectRoles(ctx context.Context, subjectID uint64) ([]string, error) {
+	s.calls++
+	return s.PrincipalRoles(ctx, SubjectPrincipal(subjectID))
+}
+
+func TestBindings(t *testing.T) {
+	ctx := context.TODO()
+	b := NewBindings(nil)
+
+	assert.NoError(t, b.Assign(ctx, 1, `editor`, `viewer`))
+	assert.NoError(t, b.Assign(ctx, 1, `editor`))
+	assert.NoError(t, b.AssignGroup(ctx, `support`, `support`))
+	assert.NoError(t, b.AssignGroup(ctx, `staff`, `viewer`, `reporter`))
+	assert.NoError(t, b.AddToGroup(ctx, `support`, 2, 3))
+	assert.NoError(t, b.AddSubgroups(ctx, `staff`, `support`))
+	assert.NoError(t, b.AddToGroup(ctx, `staff`, 1))
+
+	roles, err := b.SubjectRoles(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`editor`, `reporter`, `viewer`}, roles)
+	roles, _ = b.SubjectRoles(ctx, 2)
+	assert.Equal(t, []string{`reporter`, `support`, `viewer`}, roles)
+	groups, _ := b.SubjectGroups(ctx, 3)
+	assert.Equal(t, []string{`staff`, `support`}, groups)
+
+	members, err := b.RoleMembers(ctx, `viewer`)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3}, members)
+	members, _ = b.RoleMembers(ctx, `support`)
+	assert.Equal(t, []uint64{2, 3}, members)
+
+	// Cycles of the nested groups are rejected
+	assert.ErrorIs(t, b.AddSubgroups(ctx, `support`, `staff`), ErrGroupCycle)
+	assert.ErrorIs(t, b.AddSubgroups(ctx, `staff`, `staff`), ErrGroupCycle)
+	assert.ErrorIs(t, b.AddSubgroups(ctx, `staff`, ``), ErrInvalidPrincipal)
+	assert.ErrorIs(t, b.Assign(ctx, 0, `viewer`), ErrInvalidPrincipal)
+
+	// Revocation
+	assert.NoError(t, b.Revoke(ctx, 1, `editor`))
+	assert.NoError(t, b.RemoveSubgroups(ctx, `staff`, `support`))
+	assert.NoError(t, b.RemoveFromGroup(ctx, `support`, 3))
+	roles, _ = b.SubjectRoles(ctx, 1)
+	assert.Equal(t, []string{`reporter`, `viewer`}, roles)
+	roles, _ = b.SubjectRoles(ctx, 2)
+	assert.Equal(t, []string{`support`}, roles)
+	roles, _ = b.SubjectRoles(ctx, 3)
+	assert.Empty(t, roles)
+	assert.NoError(t, b.RevokeGroup(ctx, `staff`, `viewer`))
+	members, _ = b.RoleMembers(ctx, `viewer`)
+	assert.Equal(t, []uint64{1}, members)
+
+	// Bindings are scoped by the tenant
+	acme := WithTenant(ctx, `acme`)
+	assert.NoError(t, b.Assign(acme, 1, `admin`))
+	roles, _ = b.SubjectRoles(acme, 1)
+	assert.Equal(t, []string{`admin`}, roles)
+	members, _ = b.RoleMembers(ctx, `admin`)
+	assert.Empty(t, members)
+}
+
+func TestManagerBindings(t *testing.T) {
+	ctx := context.TODO()
+	obj := &testObject{}
+
+	mng := NewManager(nil)
+	assert.NoError(t, mng.RegisterNewOwningPermissions((*testObject)(nil), []string{`view`, `update`}))
+	mng.RegisterRole(ctx,
+		MustNewRole(`viewer`, WithPermissions(`rbac.testObject.view.*`)),
+		MustNewRole(`editor`, WithPermissions(`rbac.testObject.update.*`)))
+
+	assert.NoError(t, mng.Bindings().AssignGroup(ctx, `editors`, `editor`))
+	assert.NoError(t, mng.Bindings().AddToGroup(ctx, `editors`, 1))
+
+	subject := NewSubject(1, 0, `viewer`)
+	assert.True(t, mng.Check(ctx, subject, obj, `view.all`).Allowed())
+	assert.True(t, mng.Check(ctx, subject, obj, `update.all`).Allowed())
+	assert.False(t, mng.Check(ctx, NewSubject(2, 0, `viewer`), obj, `update.all`).Allowed())
+	assert.Len(t, mng.SubjectRoles(ctx, NewSubject(1, 0)), 1)
+	assert.Equal(t, []string{`viewer`}, subject.Roles, `roles of the subject are not changed`)
+
+	// Failure of the store denies access
+	failing := NewManager(nil, WithBindings(failingBindingStore{NewMemoryBindingStore()}))
+	failing.RegisterRole(ctx, MustNewRole(`viewer`, WithPermissions(`rbac.testObject.view.*`)))
+	decision := failing.Check(ctx, subject, obj, `view.all`)
+	assert.False(t, decision.Allowed())
+	assert.Error(t, decision.Err)
+
+	assert.ErrorIs(t, WithBindings(nil)(&Manager{}), ErrInvalidOption)
+}
+
+func TestManagerBindingsResolver(t *testing.T) {
+	ctx := context.TODO()
+	obj := &testObject{}
+	store := &countingBindingStore{MemoryBindingStore: NewMemoryBindingStore()}
+	mng := NewManager(nil, WithBindings(store))
+	assert.NoError(t, mng.RegisterNewOwningPermissions((*testObject)(nil), []string{`view`}))
+	mng.RegisterRole(ctx, MustNewRole(`viewer`, WithPermissions(`rbac.testObject.view.*`)))
+	assert.NoError(t, mng.Bindings().Assign(ctx, 1, `viewer`, `viewer`))
+
+	// Roles of the subject are resolved by the store once per check
+	assert.True(t, mng.Check(ctx, NewSubject(1, 0), obj, `view.all`).Allowed())
+	assert.Equal(t, 1, store.calls)
+
+	// and once per request with the decision cache
+	cached := WithDecisionCache(ctx)
+	for i := 0; i < 3; i++ {
+		assert.True(t, mng.Check(cached, NewSubject(1, 0), obj, `view.all`).Allowed())
+	}
+	assert.Equal(t, 2, store.calls)
+	assert.False(t, mng.Check(WithTenant(cached, `acme`), NewSubject(1, 0), obj, `view.all`).Allowed())
+	assert.Equal(t, 3, store.calls)
+	roles, err := mng.Bindings().SubjectRoles(cached, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`viewer`}, roles)
+}
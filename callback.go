@@ -0,0 +1,95 @@
+package rbac
+
+import (
+	"context"
+	"reflect"
+)
+
+// checkFunc is the unified form of the custom check callback
+type checkFunc func(ctx context.Context, resource any, perm Permission) (bool, error)
+
+var (
+	contextType    = reflect.TypeOf((*context.Context)(nil)).Elem()
+	permissionType = reflect.TypeOf((*Permission)(nil)).Elem()
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// typedCheckFunc converts typed callback into the unified form without reflection
+func typedCheckFunc[T any](f func(ctx context.Context, resource T, perm Permission) bool) checkFunc {
+	return func(ctx context.Context, resource any, perm Permission) (bool, error) {
+		res, ok := resource.(T)
+		return ok && f(ctx, res, perm), nil
+	}
+}
+
+// typedCheckFuncE converts typed callback with error into the unified form without reflection
+func typedCheckFuncE[T any](f func(ctx context.Context, resource T, perm Permission) (bool, error)) checkFunc {
+	return func(ctx context.Context, resource any, perm Permission) (bool, error) {
+		res, ok := resource.(T)
+		if !ok {
+			return false, nil
+		}
+		return f(ctx, res, perm)
+	}
+}
+
+// reflectCheckFunc converts callback of any function type into the unified form
+//
+// The callback must have the signature `func(context.Context, <resource type>, Permission) bool`
+// or `func(context.Context, <resource type>, Permission) (bool, error)`, callbacks with different
+// result return false. Resources which do not match the resource argument type are not passed
+// to the callback and the check returns false.
+func reflectCheckFunc(f any) (checkFunc, reflect.Type, error) {
+	anyType := reflect.TypeOf((*any)(nil)).Elem()
+	switch fn := f.(type) {
+	case nil:
+		return nil, nil, ErrInvalidOptionParam
+	case func(context.Context, any, Permission) bool:
+		return typedCheckFunc(fn), anyType, nil
+	case func(context.Context, any, Permission) (bool, error):
+		return fn, anyType, nil
+	}
+	fval := reflect.ValueOf(f)
+	ftype := fval.Type()
+	if ftype.Kind() != reflect.Func || ftype.NumIn() != 3 ||
+		!contextType.AssignableTo(ftype.In(0)) || !permissionType.AssignableTo(ftype.In(2)) {
+		return nil, nil, ErrInvalidOptionParam
+	}
+	resType := ftype.In(1)
+	nilable := isNilableKind(resType.Kind())
+	return func(ctx context.Context, resource any, perm Permission) (bool, error) {
+		res := reflect.ValueOf(resource)
+		switch {
+		case !res.IsValid() && nilable:
+			res = reflect.Zero(resType)
+		case !res.IsValid() || !res.Type().AssignableTo(resType):
+			return false, nil
+		}
+		resp := fval.Call([]reflect.Value{
+			reflect.ValueOf(&ctx).Elem(), res,
+			reflect.ValueOf(&perm).Elem(),
+		})
+		switch {
+		case len(resp) == 2 && resp[1].Type() == errorType:
+			if !resp[1].IsNil() {
+				return false, resp[1].Interface().(error)
+			}
+		case len(resp) != 1:
+			return false, nil
+		}
+		return resp[0].Kind() == reflect.Bool && resp[0].Bool(), nil
+	}, resType, nil
+}
+
+func isNilableKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return true
+	}
+	return false
+}
+
+// checkResourceArgType returns false if callback argument type is incompatible with resource type
+func checkResourceArgType(argType, resType reflect.Type) bool {
+	return argType.Kind() == reflect.Interface || GetResType(argType) == resType
+}
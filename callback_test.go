@@ -0,0 +1,142 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithCheck(t *testing.T) {
+	ctx := context.TODO()
+	check := func(ctx context.Context, obj *testObject, perm Permission) bool {
+		return obj.name == `test`
+	}
+
+	perm := MustNewSimplePermission(`view`, WithCheck(check))
+	assert.True(t, perm.CheckPermissions(ctx, &testObject{name: `test`}, `view`))
+	assert.False(t, perm.CheckPermissions(ctx, &testObject{name: `other`}, `view`))
+	assert.False(t, perm.CheckPermissions(ctx, &testExt{name: `test`}, `view`))
+	assert.False(t, perm.CheckPermissions(ctx, nil, `view`))
+
+	resPerm, err := NewResourcePermission(`view`, (*testObject)(nil), WithCheck(check, &testExt{name: `data`}))
+	assert.NoError(t, err)
+	assert.Equal(t, &testExt{name: `data`}, resPerm.Ext())
+	assert.True(t, resPerm.CheckPermissions(ctx, &testObject{name: `test`}, `view`))
+	decision := Decide(ctx, DenyOverrides, []Permission{resPerm}, &testObject{name: `test`}, `view`)
+	assert.Equal(t, `github.com/demdxx/rbac.TestWithCheck.func1`, decision.Callbacks[0].Callback)
+
+	_, err = NewResourcePermission(`view`, (*testExt)(nil), WithCheck(check))
+	assert.ErrorIs(t, err, ErrInvalidOptionParam)
+	_, err = NewResourcePermission(`view`, (*testExt)(nil), WithCheck(func(context.Context, any, Permission) bool { return true }))
+	assert.NoError(t, err)
+	assert.Error(t, WithCheck[*testObject](nil)(&SimplePermission{}))
+	assert.Error(t, WithCheck(check)(nil))
+}
+
+func TestWithCustomCheckSignature(t *testing.T) {
+	ctx := context.TODO()
+
+	_, err := NewSimplePermission(`view`, WithCustomCheck(func(int, *testObject, Permission) bool { return true }))
+	assert.ErrorIs(t, err, ErrInvalidOptionParam)
+	_, err = NewSimplePermission(`view`, WithCustomCheck(`not a function`))
+	assert.ErrorIs(t, err, ErrInvalidOptionParam)
+	_, err = NewResourcePermission(`view`, (*testExt)(nil), WithCustomCheck(testCustomCallback))
+	assert.ErrorIs(t, err, ErrInvalidOptionParam)
+
+	var called bool
+	perm := MustNewSimplePermission(`view`, WithCustomCheck(func(_ context.Context, obj *testObject, _ Permission) bool {
+		called = true
+		return obj == nil
+	}))
+	assert.True(t, perm.CheckPermissions(ctx, nil, `view`))
+	assert.True(t, called)
+	assert.False(t, perm.CheckPermissions(ctx, &testExt{}, `view`))
+
+	assert.NoError(t, WithoutCustomCheck(perm))
+	assert.True(t, perm.CheckPermissions(ctx, &testExt{}, `view`))
+}
+
+func TestManagerRegisterTypedObject(t *testing.T) {
+	ctx := context.TODO()
+	mng := RegisterObject(NewManager(nil), func(_ context.Context, obj *testObject, perm Permission) bool {
+		return obj.name == `test`
+	})
+	assert.NotNil(t, mng.ObjectByName(`rbac.testObject`))
+	assert.NoError(t, mng.RegisterNewOwningPermissions((*testObject)(nil), []string{`view`}))
+	mng.RegisterRole(ctx, MustNewRole(`viewer`, WithPermissions(`rbac.testObject.view.*`)))
+
+	role := mng.Role(ctx, `viewer`)
+	assert.True(t, role.CheckPermissions(ctx, &testObject{name: `test`}, `view.*`))
+	assert.False(t, role.CheckPermissions(ctx, &testObject{name: `other`}, `view.*`))
+}
+
+func TestCheckPermissionsE(t *testing.T) {
+	ctx := context.TODO()
+	obj := &testObject{name: `test`}
+	errBackend := errors.New(`backend unavailable`)
+	failing := MustNewResourcePermission(`view`, (*testObject)(nil),
+		WithCheckE(func(_ context.Context, obj *testObject, _ Permission) (bool, error) {
+			if obj.name == `fail` {
+				return false, errBackend
+			}
+			return true, nil
+		}))
+
+	ok, err := failing.CheckPermissionsE(ctx, obj, `view`)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = failing.CheckPermissionsE(ctx, &testObject{name: `fail`}, `view`)
+	assert.ErrorIs(t, err, errBackend)
+	assert.False(t, ok)
+	assert.False(t, failing.CheckPermissions(ctx, &testObject{name: `fail`}, `view`))
+
+	_, err = failing.CheckPermissionsE(ctx, obj)
+	assert.ErrorIs(t, err, ErrInvalidCheckParams)
+
+	t.Run(`child-role`, func(t *testing.T) {
+		role := MustNewRole(`admin`, WithChildRoles(
+			MustNewRole(`viewer`, WithPermissions(failing)),
+		))
+		ok, err := role.CheckPermissionsE(ctx, &testObject{name: `fail`}, `view`)
+		assert.False(t, ok)
+		assert.ErrorIs(t, err, errBackend)
+		assert.Contains(t, err.Error(), `permission rbac.testObject.view`)
+		assert.False(t, role.CheckPermissions(ctx, &testObject{name: `fail`}, `view`))
+
+		decision := role.Decide(ctx, &testObject{name: `fail`}, `view`)
+		assert.Equal(t, Deny, decision.Effect)
+		assert.ErrorIs(t, decision.Err, errBackend)
+		assert.Contains(t, decision.Reason(), `check error:`)
+		if assert.Equal(t, 1, len(decision.Callbacks)) {
+			assert.Equal(t, errBackend.Error(), decision.Callbacks[0].Error)
+		}
+	})
+
+	t.Run(`reflect`, func(t *testing.T) {
+		perm := MustNewSimplePermission(`view`, WithCustomCheck(func(context.Context, *testObject, Permission) (bool, error) {
+			return false, errBackend
+		}))
+		_, err := perm.CheckPermissionsE(ctx, obj, `view`)
+		assert.ErrorIs(t, err, errBackend)
+		perm = MustNewSimplePermission(`view`, WithCustomCheck(func(context.Context, *testObject, Permission) (bool, int) {
+			return true, 0
+		}))
+		ok, err := perm.CheckPermissionsE(ctx, obj, `view`)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run(`manager`, func(t *testing.T) {
+		mng := RegisterObjectE(NewManager(nil), func(_ context.Context, obj *testObject, _ Permission) (bool, error) {
+			return false, errBackend
+		})
+		assert.NoError(t, mng.RegisterNewPermissions((*testObject)(nil), []string{`view`}))
+		mng.RegisterRole(ctx, MustNewRole(`viewer`, WithPermissions(`rbac.testObject.view`)))
+		ok, err := mng.CheckPermissionsE(ctx, []string{`viewer`}, obj, `view`)
+		assert.False(t, ok)
+		assert.ErrorIs(t, err, errBackend)
+	})
+}
@@ -0,0 +1,564 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidCondition if the condition expression can't be compiled
+	ErrInvalidCondition = wrapError(ErrInvalidOptionParam, `invalid condition`)
+
+	// ErrConditionType if values of the condition can't be compared or used as boolean
+	ErrConditionType = errors.New(`invalid condition value type`)
+)
+
+// Attributer provides attributes of the resource for conditions,
+// the resource fields are used by reflection otherwise
+type Attributer interface {
+	RBACAttributes() map[string]any
+}
+
+// condRoots are variables available in conditions
+var condRoots = map[string]bool{`subject`: true, `resource`: true, `ext`: true}
+
+// Condition is the compiled attribute-based expression of the permission
+//
+// The expression compares attributes of the acting subject (see WithSubject), the checked
+// resource and the request data (see WithExt) with literals and each other:
+//
+//	resource.status == "draft" && subject.department == resource.department
+//	!(resource.archived) || subject.id == resource.owner_id
+//	resource.kind in ["post", "page"] && resource.title.startsWith("draft:")
+//	resource.created_at + duration("24h") > now()
+//
+// The expression supports comparisons, boolean logic, `+` and `-` of numbers, strings and times,
+// the `in` operator of lists and maps, indexes `value[key]` and builtin functions which can be
+// called as methods: size, startsWith, endsWith, contains, lower, upper, matches (regular expression),
+// timestamp (RFC3339 or unix time), duration and now. Functions with literal arguments
+// are evaluated once by the compiler.
+//
+// Subject attributes are `id`, `account_id`, `roles` and values of RBACAttributes.
+// Resource attributes are values of RBACAttributes (see Attributer) or fields of the struct
+// by the `rbac` or `json` tag or by the name case-insensitively. Missing attributes are null.
+type Condition struct {
+	source string
+	root   condNode
+
+	// The result depends only on the resource and the subject identity
+	// and can be memoized by the decision cache
+	cacheable bool
+}
+
+// CompileCondition parses the condition expression
+func CompileCondition(expr string) (*Condition, error) {
+	root, err := parseCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Condition{source: expr, root: root, cacheable: condCacheable(root)}, nil
+}
+
+// MustCompileCondition or produce panic
+func MustCompileCondition(expr string) *Condition {
+	cond, err := CompileCondition(expr)
+	if err != nil {
+		panic(err)
+	}
+	return cond
+}
+
+// String returns the source of the condition
+func (c *Condition) String() string {
+	return c.source
+}
+
+// Evaluate the condition for the resource and the subject of the context
+func (c *Condition) Evaluate(ctx context.Context, resource any) (bool, error) {
+	value, err := c.root.eval(&condEnv{ctx: ctx, resource: resource})
+	if err != nil {
+		return false, wrapError(err, `condition `+strconv.Quote(c.source))
+	}
+	return condTruth(value)
+}
+
+// evaluate the condition in the evaluation state, fails the evaluation on error
+func (c *Condition) evaluate(st *evalState, curPerm Permission) bool {
+	if !c.cacheable {
+		st.uncacheable = true
+	}
+	ok, err := c.Evaluate(st.ctx, st.resource)
+	if err != nil {
+		st.fail(curPerm, err)
+		return false
+	}
+	return ok
+}
+
+// condCacheable returns true if the expression uses only resource attributes
+// and the subject identity which are parts of the decision cache key
+func condCacheable(node condNode) bool {
+	switch n := node.(type) {
+	case *condPath:
+		switch n.root {
+		case `resource`:
+			return true
+		case `subject`:
+			return len(n.attrs) == 1 && (n.attrs[0] == `id` || n.attrs[0] == `account_id`)
+		}
+		return false
+	case *condNot:
+		return condCacheable(n.node)
+	case *condNeg:
+		return condCacheable(n.node)
+	case *condAttr:
+		return condCacheable(n.node)
+	case *condIndex:
+		return condCacheable(n.node) && condCacheable(n.index)
+	case *condLogical:
+		return condCacheable(n.left) && condCacheable(n.right)
+	case *condCompare:
+		return condCacheable(n.left) && condCacheable(n.right)
+	case *condArith:
+		return condCacheable(n.left) && condCacheable(n.right)
+	case *condIn:
+		return condCacheable(n.item) && condCacheable(n.set)
+	case *condList:
+		return condCacheableAll(n.items)
+	case *condCall:
+		return n.fn.pure && condCacheableAll(n.args)
+	}
+	return true
+}
+
+func condCacheableAll(nodes []condNode) bool {
+	for _, node := range nodes {
+		if !condCacheable(node) {
+			return false
+		}
+	}
+	return true
+}
+
+func conditionError(src string, pos int, msg string) error {
+	return wrapError(ErrInvalidCondition, msg+` at `+strconv.Itoa(pos)+` in `+strconv.Quote(src))
+}
+
+// condEnv of the condition evaluation
+type condEnv struct {
+	ctx      context.Context
+	resource any
+}
+
+func (env *condEnv) variable(name string) any {
+	switch name {
+	case `subject`:
+		if subject := SubjectFromContext(env.ctx); subject != nil {
+			return subject
+		}
+	case `resource`:
+		return env.resource
+	case `ext`:
+		return ExtData(env.ctx)
+	}
+	return nil
+}
+
+type condNode interface {
+	eval(env *condEnv) (any, error)
+}
+
+type condLiteral struct {
+	value any
+}
+
+func (n *condLiteral) eval(*condEnv) (any, error) {
+	return n.value, nil
+}
+
+type condList struct {
+	items []condNode
+}
+
+func (n *condList) eval(env *condEnv) (any, error) {
+	list := make([]any, 0, len(n.items))
+	for _, item := range n.items {
+		value, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+type condPath struct {
+	root  string
+	attrs []string
+}
+
+func (n *condPath) eval(env *condEnv) (any, error) {
+	value := env.variable(n.root)
+	for _, attr := range n.attrs {
+		if value = attributeOf(value, attr); value == nil {
+			return nil, nil
+		}
+	}
+	return value, nil
+}
+
+// condAttr is the attribute of the calculated value
+type condAttr struct {
+	node condNode
+	name string
+}
+
+func (n *condAttr) eval(env *condEnv) (any, error) {
+	value, err := n.node.eval(env)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return attributeOf(value, n.name), nil
+}
+
+type condIndex struct {
+	node, index condNode
+}
+
+func (n *condIndex) eval(env *condEnv) (any, error) {
+	value, err := n.node.eval(env)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	index, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch key := normalizeCondValue(index).(type) {
+	case string:
+		return attributeOf(value, key), nil
+	case float64:
+		v := reflect.ValueOf(value)
+		for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array:
+			if i := int(key); float64(i) == key && i >= 0 && i < v.Len() {
+				return v.Index(i).Interface(), nil
+			}
+			return nil, nil
+		}
+	}
+	return nil, wrapError(ErrConditionType, `invalid index`)
+}
+
+type condCall struct {
+	name string
+	fn   *condFunc
+	args []condNode
+}
+
+func (n *condCall) eval(env *condEnv) (any, error) {
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	return n.fn.call(env, args)
+}
+
+type condArith struct {
+	op          string
+	left, right condNode
+}
+
+func (n *condArith) eval(env *condEnv) (any, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return condArithmetic(n.op, left, right)
+}
+
+type condNeg struct {
+	node condNode
+}
+
+func (n *condNeg) eval(env *condEnv) (any, error) {
+	value, err := n.node.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch v := normalizeCondValue(value).(type) {
+	case float64:
+		return -v, nil
+	case time.Duration:
+		return -v, nil
+	}
+	return nil, wrapError(ErrConditionType, `invalid operand of -`)
+}
+
+type condIn struct {
+	item, set condNode
+}
+
+func (n *condIn) eval(env *condEnv) (any, error) {
+	item, err := n.item.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	set, err := n.set.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return condContains(item, set)
+}
+
+type condNot struct {
+	node condNode
+}
+
+func (n *condNot) eval(env *condEnv) (any, error) {
+	value, err := n.node.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := condTruth(value)
+	return !ok, err
+}
+
+type condLogical struct {
+	op          string
+	left, right condNode
+}
+
+func (n *condLogical) eval(env *condEnv) (any, error) {
+	value, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	left, err := condTruth(value)
+	if err != nil {
+		return nil, err
+	}
+	// Short-circuit evaluation
+	if left == (n.op == `||`) {
+		return left, nil
+	}
+	if value, err = n.right.eval(env); err != nil {
+		return nil, err
+	}
+	return condTruth(value)
+}
+
+type condCompare struct {
+	op          string
+	left, right condNode
+}
+
+func (n *condCompare) eval(env *condEnv) (any, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case `==`:
+		return condEqual(left, right), nil
+	case `!=`:
+		return !condEqual(left, right), nil
+	}
+	cmp, err := condCompareValues(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case `<`:
+		return cmp < 0, nil
+	case `<=`:
+		return cmp <= 0, nil
+	case `>`:
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+// condTruth converts the value to boolean, null is false
+func condTruth(value any) (bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	}
+	return false, wrapError(ErrConditionType, `expected boolean value`)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// normalizeCondValue converts numbers to float64 and dereferences pointers
+func normalizeCondValue(value any) any {
+	switch value.(type) {
+	case nil:
+		return nil
+	case float64, string, bool, time.Time, time.Duration:
+		return value
+	}
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Type() == durationType {
+		return time.Duration(v.Int())
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	}
+	return v.Interface()
+}
+
+func condEqual(left, right any) bool {
+	left, right = normalizeCondValue(left), normalizeCondValue(right)
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	lt, rt := reflect.TypeOf(left), reflect.TypeOf(right)
+	if lt != rt {
+		return false
+	}
+	if tm, ok := left.(time.Time); ok {
+		return tm.Equal(right.(time.Time))
+	}
+	if lt.Comparable() {
+		return left == right
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func condCompareValues(left, right any) (int, error) {
+	switch l := normalizeCondValue(left).(type) {
+	case float64:
+		if r, ok := normalizeCondValue(right).(float64); ok {
+			switch {
+			case l < r:
+				return -1, nil
+			case l > r:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if r, ok := normalizeCondValue(right).(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	case time.Time:
+		if r, ok := normalizeCondValue(right).(time.Time); ok {
+			return l.Compare(r), nil
+		}
+	case time.Duration:
+		if r, ok := normalizeCondValue(right).(time.Duration); ok {
+			return cmpDuration(l, r), nil
+		}
+	}
+	return 0, wrapError(ErrConditionType, `values are not comparable`)
+}
+
+func cmpDuration(l, r time.Duration) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+// attributeOf returns the attribute of the subject, resource or nested value, nil if it's missing
+func attributeOf(value any, name string) any {
+	if subject, ok := value.(Subject); ok {
+		switch name {
+		case `id`:
+			return subject.RBACSubjectID()
+		case `account_id`:
+			return subject.RBACAccountID()
+		case `roles`:
+			return subject.RBACRoles()
+		}
+	}
+	if attrs, ok := value.(Attributer); ok {
+		return attrs.RBACAttributes()[name]
+	}
+	if attrs, ok := value.(map[string]any); ok {
+		return attrs[name]
+	}
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		if item := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key())); item.IsValid() {
+			return item.Interface()
+		}
+	case reflect.Struct:
+		if field, ok := structField(v.Type(), name); ok {
+			return v.FieldByIndex(field.Index).Interface()
+		}
+	}
+	return nil
+}
+
+// structField finds the exported field by the `rbac` or `json` tag or by the name case-insensitively
+func structField(tp reflect.Type, name string) (reflect.StructField, bool) {
+	var byName reflect.StructField
+	found := false
+	for i := 0; i < tp.NumField(); i++ {
+		field := tp.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		for _, tag := range []string{`rbac`, `json`} {
+			if tagName, _, _ := strings.Cut(field.Tag.Get(tag), `,`); tagName == name {
+				return field, true
+			}
+		}
+		if !found && strings.EqualFold(field.Name, name) {
+			byName, found = field, true
+		}
+	}
+	return byName, found
+}
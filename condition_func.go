@@ -0,0 +1,232 @@
+package rbac
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// condFunc is the builtin function of the condition expressions,
+// functions can be called as `name(a, b)` or as methods `a.name(b)`
+type condFunc struct {
+	args int
+
+	// Pure functions with literal arguments are evaluated once by the compiler
+	pure bool
+
+	// prepare arguments of the call by the compiler (optional)
+	prepare func(args []condNode) error
+
+	call func(env *condEnv, args []any) (any, error)
+}
+
+var condFunctions = map[string]*condFunc{
+	`size`: {args: 1, pure: true, call: condSize},
+	`startsWith`: {args: 2, pure: true, call: stringsFunc(`startsWith`, func(s, arg string) any {
+		return strings.HasPrefix(s, arg)
+	})},
+	`endsWith`: {args: 2, pure: true, call: stringsFunc(`endsWith`, func(s, arg string) any {
+		return strings.HasSuffix(s, arg)
+	})},
+	`contains`: {args: 2, pure: true, call: stringsFunc(`contains`, func(s, arg string) any {
+		return strings.Contains(s, arg)
+	})},
+	`lower`:     {args: 1, pure: true, call: stringFunc(`lower`, strings.ToLower)},
+	`upper`:     {args: 1, pure: true, call: stringFunc(`upper`, strings.ToUpper)},
+	`matches`:   {args: 2, pure: true, prepare: prepareMatches, call: condMatches},
+	`timestamp`: {args: 1, pure: true, call: condTimestamp},
+	`duration`:  {args: 1, pure: true, call: condDuration},
+	`now`:       {args: 0, call: func(env *condEnv, _ []any) (any, error) { return CurrentTime(env.ctx), nil }},
+}
+
+func condFuncError(name, msg string) error {
+	return wrapError(ErrConditionType, name+`: `+msg)
+}
+
+func stringFunc(name string, fn func(s string) string) func(env *condEnv, args []any) (any, error) {
+	return func(_ *condEnv, args []any) (any, error) {
+		s, ok := normalizeCondValue(args[0]).(string)
+		if !ok {
+			return nil, condFuncError(name, `expected string argument`)
+		}
+		return fn(s), nil
+	}
+}
+
+func stringsFunc(name string, fn func(s, arg string) any) func(env *condEnv, args []any) (any, error) {
+	return func(_ *condEnv, args []any) (any, error) {
+		s, ok1 := normalizeCondValue(args[0]).(string)
+		arg, ok2 := normalizeCondValue(args[1]).(string)
+		if !ok1 || !ok2 {
+			return nil, condFuncError(name, `expected string arguments`)
+		}
+		return fn(s, arg), nil
+	}
+}
+
+func condSize(_ *condEnv, args []any) (any, error) {
+	switch v := normalizeCondValue(args[0]).(type) {
+	case nil:
+		return float64(0), nil
+	case string:
+		return float64(utf8.RuneCountInString(v)), nil
+	}
+	v := reflect.ValueOf(args[0])
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), nil
+	}
+	return nil, condFuncError(`size`, `expected string, list or map argument`)
+}
+
+// prepareMatches compiles the literal regular expression once
+func prepareMatches(args []condNode) error {
+	lit, ok := args[1].(*condLiteral)
+	if !ok {
+		return nil
+	}
+	expr, ok := lit.value.(string)
+	if !ok {
+		return condFuncError(`matches`, `expected string regular expression`)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return condFuncError(`matches`, err.Error())
+	}
+	args[1] = &condLiteral{value: re}
+	return nil
+}
+
+func condMatches(_ *condEnv, args []any) (any, error) {
+	s, ok := normalizeCondValue(args[0]).(string)
+	if !ok {
+		return nil, condFuncError(`matches`, `expected string argument`)
+	}
+	switch re := args[1].(type) {
+	case *regexp.Regexp:
+		return re.MatchString(s), nil
+	case string:
+		compiled, err := regexp.Compile(re)
+		if err != nil {
+			return nil, condFuncError(`matches`, err.Error())
+		}
+		return compiled.MatchString(s), nil
+	}
+	return nil, condFuncError(`matches`, `expected string regular expression`)
+}
+
+func condTimestamp(_ *condEnv, args []any) (any, error) {
+	switch v := normalizeCondValue(args[0]).(type) {
+	case time.Time:
+		return v, nil
+	case float64:
+		return time.Unix(0, int64(v*float64(time.Second))).UTC(), nil
+	case string:
+		tm, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, condFuncError(`timestamp`, err.Error())
+		}
+		return tm, nil
+	}
+	return nil, condFuncError(`timestamp`, `expected RFC3339 string or unix time`)
+}
+
+func condDuration(_ *condEnv, args []any) (any, error) {
+	switch v := normalizeCondValue(args[0]).(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, condFuncError(`duration`, err.Error())
+		}
+		return d, nil
+	}
+	return nil, condFuncError(`duration`, `expected duration string`)
+}
+
+// condArithmetic of numbers, strings (concatenation), timestamps and durations
+func condArithmetic(op string, left, right any) (any, error) {
+	left, right = normalizeCondValue(left), normalizeCondValue(right)
+	switch l := left.(type) {
+	case float64:
+		if r, ok := right.(float64); ok {
+			if op == `+` {
+				return l + r, nil
+			}
+			return l - r, nil
+		}
+	case string:
+		if r, ok := right.(string); ok && op == `+` {
+			return l + r, nil
+		}
+	case time.Time:
+		switch r := right.(type) {
+		case time.Duration:
+			if op == `+` {
+				return l.Add(r), nil
+			}
+			return l.Add(-r), nil
+		case time.Time:
+			if op == `-` {
+				return l.Sub(r), nil
+			}
+		}
+	case time.Duration:
+		switch r := right.(type) {
+		case time.Duration:
+			if op == `+` {
+				return l + r, nil
+			}
+			return l - r, nil
+		case time.Time:
+			if op == `+` {
+				return r.Add(l), nil
+			}
+		}
+	}
+	return nil, wrapError(ErrConditionType, `invalid operands of `+op)
+}
+
+// condContains returns true if the list contains the item or the map contains the key
+func condContains(item, set any) (bool, error) {
+	if set == nil {
+		return false, nil
+	}
+	if list, ok := set.([]any); ok {
+		for _, v := range list {
+			if condEqual(item, v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	v := reflect.ValueOf(set)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return false, nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if condEqual(item, v.Index(i).Interface()) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		key, ok := normalizeCondValue(item).(string)
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			return false, wrapError(ErrConditionType, `map key must be string`)
+		}
+		return v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())).IsValid(), nil
+	}
+	return false, wrapError(ErrConditionType, `expected list or map operand of in`)
+}
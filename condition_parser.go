@@ -0,0 +1,441 @@
+package rbac
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type condTokenKind uint8
+
+const (
+	tokEOF condTokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOperator
+)
+
+type condToken struct {
+	kind  condTokenKind
+	value string
+	pos   int
+}
+
+// condOperators sorted by length to match the longest operator first
+var condOperators = []string{`&&`, `||`, `==`, `!=`, `<=`, `>=`, `<`, `>`, `!`, `(`, `)`, `[`, `]`, `.`, `,`, `+`, `-`}
+
+// tokenizeCondition splits the expression into tokens
+func tokenizeCondition(src string) ([]condToken, error) {
+	var tokens []condToken
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for ; end < len(src) && src[end] != byte(c); end++ {
+				if src[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(src) {
+				return nil, conditionError(src, i, `unclosed string`)
+			}
+			value, err := unquoteCondString(src[i+1:end], byte(c))
+			if err != nil {
+				return nil, conditionError(src, i, err.Error())
+			}
+			tokens = append(tokens, condToken{kind: tokString, value: value, pos: i})
+			i = end + 1
+		case c >= '0' && c <= '9':
+			end := i
+			for end < len(src) && (src[end] >= '0' && src[end] <= '9' || src[end] == '.' || src[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, condToken{kind: tokNumber, value: src[i:end], pos: i})
+			i = end
+		case c == '_' || unicode.IsLetter(c):
+			end := i
+			for end < len(src) && (src[end] == '_' || unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end]))) {
+				end++
+			}
+			tokens = append(tokens, condToken{kind: tokIdent, value: src[i:end], pos: i})
+			i = end
+		default:
+			op := ``
+			for _, o := range condOperators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == `` {
+				return nil, conditionError(src, i, `unexpected character `+strconv.QuoteRune(c))
+			}
+			tokens = append(tokens, condToken{kind: tokOperator, value: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, condToken{kind: tokEOF, pos: len(src)}), nil
+}
+
+func unquoteCondString(s string, quote byte) (string, error) {
+	if quote == '\'' {
+		s = strings.ReplaceAll(strings.ReplaceAll(s, `\'`, `'`), `"`, `\"`)
+	}
+	return strconv.Unquote(`"` + s + `"`)
+}
+
+// condParser is the recursive descent parser of the condition expressions
+//
+//	or       := and ('||' and)*
+//	and      := not ('&&' not)*
+//	not      := '!' not | compare
+//	compare  := additive (('==' | '!=' | '<' | '<=' | '>' | '>=' | 'in') additive)?
+//	additive := unary (('+' | '-') unary)*
+//	unary    := '-' unary | postfix
+//	postfix  := primary ('.' ident | '.' ident '(' args ')' | '[' or ']')*
+//	primary  := literal | variable | ident '(' args ')' | '[' args ']' | '(' or ')'
+//	args     := (or (',' or)*)?
+type condParser struct {
+	src    string
+	tokens []condToken
+	pos    int
+	depth  int
+}
+
+const (
+	// maxConditionLength limits the source of the condition expression
+	maxConditionLength = 4096
+	// maxConditionDepth limits the nesting of the condition expression
+	// to protect the recursive descent parser from the stack overflow
+	maxConditionDepth = 100
+)
+
+func parseCondition(src string) (condNode, error) {
+	if len(src) > maxConditionLength {
+		return nil, wrapError(ErrInvalidCondition, `expression is longer than `+strconv.Itoa(maxConditionLength)+` bytes`)
+	}
+	tokens, err := tokenizeCondition(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &condParser{src: src, tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, `unexpected `+strconv.Quote(tok.value))
+	}
+	return node, nil
+}
+
+func (p *condParser) peek() condToken {
+	return p.tokens[p.pos]
+}
+
+func (p *condParser) next() condToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept the operator token if it's the next one
+func (p *condParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOperator && tok.value == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *condParser) errorf(tok condToken, msg string) error {
+	return conditionError(p.src, tok.pos, msg)
+}
+
+// enter the nested level of the expression, the returned func leaves it
+func (p *condParser) enter() (func(), error) {
+	if p.depth >= maxConditionDepth {
+		return nil, p.errorf(p.peek(), `expression is nested deeper than `+strconv.Itoa(maxConditionDepth))
+	}
+	p.depth++
+	return func() { p.depth-- }, nil
+}
+
+func (p *condParser) parseOr() (condNode, error) {
+	leave, err := p.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(`||`) {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &condLogical{op: `||`, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *condParser) parseAnd() (condNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(`&&`) {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &condLogical{op: `&&`, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *condParser) parseNot() (condNode, error) {
+	if p.accept(`!`) {
+		leave, err := p.enter()
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &condNot{node: node}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *condParser) parseCompare() (condNode, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := p.peek(); {
+	case tok.kind == tokOperator:
+		switch tok.value {
+		case `==`, `!=`, `<`, `<=`, `>`, `>=`:
+			p.next()
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			return &condCompare{op: tok.value, left: left, right: right}, nil
+		}
+	case tok.kind == tokIdent && tok.value == `in`:
+		p.next()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &condIn{item: left, set: right}, nil
+	}
+	return left, nil
+}
+
+func (p *condParser) parseAdditive() (condNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokOperator || (tok.value != `+` && tok.value != `-`) {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &condArith{op: tok.value, left: left, right: right}
+	}
+}
+
+func (p *condParser) parseUnary() (condNode, error) {
+	if !p.accept(`-`) {
+		return p.parsePostfix()
+	}
+	leave, err := p.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	node, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if lit, ok := node.(*condLiteral); ok {
+		if value, ok := lit.value.(float64); ok {
+			return &condLiteral{value: -value}, nil
+		}
+	}
+	return &condNeg{node: node}, nil
+}
+
+func (p *condParser) parsePostfix() (condNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept(`.`):
+			tok := p.next()
+			if tok.kind != tokIdent {
+				return nil, p.errorf(tok, `expected attribute name`)
+			}
+			if p.accept(`(`) {
+				args, err := p.parseArgs(`)`)
+				if err != nil {
+					return nil, err
+				}
+				// Method call is the function call with the receiver as the first argument
+				if node, err = p.call(tok, append([]condNode{node}, args...)); err != nil {
+					return nil, err
+				}
+			} else if path, ok := node.(*condPath); ok {
+				path.attrs = append(path.attrs, tok.value)
+			} else {
+				node = &condAttr{node: node, name: tok.value}
+			}
+		case p.accept(`[`):
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(`]`) {
+				return nil, p.errorf(p.peek(), `expected ]`)
+			}
+			node = &condIndex{node: node, index: index}
+		default:
+			return node, nil
+		}
+	}
+}
+
+// parseArgs of the call or the list till the closing operator
+func (p *condParser) parseArgs(closing string) ([]condNode, error) {
+	var args []condNode
+	if p.accept(closing) {
+		return args, nil
+	}
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.accept(closing) {
+			return args, nil
+		}
+		if !p.accept(`,`) {
+			return nil, p.errorf(p.peek(), `expected , or `+closing)
+		}
+	}
+}
+
+// call of the builtin function, pure functions with literal arguments are evaluated once
+func (p *condParser) call(name condToken, args []condNode) (condNode, error) {
+	fn := condFunctions[name.value]
+	if fn == nil {
+		return nil, p.errorf(name, `unknown function `+name.value)
+	}
+	if len(args) != fn.args {
+		return nil, p.errorf(name, name.value+` expects `+strconv.Itoa(fn.args)+` arguments`)
+	}
+	if fn.prepare != nil {
+		if err := fn.prepare(args); err != nil {
+			return nil, p.errorf(name, err.Error())
+		}
+	}
+	node := &condCall{name: name.value, fn: fn, args: args}
+	if !fn.pure || !condLiterals(args) {
+		return node, nil
+	}
+	value, err := node.eval(nil)
+	if err != nil {
+		return nil, p.errorf(name, err.Error())
+	}
+	return &condLiteral{value: value}, nil
+}
+
+func condLiterals(nodes []condNode) bool {
+	for _, node := range nodes {
+		if _, ok := node.(*condLiteral); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *condParser) parsePrimary() (condNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return &condLiteral{value: tok.value}, nil
+	case tokNumber:
+		value, err := strconv.ParseFloat(strings.ReplaceAll(tok.value, `_`, ``), 64)
+		if err != nil {
+			return nil, p.errorf(tok, `invalid number `+tok.value)
+		}
+		return &condLiteral{value: value}, nil
+	case tokIdent:
+		switch tok.value {
+		case `true`:
+			return &condLiteral{value: true}, nil
+		case `false`:
+			return &condLiteral{value: false}, nil
+		case `null`, `nil`:
+			return &condLiteral{value: nil}, nil
+		}
+		if p.accept(`(`) {
+			args, err := p.parseArgs(`)`)
+			if err != nil {
+				return nil, err
+			}
+			return p.call(tok, args)
+		}
+		return p.parsePath(tok)
+	case tokOperator:
+		switch tok.value {
+		case `[`:
+			items, err := p.parseArgs(`]`)
+			if err != nil {
+				return nil, err
+			}
+			return &condList{items: items}, nil
+		case `(`:
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(`)`) {
+				return nil, p.errorf(p.peek(), `expected )`)
+			}
+			return node, nil
+		}
+	case tokEOF:
+		return nil, p.errorf(tok, `unexpected end of expression`)
+	}
+	return nil, p.errorf(tok, `unexpected `+strconv.Quote(tok.value))
+}
+
+func (p *condParser) parsePath(root condToken) (condNode, error) {
+	if !condRoots[root.value] {
+		return nil, p.errorf(root, `unknown variable `+root.value)
+	}
+	return &condPath{root: root.value}, nil
+}
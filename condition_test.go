@@ -0,0 +1,298 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testArticle struct {
+	Status     string `json:"status"`
+	Department string `rbac:"department"`
+	OwnerID    uint64
+	Archived   bool
+	Tags       map[string]string
+}
+
+type testAttrResource struct{}
+
+func (testAttrResource) RBACAttributes() map[string]any {
+	return map[string]any{`level`: 3}
+}
+
+func TestConditionParse(t *testing.T) {
+	for _, expr := range []string{
+		`true`,
+		`resource.status == "draft"`,
+		`resource.level >= 1_000 || !resource.archived && subject.id != null`,
+		`(resource.status == 'it\'s' || ext.force) && resource.a.b.c < 10.5`,
+	} {
+		_, err := CompileCondition(expr)
+		assert.NoError(t, err, expr)
+	}
+	for _, expr := range []string{
+		``,
+		`resource.status ==`,
+		`user.id == 1`,
+		`(true`,
+		`true false`,
+		`resource.`,
+		`"unclosed`,
+		`resource.id # 1`,
+		`1.2.3 == 1`,
+	} {
+		_, err := CompileCondition(expr)
+		assert.ErrorIs(t, err, ErrInvalidCondition, expr)
+	}
+	assert.Panics(t, func() { MustCompileCondition(`(`) })
+
+	// Deeply nested and oversized expressions are rejected before they exhaust the stack
+	for _, expr := range []string{
+		strings.Repeat(`(`, 100000) + `true` + strings.Repeat(`)`, 100000),
+		strings.Repeat(`!`, 1000) + `true`,
+		strings.Repeat(`-`, 1000) + `1 == 1`,
+		strings.Repeat(`[`, 1000) + strings.Repeat(`]`, 1000),
+		`true` + strings.Repeat(` && true`, 1000),
+	} {
+		_, err := CompileCondition(expr)
+		assert.ErrorIs(t, err, ErrInvalidCondition)
+	}
+	nested := strings.Repeat(`(`, 50) + `true` + strings.Repeat(`)`, 50)
+	_, err := CompileCondition(nested + ` && !!` + nested)
+	assert.NoError(t, err)
+	assert.Equal(t, `resource.status == "draft"`, MustCompileCondition(`resource.status == "draft"`).String())
+}
+
+func TestConditionEvaluate(t *testing.T) {
+	ctx := WithSubject(context.TODO(), &SimpleSubject{ID: 7, AccountID: 1,
+		Roles: []string{`editor`}, Attributes: map[string]any{`department`: `news`}})
+	ctx = WithExt(ctx, map[string]any{`force`: true})
+	article := &testArticle{Status: `draft`, Department: `news`, OwnerID: 7, Tags: map[string]string{`lang`: `en`}}
+
+	tests := []struct {
+		expr   string
+		res    any
+		result bool
+		err    error
+	}{
+		{expr: `resource.status == "draft"`, res: article, result: true},
+		{expr: `resource.Status == 'draft' && resource.department == subject.department`, res: article, result: true},
+		{expr: `resource.ownerid == subject.id && subject.account_id == 1`, res: article, result: true},
+		{expr: `resource.tags.lang == "en" && resource.tags.missing == null`, res: article, result: true},
+		{expr: `!resource.archived || false`, res: article, result: true},
+		{expr: `resource.owner_id`, res: article, result: false},
+		{expr: `ext.force`, res: nil, result: true},
+		{expr: `resource.level > 2 && resource.level <= 3.0`, res: testAttrResource{}, result: true},
+		{expr: `resource.name < "b"`, res: map[string]any{`name`: `a`}, result: true},
+		{expr: `resource.missing.value == nil`, res: article, result: true},
+		{expr: `resource.status`, res: article, err: ErrConditionType},
+		{expr: `resource.status > 1`, res: article, err: ErrConditionType},
+		{expr: `!resource.ownerid`, res: article, err: ErrConditionType},
+		// Short-circuit skips the invalid right operand
+		{expr: `false && resource.status`, res: article, result: false},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			result, err := MustCompileCondition(test.expr).Evaluate(ctx, test.res)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, result)
+		})
+	}
+
+	t.Run(`no-subject`, func(t *testing.T) {
+		result, err := MustCompileCondition(`subject.id == 7`).Evaluate(context.TODO(), article)
+		assert.NoError(t, err)
+		assert.False(t, result)
+		result, err = MustCompileCondition(`subject == null`).Evaluate(context.TODO(), article)
+		assert.NoError(t, err)
+		assert.True(t, result)
+	})
+}
+
+func TestPermissionCondition(t *testing.T) {
+	ctx := WithSubject(context.TODO(), &SimpleSubject{ID: 1, Attributes: map[string]any{`department`: `news`}})
+	role := MustNewRole(`editor`, WithPermissions(
+		MustNewResourcePermission(`edit`, (*testArticle)(nil),
+			WithCondition(`resource.status == "draft" && subject.department == resource.department`)),
+		MustNewResourcePermission(`publish`, (*testArticle)(nil), WithCondition(`resource.status > 1`)),
+		MustNewSimplePermission(`debug`, WithCondition(`ext.debug == true`)),
+	))
+
+	assert.True(t, role.CheckPermissions(ctx, &testArticle{Status: `draft`, Department: `news`}, `edit`))
+	assert.False(t, role.CheckPermissions(ctx, &testArticle{Status: `published`, Department: `news`}, `edit`))
+	assert.False(t, role.CheckPermissions(ctx, &testArticle{Status: `draft`, Department: `sport`}, `edit`))
+	assert.False(t, role.CheckPermissions(ctx, nil, `debug`))
+	assert.True(t, role.CheckPermissions(WithExt(ctx, map[string]any{`debug`: true}), nil, `debug`))
+
+	ok, err := role.CheckPermissionsE(ctx, &testArticle{}, `publish`)
+	assert.False(t, ok)
+	assert.ErrorIs(t, err, ErrConditionType)
+
+	_, err = NewResourcePermission(`edit`, (*testArticle)(nil), WithCondition(`resource.status ==`))
+	assert.ErrorIs(t, err, ErrInvalidCondition)
+	_, err = NewRole(`test`, WithCondition(`true`))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+
+	t.Run(`decision-cache`, func(t *testing.T) {
+		ctx := WithDecisionCache(ctx)
+		debug := map[string]any{`debug`: true}
+		assert.True(t, role.CheckPermissions(WithExt(ctx, debug), nil, `debug`))
+		// The condition depends on the request data, the result is not cached
+		assert.False(t, role.CheckPermissions(ctx, nil, `debug`))
+		assert.True(t, MustCompileCondition(`resource.a == subject.id`).cacheable)
+		assert.False(t, MustCompileCondition(`resource.a == subject.department`).cacheable)
+	})
+}
+
+func TestRolePatternConditions(t *testing.T) {
+	ctx := context.TODO()
+	mng := NewManager(nil)
+	assert.NoError(t, mng.RegisterNewOwningPermissions((*testArticle)(nil), []string{`view`, `edit`}))
+
+	role, err := NewRole(`editor`,
+		WithPermissions(`rbac.testArticle.view.*`, `rbac.testArticle.edit.*`),
+		WithPatternConditions(map[string]string{`rbac.testArticle.edit.*`: `resource.status == "draft"`}))
+	assert.NoError(t, err)
+	mng.RegisterRole(ctx, role)
+	role = mng.Role(ctx, `editor`)
+
+	draft, published := &testArticle{Status: `draft`}, &testArticle{Status: `published`}
+	assert.True(t, role.CheckPermissions(ctx, published, `view.all`))
+	assert.True(t, role.CheckPermissions(ctx, draft, `edit.all`))
+	assert.False(t, role.CheckPermissions(ctx, published, `edit.all`))
+
+	_, err = NewRole(`invalid`, WithPatternConditions(map[string]string{`*`: `resource.`}))
+	assert.ErrorIs(t, err, ErrInvalidCondition)
+}
+
+func TestPolicyConditions(t *testing.T) {
+	ctx := context.TODO()
+	newManager := func() *Manager {
+		mng := NewManager(nil)
+		assert.NoError(t, mng.RegisterNewOwningPermissions((*testArticle)(nil), []string{`view`, `edit`}))
+		return mng
+	}
+	const policy = `
+roles:
+  - name: editor
+    permissions: [rbac.testArticle.view.*, rbac.testArticle.edit.*]
+    conditions:
+      rbac.testArticle.edit.*: resource.status == "draft"
+`
+	mng := newManager()
+	assert.NoError(t, mng.LoadPolicy(ctx, strings.NewReader(policy)))
+	editor := mng.Role(ctx, `editor`)
+	assert.True(t, editor.CheckPermissions(ctx, &testArticle{Status: `draft`}, `edit.all`))
+	assert.False(t, editor.CheckPermissions(ctx, &testArticle{Status: `published`}, `edit.all`))
+
+	exported := mng.ExportPolicy(ctx)
+	if assert.Len(t, exported.Roles, 1) {
+		assert.Equal(t, map[string]string{`rbac.testArticle.edit.*`: `resource.status == "draft"`},
+			exported.Roles[0].Conditions)
+	}
+	imported := newManager()
+	assert.NoError(t, imported.ApplyPolicy(ctx, exported))
+	assert.False(t, imported.Role(ctx, `editor`).CheckPermissions(ctx, &testArticle{Status: `published`}, `edit.all`))
+
+	for _, data := range []string{
+		`{"roles": [{"name": "a", "permissions": ["rbac.testArticle.view.*"], "conditions": {"rbac.other.*": "true"}}]}`,
+		`{"roles": [{"name": "a", "permissions": ["rbac.testArticle.view.*"], "conditions": {"rbac.testArticle.view.*": "true &&"}}]}`,
+	} {
+		err := newManager().LoadPolicy(ctx, strings.NewReader(data))
+		assert.True(t, errors.Is(err, ErrInvalidPolicy) || errors.Is(err, ErrInvalidCondition), `error: %v`, err)
+	}
+}
+
+func TestConditionExpressions(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ctx := WithSubject(context.TODO(), NewSubject(7, 1, `editor`, `viewer`))
+	ctx = WithClock(ctx, func() time.Time { return now })
+	res := map[string]any{
+		`kind`:       `post`,
+		`title`:      `Draft: News`,
+		`tags`:       []string{`go`, `rbac`},
+		`meta`:       map[string]any{`lang`: `en`, `score`: 5},
+		`created_at`: now.Add(-time.Hour),
+		`ttl`:        30 * time.Minute,
+		`expires_at`: (*time.Time)(nil),
+	}
+	tests := []struct {
+		expr   string
+		result bool
+		err    error
+	}{
+		{expr: `resource.kind in ["post", "page"]`, result: true},
+		{expr: `"go" in resource.tags && !("java" in resource.tags)`, result: true},
+		{expr: `"lang" in resource.meta && "editor" in subject.roles`, result: true},
+		{expr: `resource.title.startsWith("Draft:") && resource.title.endsWith("News")`, result: true},
+		{expr: `resource.title.lower().contains("news") && upper(resource.kind) == "POST"`, result: true},
+		{expr: `resource.title.matches("^(?i)draft:")`, result: true},
+		{expr: `matches(resource.title, resource.kind + ".*")`, result: false},
+		{expr: `size(resource.tags) == 2 && resource.title.size() == 11 && size(resource.missing) == 0`, result: true},
+		{expr: `resource.meta["score"] - 1 >= 4 && resource.tags[1] == "rbac" && resource.tags[5] == null`, result: true},
+		{expr: `-resource.meta.score < -4`, result: true},
+		{expr: `resource.created_at < now() && resource.created_at + duration("2h") > now()`, result: true},
+		{expr: `now() - resource.created_at > resource.ttl`, result: true},
+		{expr: `resource.created_at == timestamp("2024-05-01T11:00:00Z")`, result: true},
+		{expr: `timestamp(1714564800) == now()`, result: true},
+		{expr: `resource.expires_at == null || resource.expires_at > now()`, result: true},
+		{expr: `resource.ttl < duration("1h") && -resource.ttl < duration("0s")`, result: true},
+		{expr: `resource.kind in resource.title`, err: ErrConditionType},
+		{expr: `resource.kind + 1 == 1`, err: ErrConditionType},
+		{expr: `resource.tags.startsWith("go")`, err: ErrConditionType},
+		{expr: `resource.created_at > "2024-01-01"`, err: ErrConditionType},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			result, err := MustCompileCondition(test.expr).Evaluate(ctx, res)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, result)
+		})
+	}
+
+	for _, expr := range []string{
+		`unknown(resource.a)`,
+		`size()`,
+		`resource.a.size(1)`,
+		`resource.a.matches("[a-")`,
+		`timestamp("yesterday") < now()`,
+		`duration("1 day") > duration("1h")`,
+		`resource.a in [1, 2`,
+		`resource.a[1`,
+	} {
+		_, err := CompileCondition(expr)
+		assert.ErrorIs(t, err, ErrInvalidCondition, expr)
+		assert.ErrorIs(t, err, ErrInvalidOptionParam, expr)
+	}
+
+	// Pure functions with literal arguments are evaluated by the compiler
+	_, ok := MustCompileCondition(`timestamp("2024-05-01T11:00:00Z")`).root.(*condLiteral)
+	assert.True(t, ok)
+	assert.False(t, MustCompileCondition(`resource.created_at < now()`).cacheable)
+}
+
+func TestConditionDecision(t *testing.T) {
+	ctx := context.TODO()
+	role := MustNewRole(`editor`, WithPermissions(
+		MustNewSimplePermission(`edit`, WithCondition(`resource.title.startsWith("a")`)),
+	))
+	decision := role.Decide(ctx, map[string]any{`title`: 1}, `edit`)
+	assert.False(t, decision.Allowed())
+	assert.ErrorIs(t, decision.Err, ErrConditionType)
+	assert.Contains(t, decision.Reason(), `startsWith: expected string arguments`)
+
+	_, err := NewSimplePermission(`edit`, WithCondition(`resource.title.startsWith(`))
+	assert.ErrorIs(t, err, ErrInvalidOptionParam)
+}
@@ -0,0 +1,73 @@
+package rbac
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	subjectCtxKey struct{}
+	rolesCtxKey   struct{}
+	extDataCtxKey struct{}
+	clockCtxKey   struct{}
+)
+
+// WithSubject puts the subject acting in the context
+func WithSubject(ctx context.Context, subject Subject) context.Context {
+	return context.WithValue(ctx, subjectCtxKey{}, subject)
+}
+
+// SubjectFromContext returns the subject acting in the context or nil
+func SubjectFromContext(ctx context.Context) Subject {
+	if ctx == nil {
+		return nil
+	}
+	subject, _ := ctx.Value(subjectCtxKey{}).(Subject)
+	return subject
+}
+
+// WithRoles puts the resolved roles of the acting subject in the context
+func WithRoles(ctx context.Context, roles ...Role) context.Context {
+	return context.WithValue(ctx, rolesCtxKey{}, roles)
+}
+
+// RolesFromContext returns the roles of the acting subject from the context or nil
+func RolesFromContext(ctx context.Context) []Role {
+	if ctx == nil {
+		return nil
+	}
+	roles, _ := ctx.Value(rolesCtxKey{}).([]Role)
+	return roles
+}
+
+// WithExt puts additional user data of the request in the context
+//
+// The data is available in the check callbacks by ExtData
+// and doesn't depend on the checked permission in opposite to Permission.Ext.
+func WithExt(ctx context.Context, data any) context.Context {
+	return context.WithValue(ctx, extDataCtxKey{}, data)
+}
+
+// ExtData returns additional user data from the context or nil
+func ExtData(ctx context.Context) any {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Value(extDataCtxKey{})
+}
+
+// WithClock puts the clock in the context which is used by time-bound roles and permissions
+// (see WithValidity, WithSchedule) and the `now()` function of conditions instead of time.Now
+func WithClock(ctx context.Context, clock func() time.Time) context.Context {
+	return context.WithValue(ctx, clockCtxKey{}, clock)
+}
+
+// CurrentTime returns the time by the clock of the context or the current time
+func CurrentTime(ctx context.Context) time.Time {
+	if ctx != nil {
+		if clock, _ := ctx.Value(clockCtxKey{}).(func() time.Time); clock != nil {
+			return clock()
+		}
+	}
+	return time.Now()
+}
@@ -0,0 +1,40 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextHelpers(t *testing.T) {
+	ctx := context.TODO()
+	assert.Nil(t, SubjectFromContext(ctx))
+	assert.Nil(t, RolesFromContext(ctx))
+	assert.Nil(t, ExtData(ctx))
+
+	subject := NewSubject(1, 10, `viewer`)
+	viewer := MustNewRole(`viewer`)
+	ctx = WithExt(WithRoles(WithSubject(ctx, subject), viewer), `debug`)
+	assert.Equal(t, subject, SubjectFromContext(ctx))
+	assert.Equal(t, []Role{viewer}, RolesFromContext(ctx))
+	assert.Equal(t, `debug`, ExtData(ctx))
+}
+
+func TestContextCallback(t *testing.T) {
+	ctx := context.TODO()
+	mng := RegisterObject(NewManager(nil), func(ctx context.Context, obj *testObject, _ Permission) bool {
+		subject := SubjectFromContext(ctx)
+		return subject != nil && subject.RBACSubjectID() == 1 && ExtData(ctx) == obj.name
+	})
+	assert.NoError(t, mng.RegisterNewPermissions((*testObject)(nil), []string{`view`}))
+	mng.RegisterRole(ctx, MustNewRole(`viewer`, WithPermissions(`rbac.testObject.view`)))
+
+	obj := &testObject{name: `test`}
+	assert.True(t, mng.Check(WithExt(ctx, `test`), NewSubject(1, 10, `viewer`), obj, `view`).Allowed())
+	assert.False(t, mng.Check(WithExt(ctx, `other`), NewSubject(1, 10, `viewer`), obj, `view`).Allowed())
+	assert.False(t, mng.Check(WithExt(ctx, `test`), NewSubject(2, 10, `viewer`), obj, `view`).Allowed())
+
+	// The subject of the context is used if it's not defined
+	assert.True(t, mng.Check(WithExt(WithSubject(ctx, NewSubject(1, 10, `viewer`)), `test`), nil, obj, `view`).Allowed())
+}
@@ -0,0 +1,216 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// CallbackResult describes the custom check callback executed during the decision
+type CallbackResult struct {
+	// Permission name which callback was executed
+	Permission string `json:"permission"`
+
+	// Callback function name
+	Callback string `json:"callback,omitempty"`
+
+	// Result of the callback
+	Result bool `json:"result"`
+
+	// Error of the callback if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// Decision of the permission check with explanation of the result
+type Decision struct {
+	// Effect of the decision (Allow, Deny or NotApplicable if nothing matched)
+	Effect Effect
+
+	// Permission which defined the effect of the decision
+	Permission Permission
+
+	// RolePath from the checked role through the child roles to the matched permission
+	RolePath []string
+
+	// Callbacks executed during the check in order of execution
+	Callbacks []CallbackResult
+
+	// Resource name of the checked object
+	Resource string
+
+	// Patterns which were tried to match
+	Patterns []string
+
+	// Err of the check, the decision with error is always Deny
+	Err error
+}
+
+// Allowed returns true if the decision grants access
+func (d Decision) Allowed() bool {
+	return d.Effect == Allow
+}
+
+// PermissionName returns name of the matched permission or empty string
+func (d Decision) PermissionName() string {
+	if d.Permission == nil {
+		return ``
+	}
+	return d.Permission.Name()
+}
+
+// Reason returns human readable explanation of the decision
+func (d Decision) Reason() string {
+	if d.Err != nil {
+		return `check error: ` + d.Err.Error()
+	}
+	switch d.Effect {
+	case Allow:
+		return `allowed by permission ` + d.PermissionName() + d.roleReason()
+	case Deny:
+		return `denied by permission ` + d.PermissionName() + d.roleReason()
+	}
+	return `no permission matches ` + strings.Join(d.Patterns, `, `)
+}
+
+func (d Decision) roleReason() string {
+	if len(d.RolePath) == 0 {
+		return ``
+	}
+	return ` of role ` + strings.Join(d.RolePath, ` > `)
+}
+
+// String returns decision in the logfmt like form
+func (d Decision) String() string {
+	var buf strings.Builder
+	buf.WriteString(`effect=` + d.Effect.String())
+	if d.Permission != nil {
+		buf.WriteString(` permission=` + d.PermissionName())
+	}
+	if len(d.RolePath) > 0 {
+		buf.WriteString(` roles=` + strings.Join(d.RolePath, `>`))
+	}
+	if d.Resource != `` {
+		buf.WriteString(` resource=` + d.Resource)
+	}
+	buf.WriteString(` patterns=[` + strings.Join(d.Patterns, `,`) + `]`)
+	if len(d.Callbacks) > 0 {
+		buf.WriteString(` callbacks=[`)
+		for i, cb := range d.Callbacks {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(cb.Permission + `:` + cb.Callback + `=` + strconv.FormatBool(cb.Result))
+		}
+		buf.WriteByte(']')
+	}
+	if d.Err != nil {
+		buf.WriteString(` error=` + strconv.Quote(d.Err.Error()))
+	}
+	return buf.String()
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Decision) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Effect     string           `json:"effect"`
+		Permission string           `json:"permission,omitempty"`
+		RolePath   []string         `json:"role_path,omitempty"`
+		Callbacks  []CallbackResult `json:"callbacks,omitempty"`
+		Resource   string           `json:"resource,omitempty"`
+		Patterns   []string         `json:"patterns"`
+		Reason     string           `json:"reason"`
+		Error      string           `json:"error,omitempty"`
+	}{
+		Effect:     d.Effect.String(),
+		Permission: d.PermissionName(),
+		RolePath:   d.RolePath,
+		Callbacks:  d.Callbacks,
+		Resource:   d.Resource,
+		Patterns:   d.Patterns,
+		Reason:     d.Reason(),
+		Error:      errorString(d.Err),
+	})
+}
+
+// decisionTrace collects the details of the check execution
+type decisionTrace struct {
+	callbacks []CallbackResult
+}
+
+type decisionTraceKey struct{}
+
+func withDecisionTrace(ctx context.Context, trace *decisionTrace) context.Context {
+	return context.WithValue(ctx, decisionTraceKey{}, trace)
+}
+
+func decisionTraceFromContext(ctx context.Context) *decisionTrace {
+	if ctx == nil {
+		return nil
+	}
+	trace, _ := ctx.Value(decisionTraceKey{}).(*decisionTrace)
+	return trace
+}
+
+// traceCallback registers the result of the callback if the decision is traced
+func traceCallback(ctx context.Context, perm Permission, callback string, result bool, err error) {
+	if trace := decisionTraceFromContext(ctx); trace != nil {
+		trace.callbacks = append(trace.callbacks, CallbackResult{
+			Permission: perm.Name(),
+			Callback:   callback,
+			Result:     result && err == nil,
+			Error:      errorString(err),
+		})
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ``
+	}
+	return err.Error()
+}
+
+// Decide evaluates the permissions (or roles) with the combining algorithm and explains the result
+//
+// If some check callback fails the decision is Deny with the error of the callback.
+func Decide(ctx context.Context, alg CombiningAlgorithm, perms []Permission, resource any, patterns ...string) Decision {
+	decision := Decision{
+		Effect:   NotApplicable,
+		Resource: GetResName(resource),
+		Patterns: patterns,
+	}
+	if len(patterns) == 0 {
+		return decision
+	}
+	trace := &decisionTrace{}
+	st := newEvalState(withDecisionTrace(ctx, trace), resource, patterns)
+	m, ok := combineMatches(alg, func(fn matchFunc) bool {
+		for _, perm := range perms {
+			if perm != nil && !visitMatches(st, perm, fn) {
+				return false
+			}
+		}
+		return true
+	})
+	if st.err != nil {
+		decision.Effect = Deny
+		decision.Err = st.err
+	} else if ok {
+		decision.Effect = m.effect
+		decision.Permission = m.perm
+		decision.RolePath = m.path
+	}
+	decision.Callbacks = trace.callbacks
+	return decision
+}
+
+// loadErrorDecision denies access if roles of the check can't be loaded
+func loadErrorDecision(resource any, patterns []string, err error) Decision {
+	return Decision{
+		Effect:   Deny,
+		Resource: GetResName(resource),
+		Patterns: patterns,
+		Err:      wrapError(err, `load roles`),
+	}
+}
@@ -0,0 +1,121 @@
+package rbac
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type decisionCacheCtxKey struct{}
+
+// decisionCache memoizes results of the role checks in the scope of the single request
+type decisionCache struct {
+	mx      sync.Mutex
+	results map[decisionKey]Permission
+	bound   map[boundRolesKey][]string
+}
+
+// boundRolesKey identifies the roles bound to the subject in the bindings of the manager
+type boundRolesKey struct {
+	bindings *Bindings
+	tenant   string
+	subject  uint64
+}
+
+type decisionKey struct {
+	tenant   string
+	role     string
+	resource any
+	subject  [2]uint64
+	patterns string
+}
+
+// WithDecisionCache puts the cache of the check results in the context
+//
+// Results of CheckPermissions and CheckedPermissions of the roles are memoized by the tenant and the role name,
+// the resource identity (pointer or comparable scalar value), the acting subject and the set of patterns.
+// The cache must be scoped by the single request: changes of the roles and the resources are not tracked.
+// Permissions with impure callbacks can be excluded by WithoutDecisionCache, failed checks are not cached.
+// Roles bound to the subjects (see Bindings.SubjectRoles) are memoized as well.
+func WithDecisionCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, decisionCacheCtxKey{}, &decisionCache{results: map[decisionKey]Permission{}})
+}
+
+func decisionCacheFromContext(ctx context.Context) *decisionCache {
+	if ctx == nil {
+		return nil
+	}
+	cache, _ := ctx.Value(decisionCacheCtxKey{}).(*decisionCache)
+	return cache
+}
+
+// key of the check, returns false if the resource can't be identified
+func (c *decisionCache) key(ctx context.Context, role string, resource any, patterns []string) (decisionKey, bool) {
+	res, ok := resourceIdentity(resource)
+	if !ok {
+		return decisionKey{}, false
+	}
+	key := decisionKey{tenant: TenantFromContext(ctx), role: role, resource: res, patterns: normalizePatterns(patterns)}
+	if subject := SubjectFromContext(ctx); subject != nil {
+		key.subject = [2]uint64{subject.RBACSubjectID(), subject.RBACAccountID()}
+	}
+	return key, true
+}
+
+func (c *decisionCache) get(key decisionKey) (Permission, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	perm, ok := c.results[key]
+	return perm, ok
+}
+
+func (c *decisionCache) set(key decisionKey, perm Permission) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.results[key] = perm
+}
+
+func (c *decisionCache) boundRoles(key boundRolesKey) ([]string, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	names, ok := c.bound[key]
+	return names, ok
+}
+
+func (c *decisionCache) setBoundRoles(key boundRolesKey, names []string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.bound == nil {
+		c.bound = make(map[boundRolesKey][]string)
+	}
+	c.bound[key] = names
+}
+
+// resourceIdentity returns the comparable identity of the resource,
+// false for values which can't be identified (structs, slices, maps)
+func resourceIdentity(resource any) (any, bool) {
+	if resource == nil {
+		return nil, true
+	}
+	switch reflect.ValueOf(resource).Kind() {
+	case reflect.Pointer, reflect.UnsafePointer, reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		// The pointer is compared by the address and keeps the resource alive while the cache is used
+		return resource, true
+	}
+	return nil, false
+}
+
+// normalizePatterns returns the sorted set of patterns as the single string
+func normalizePatterns(patterns []string) string {
+	if len(patterns) == 1 {
+		return patterns[0]
+	}
+	list := slices.Clone(patterns)
+	slices.Sort(list)
+	return strings.Join(slices.Compact(list), "\n")
+}
@@ -0,0 +1,72 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisionCache(t *testing.T) {
+	var (
+		calls    int
+		failWith error
+		ctx      = WithDecisionCache(context.TODO())
+	)
+	check := func(ctx context.Context, obj *testObject, perm Permission) (bool, error) {
+		calls++
+		return obj.name == `allowed`, failWith
+	}
+	role := MustNewRole(`user`, WithPermissions(
+		MustNewResourcePermission(`view`, &testObject{}, WithCheckE(check)),
+		MustNewResourcePermission(`edit`, &testObject{}, WithCheckE(check), WithoutDecisionCache),
+	))
+	allowed, denied := &testObject{name: `allowed`}, &testObject{name: `denied`}
+
+	assert.True(t, role.CheckPermissions(ctx, allowed, `view`, `list`))
+	assert.True(t, role.CheckPermissions(ctx, allowed, `list`, `view`, `view`))
+	assert.Equal(t, 1, calls)
+	assert.False(t, role.CheckPermissions(ctx, denied, `view`))
+	assert.False(t, role.CheckPermissions(ctx, denied, `view`))
+	assert.Equal(t, 2, calls)
+
+	// Another subject of the same request
+	assert.True(t, role.CheckPermissions(WithSubject(ctx, NewSubject(1, 1)), allowed, `view`))
+	assert.Equal(t, 3, calls)
+
+	// Without context cache and with impure callbacks
+	assert.True(t, role.CheckPermissions(context.TODO(), allowed, `view`))
+	assert.Equal(t, 4, calls)
+	assert.True(t, role.CheckPermissions(ctx, allowed, `edit`))
+	assert.True(t, role.CheckPermissions(ctx, allowed, `edit`))
+	assert.Equal(t, 6, calls)
+
+	// Failed checks are not cached
+	failWith = errors.New(`failed`)
+	_, err := role.CheckPermissionsE(ctx, denied, `view`, `read`)
+	assert.Error(t, err)
+	failWith = nil
+	_, err = role.CheckPermissionsE(ctx, denied, `view`, `read`)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, calls)
+
+	assert.Error(t, WithoutDecisionCache(&role))
+}
+
+func TestResourceIdentity(t *testing.T) {
+	obj := &testObject{}
+	id, ok := resourceIdentity(obj)
+	assert.True(t, ok)
+	assert.Equal(t, any(obj), id)
+	_, ok = resourceIdentity(`document`)
+	assert.True(t, ok)
+	_, ok = resourceIdentity(nil)
+	assert.True(t, ok)
+	_, ok = resourceIdentity(testObject{})
+	assert.False(t, ok)
+	_, ok = resourceIdentity([]int{1})
+	assert.False(t, ok)
+
+	assert.Equal(t, "a\nb", normalizePatterns([]string{`b`, `a`, `b`}))
+}
@@ -0,0 +1,95 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleDecide(t *testing.T) {
+	ctx := context.TODO()
+	obj := &testObject{name: `test`}
+	viewer := MustNewRole(`viewer`, WithPermissions(
+		MustNewResourcePermission(`view`, (*testObject)(nil), WithCustomCheck(testCustomCallback)),
+	))
+	restricted := MustNewRole(`restricted`, WithPermissions(
+		MustNewResourcePermission(`delete`, (*testObject)(nil), WithEffect(Deny)),
+	))
+	role := MustNewRole(`admin`, WithChildRoles(viewer, restricted), WithPermissions(
+		MustNewResourcePermission(`delete`, (*testObject)(nil)),
+	))
+
+	t.Run(`allow`, func(t *testing.T) {
+		decision := role.Decide(ctx, obj, `view`)
+		assert.True(t, decision.Allowed())
+		assert.Equal(t, Allow, decision.Effect)
+		assert.Equal(t, `rbac.testObject.view`, decision.PermissionName())
+		assert.Equal(t, []string{`admin`, `viewer`}, decision.RolePath)
+		assert.Equal(t, `rbac.testObject`, decision.Resource)
+		assert.Equal(t, []string{`view`}, decision.Patterns)
+		if assert.Equal(t, 1, len(decision.Callbacks)) {
+			assert.Equal(t, `rbac.testObject.view`, decision.Callbacks[0].Permission)
+			assert.Equal(t, `github.com/demdxx/rbac.testCustomCallback`, decision.Callbacks[0].Callback)
+			assert.True(t, decision.Callbacks[0].Result)
+		}
+		assert.Equal(t, `allowed by permission rbac.testObject.view of role admin > viewer`, decision.Reason())
+	})
+
+	t.Run(`deny`, func(t *testing.T) {
+		decision := role.Decide(ctx, obj, `delete`)
+		assert.False(t, decision.Allowed())
+		assert.Equal(t, Deny, decision.Effect)
+		assert.Equal(t, []string{`admin`, `restricted`}, decision.RolePath)
+		assert.Equal(t, `effect=deny permission=rbac.testObject.delete roles=admin>restricted `+
+			`resource=rbac.testObject patterns=[delete]`, decision.String())
+	})
+
+	t.Run(`not-applicable`, func(t *testing.T) {
+		decision := role.Decide(ctx, obj, `update`)
+		assert.Equal(t, NotApplicable, decision.Effect)
+		assert.Nil(t, decision.Permission)
+		assert.Equal(t, `no permission matches update`, decision.Reason())
+		assert.Equal(t, NotApplicable, role.Decide(ctx, obj).Effect)
+	})
+
+	t.Run(`json`, func(t *testing.T) {
+		data, err := json.Marshal(role.Decide(ctx, &testObject{name: `other`}, `view`))
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"effect": "not-applicable",
+			"callbacks": [{
+				"permission": "rbac.testObject.view",
+				"callback": "github.com/demdxx/rbac.testCustomCallback",
+				"result": false
+			}],
+			"resource": "rbac.testObject",
+			"patterns": ["view"],
+			"reason": "no permission matches view"
+		}`, string(data))
+	})
+}
+
+func TestManagerDecide(t *testing.T) {
+	ctx := context.TODO()
+	obj := &testObject{name: `test`}
+	mng := NewManager(nil)
+	assert.NoError(t, mng.RegisterNewPermissions((*testObject)(nil), []string{`view`, `delete`}))
+	mng.RegisterRole(ctx,
+		MustNewRole(`viewer`, WithPermissions(`rbac.testObject.view`)),
+		MustNewRole(`editor`, WithPermissions(`rbac.testObject.*`)),
+		MustNewRole(`guest`, WithPermissions(`!rbac.testObject.delete`)),
+	)
+
+	decision := mng.Decide(ctx, []string{`viewer`, `editor`}, obj, `delete`)
+	assert.True(t, decision.Allowed())
+	assert.Equal(t, []string{`editor`}, decision.RolePath)
+
+	decision = mng.Decide(ctx, []string{`editor`, `guest`}, obj, `delete`)
+	assert.Equal(t, Deny, decision.Effect)
+	assert.Equal(t, []string{`guest`}, decision.RolePath)
+
+	assert.Equal(t, NotApplicable, mng.Decide(ctx, nil, obj, `view`).Effect)
+	assert.True(t, NewDummyPermission(`dummy`, true).Decide(ctx, obj, `view`).Allowed())
+}
@@ -27,3 +27,15 @@ func (d *dummy) CheckedPermissions(_ context.Context, _ any, _ ...string) Permis
 	}
 	return nil
 }
+
+func (d *dummy) CheckPermissionsE(_ context.Context, _ any, _ ...string) (bool, error) {
+	return d.allow, nil
+}
+
+func (d *dummy) CheckedPermissionsE(ctx context.Context, resource any, patterns ...string) (Permission, error) {
+	return d.CheckedPermissions(ctx, resource, patterns...), nil
+}
+
+func (d *dummy) Decide(ctx context.Context, resource any, patterns ...string) Decision {
+	return Decide(ctx, DefaultCombiningAlgorithm, []Permission{d}, resource, patterns...)
+}
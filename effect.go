@@ -0,0 +1,163 @@
+package rbac
+
+import "context"
+
+// Effect of the permission which is applied if the permission matches the check
+type Effect int
+
+const (
+	// Allow access to the resource if the permission matches
+	Allow Effect = iota
+
+	// Deny access to the resource if the permission matches
+	Deny
+
+	// NotApplicable is the effect of the decision if no permission matches the check
+	NotApplicable
+)
+
+// String returns name of the effect
+func (e Effect) String() string {
+	switch e {
+	case Allow:
+		return `allow`
+	case Deny:
+		return `deny`
+	case NotApplicable:
+		return `not-applicable`
+	}
+	return `unknown`
+}
+
+// CombiningAlgorithm defines how to resolve the result
+// if the check matches several permissions with different effects
+type CombiningAlgorithm int
+
+const (
+	// DenyOverrides denies access if any of matched permissions denies it
+	DenyOverrides CombiningAlgorithm = iota + 1
+
+	// AllowOverrides allows access if any of matched permissions allows it
+	AllowOverrides
+
+	// FirstApplicable applies the effect of the first matched permission
+	FirstApplicable
+)
+
+// DefaultCombiningAlgorithm is used if the algorithm is not defined for the role or manager
+const DefaultCombiningAlgorithm = DenyOverrides
+
+// String returns name of the algorithm
+func (alg CombiningAlgorithm) String() string {
+	switch alg {
+	case DenyOverrides:
+		return `deny-overrides`
+	case AllowOverrides:
+		return `allow-overrides`
+	case FirstApplicable:
+		return `first-applicable`
+	}
+	return `unknown`
+}
+
+// ParseCombiningAlgorithm by name
+func ParseCombiningAlgorithm(name string) (CombiningAlgorithm, error) {
+	for _, alg := range []CombiningAlgorithm{DenyOverrides, AllowOverrides, FirstApplicable} {
+		if alg.String() == name {
+			return alg, nil
+		}
+	}
+	return 0, wrapError(ErrInvalidOptionParam, `unknown combining algorithm `+name)
+}
+
+func (alg CombiningAlgorithm) valid() bool {
+	return alg >= DenyOverrides && alg <= FirstApplicable
+}
+
+// PermissionEffect returns effect of the permission, Allow by default
+func PermissionEffect(perm Permission) Effect {
+	type effector interface {
+		Effect() Effect
+	}
+	if e, ok := perm.(effector); ok {
+		return e.Effect()
+	}
+	return Allow
+}
+
+// deniedPermission inverts the effect of the wrapped permission
+type deniedPermission struct {
+	perm Permission
+}
+
+// Name of the wrapped permission
+func (p *deniedPermission) Name() string { return p.perm.Name() }
+
+// Description of the wrapped permission
+func (p *deniedPermission) Description() string { return p.perm.Description() }
+
+// ChildPermissions of the wrapped permission
+func (p *deniedPermission) ChildPermissions() []Permission { return p.perm.ChildPermissions() }
+
+// Permission returns permission by name
+func (p *deniedPermission) Permission(name string) Permission { return p.perm.Permission(name) }
+
+// Permissions returns list of permissions by pattern
+func (p *deniedPermission) Permissions(patterns ...string) []Permission {
+	return p.perm.Permissions(patterns...)
+}
+
+// HasPermission returns true if permission has child permission
+func (p *deniedPermission) HasPermission(patterns ...string) bool {
+	return p.perm.HasPermission(patterns...)
+}
+
+// MatchPermissionPattern returns true if permission matches any of the patterns
+func (p *deniedPermission) MatchPermissionPattern(patterns ...string) bool {
+	return p.perm.MatchPermissionPattern(patterns...)
+}
+
+// Ext returns additional user data
+func (p *deniedPermission) Ext() any { return p.perm.Ext() }
+
+// Effect of the permission is always Deny
+func (p *deniedPermission) Effect() Effect { return Deny }
+
+// CheckPermissions always returns false as deny permission never grants access
+func (p *deniedPermission) CheckPermissions(_ context.Context, _ any, patterns ...string) bool {
+	if len(patterns) == 0 {
+		panic(ErrInvalidCheckParams)
+	}
+	return false
+}
+
+// CheckPermissionsE always returns false as deny permission never grants access
+func (p *deniedPermission) CheckPermissionsE(_ context.Context, _ any, patterns ...string) (bool, error) {
+	if len(patterns) == 0 {
+		return false, ErrInvalidCheckParams
+	}
+	return false, nil
+}
+
+// CheckedPermissions always returns nil as deny permission never grants access
+func (p *deniedPermission) CheckedPermissions(_ context.Context, _ any, _ ...string) Permission {
+	return nil
+}
+
+// CheckedPermissionsE always returns nil as deny permission never grants access
+func (p *deniedPermission) CheckedPermissionsE(_ context.Context, _ any, patterns ...string) (Permission, error) {
+	if len(patterns) == 0 {
+		return nil, ErrInvalidCheckParams
+	}
+	return nil, nil
+}
+
+func (p *deniedPermission) visitMatches(st *evalState, fn matchFunc) bool {
+	denying := st.denying
+	st.denying = true
+	defer func() { st.denying = denying }()
+	return visitMatches(st, p.perm, func(m permissionMatch) bool {
+		m.effect = Deny
+		return fn(m)
+	})
+}
@@ -0,0 +1,118 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDenyPermission(t *testing.T) {
+	ctx := context.TODO()
+	obj := &testObject{name: `test`}
+
+	deny := MustNewResourcePermission(`delete`, (*testObject)(nil), WithEffect(Deny))
+	assert.Equal(t, Deny, deny.Effect())
+	assert.Equal(t, Deny, PermissionEffect(deny))
+	assert.False(t, deny.CheckPermissions(ctx, obj, `delete`))
+	assert.Nil(t, deny.CheckedPermissions(ctx, obj, `delete`))
+
+	allow := MustNewResourcePermission(`delete`, (*testObject)(nil))
+	assert.Equal(t, Allow, PermissionEffect(allow))
+
+	tests := []struct {
+		name     string
+		alg      CombiningAlgorithm
+		perms    []any
+		expected bool
+	}{
+		{name: `deny-overrides`, alg: DenyOverrides, perms: []any{allow, deny}, expected: false},
+		{name: `deny-overrides-reverse`, alg: DenyOverrides, perms: []any{deny, allow}, expected: false},
+		{name: `allow-overrides`, alg: AllowOverrides, perms: []any{deny, allow}, expected: true},
+		{name: `first-applicable-allow`, alg: FirstApplicable, perms: []any{allow, deny}, expected: true},
+		{name: `first-applicable-deny`, alg: FirstApplicable, perms: []any{deny, allow}, expected: false},
+		{name: `only-deny`, alg: AllowOverrides, perms: []any{deny}, expected: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			role := MustNewRole(`test`, WithCombiningAlgorithm(test.alg), WithPermissions(test.perms...))
+			assert.Equal(t, test.expected, role.CheckPermissions(ctx, obj, `delete`))
+		})
+	}
+
+	t.Run(`child-role`, func(t *testing.T) {
+		role := MustNewRole(`test`,
+			WithPermissions(allow),
+			WithChildRoles(MustNewRole(`restricted`, WithPermissions(deny))),
+		)
+		assert.False(t, role.CheckPermissions(ctx, obj, `delete`))
+
+		role = MustNewRole(`test`, WithCombiningAlgorithm(AllowOverrides),
+			WithPermissions(allow),
+			WithChildRoles(MustNewRole(`restricted`, WithPermissions(deny))),
+		)
+		assert.True(t, role.CheckPermissions(ctx, obj, `delete`))
+	})
+}
+
+func TestDenyPermissionPreload(t *testing.T) {
+	ctx := context.TODO()
+	obj := &testObject{name: `test`}
+	mng := NewManager(nil)
+	assert.NoError(t, mng.RegisterNewOwningPermissions((*testObject)(nil), []string{`view`, `delete`}))
+
+	mng.RegisterRole(ctx, MustNewRole(`admin`, WithPermissions(`rbac.testObject.*.all`, `!rbac.testObject.delete.*`)))
+	role := mng.Role(ctx, `admin`)
+	if assert.NotNil(t, role) {
+		assert.Equal(t, 5, len(role.Permissions()))
+		assert.True(t, role.CheckPermissions(ctx, obj, `view.all`))
+		assert.False(t, role.CheckPermissions(ctx, obj, `delete.all`))
+		assert.False(t, role.CheckPermissions(ctx, obj, `delete.*`))
+		assert.Nil(t, role.CheckedPermissions(ctx, obj, `delete.all`))
+	}
+}
+
+func TestDenyPermissionPreloadOrder(t *testing.T) {
+	ctx := context.TODO()
+	mng := NewManager(nil)
+	mng.RegisterPermission(MustNewSimplePermission(`post.view`), MustNewSimplePermission(`post.delete.own`),
+		MustNewSimplePermission(`post.delete.any`))
+
+	// Preloaded permissions keep the declaration order of the patterns
+	mng.RegisterRole(ctx, MustNewRole(`moderator`, WithCombiningAlgorithm(FirstApplicable),
+		WithPermissions(`!post.delete.*`, `post.**`)))
+	mng.RegisterRole(ctx, MustNewRole(`owner`, WithCombiningAlgorithm(FirstApplicable),
+		WithPermissions(`post.**`, `!post.delete.*`)))
+	moderator, owner := mng.Role(ctx, `moderator`), mng.Role(ctx, `owner`)
+	assert.False(t, moderator.CheckPermissions(ctx, nil, `post.delete.any`))
+	assert.True(t, moderator.CheckPermissions(ctx, nil, `post.view`))
+	assert.True(t, owner.CheckPermissions(ctx, nil, `post.delete.any`))
+
+	// Denied permissions are not granted by the role
+	assert.True(t, moderator.HasPermission(`post.view`))
+	assert.True(t, moderator.HasPermission(`post.delete.*`))
+	assert.False(t, MustNewRole(`restricted`, WithPermissions(
+		MustNewSimplePermission(`post.delete.any`, WithEffect(Deny)))).HasPermission(`post.delete.*`))
+}
+
+func TestManagerCombiningAlgorithm(t *testing.T) {
+	ctx := context.TODO()
+	obj := &testObject{name: `test`}
+	mng := NewManager(nil, WithCombiningAlgorithm(AllowOverrides))
+	assert.Equal(t, AllowOverrides, mng.CombiningAlgorithm())
+	assert.Equal(t, DenyOverrides, NewManager(nil).CombiningAlgorithm())
+	assert.Panics(t, func() { NewManager(nil, WithEffect(Deny)) })
+	assert.NoError(t, mng.RegisterNewPermissions((*testObject)(nil), []string{`view`}))
+
+	mng.RegisterRole(ctx, MustNewRole(`viewer`, WithPermissions(`rbac.testObject.view`, `!rbac.testObject.view`)))
+	assert.True(t, mng.Role(ctx, `viewer`).CheckPermissions(ctx, obj, `view`))
+}
+
+func TestEffectOptionError(t *testing.T) {
+	assert.Error(t, WithEffect(Effect(10))(&SimplePermission{}))
+	assert.Error(t, WithEffect(Deny)(nil))
+	assert.Error(t, WithCombiningAlgorithm(0)(&role{}))
+	assert.Error(t, WithCombiningAlgorithm(DenyOverrides)(nil))
+	assert.Equal(t, `deny-overrides`, DenyOverrides.String())
+	assert.Equal(t, `deny`, Deny.String())
+}
@@ -0,0 +1,182 @@
+package rbac
+
+import (
+	"context"
+	"time"
+)
+
+// evalState of the single permission check
+type evalState struct {
+	ctx      context.Context
+	resource any
+	patterns []string
+
+	// Names of the roles on the path to the current evaluated node
+	path []string
+
+	// First error of the evaluation, stops the check
+	err error
+
+	// Some callback of the evaluation is impure and the result can't be memoized
+	uncacheable bool
+
+	// Permissions are evaluated inside the deny permission
+	denying bool
+
+	// Time of the evaluation by the clock of the context (see WithClock)
+	time time.Time
+
+	// Combined results of the evaluated roles, every role is evaluated once per check
+	roles map[*role]roleResult
+}
+
+// roleResult is the combined match of the role, the path of the match is relative to the parent of the role
+type roleResult struct {
+	match permissionMatch
+	ok    bool
+}
+
+func newEvalState(ctx context.Context, resource any, patterns []string) *evalState {
+	return &evalState{ctx: ctx, resource: resource, patterns: patterns}
+}
+
+// enterRole pushes the role into the path, returns false if the role is already
+// in the path (cycle) or the maximal depth of the hierarchy is reached
+func (st *evalState) enterRole(name string) bool {
+	if !canEnterRole(st.path, name) {
+		return false
+	}
+	st.path = append(st.path, name)
+	return true
+}
+
+func (st *evalState) leaveRole() {
+	st.path = st.path[:len(st.path)-1]
+}
+
+// remember the combined match of the role evaluated on the current path
+func (st *evalState) remember(r *role, m permissionMatch, ok bool) roleResult {
+	if ok {
+		m.path = m.path[len(st.path):]
+	}
+	res := roleResult{match: m, ok: ok}
+	if st.roles == nil {
+		st.roles = map[*role]roleResult{}
+	}
+	st.roles[r] = res
+	return res
+}
+
+// matchOf the remembered result on the current path
+func (st *evalState) matchOf(res roleResult) permissionMatch {
+	m := res.match
+	m.path = append(append(make([]string, 0, len(st.path)+len(m.path)), st.path...), m.path...)
+	return m
+}
+
+// now returns the time of the evaluation, the time is the same for all checked grants
+func (st *evalState) now() time.Time {
+	if st.time.IsZero() {
+		st.time = CurrentTime(st.ctx)
+	}
+	return st.time
+}
+
+// fail the evaluation with the error of the permission
+func (st *evalState) fail(perm Permission, err error) {
+	if st.err == nil {
+		st.err = wrapError(err, `permission `+perm.Name())
+	}
+}
+
+// match of the permission with the current path of roles
+func (st *evalState) match(perm Permission, effect Effect) permissionMatch {
+	m := permissionMatch{perm: perm, effect: effect, rank: owningRank(perm)}
+	if len(st.path) > 0 {
+		m.path = append(make([]string, 0, len(st.path)), st.path...)
+	}
+	return m
+}
+
+// permissionMatch describes the permission matched by the check
+type permissionMatch struct {
+	perm   Permission
+	effect Effect
+	path   []string // path of roles to the permission
+	rank   int      // rank of the owning scope of the permission
+}
+
+// narrower returns true if the match has narrower owning scope than other
+func (m permissionMatch) narrower(other permissionMatch) bool {
+	return m.rank > 0 && other.rank > 0 && m.rank < other.rank
+}
+
+// matchFunc receives every matched permission, returns false to stop the iteration
+type matchFunc func(m permissionMatch) bool
+
+type matchVisitor interface {
+	visitMatches(st *evalState, fn matchFunc) bool
+}
+
+// visitMatches calls fn for every permission in the tree which matches the check
+func visitMatches(st *evalState, perm Permission, fn matchFunc) bool {
+	if v, ok := perm.(matchVisitor); ok {
+		return v.visitMatches(st, fn)
+	}
+	p, err := perm.CheckedPermissionsE(st.ctx, st.resource, st.patterns...)
+	if err != nil {
+		st.fail(perm, err)
+		return false
+	}
+	if p != nil {
+		m := st.match(p, PermissionEffect(p))
+		if _, ok := perm.(Role); ok {
+			m.path = append(m.path, perm.Name())
+		}
+		return fn(m)
+	}
+	return true
+}
+
+// checkedPermissionE evaluates the permission tree by the algorithm and returns the allowed permission
+func checkedPermissionE(st *evalState, alg CombiningAlgorithm, v matchVisitor) (Permission, error) {
+	perm := checkedMatch(combineMatches(alg, func(fn matchFunc) bool {
+		return v.visitMatches(st, fn)
+	}))
+	if st.err != nil {
+		return nil, st.err
+	}
+	return perm, nil
+}
+
+// combineMatches resolves the final match of the visited permissions by the algorithm
+//
+// Matches of the same effect are resolved to the narrowest owning scope except FirstApplicable.
+func combineMatches(alg CombiningAlgorithm, visit func(fn matchFunc) bool) (res permissionMatch, ok bool) {
+	visit(func(m permissionMatch) bool {
+		switch alg {
+		case FirstApplicable:
+			res, ok = m, true
+			return false
+		case AllowOverrides:
+			if !ok || m.effect == Allow && res.effect != Allow || m.effect == res.effect && m.narrower(res) {
+				res, ok = m, true
+			}
+			return res.effect != Allow || res.rank > ownerRank
+		default:
+			if !ok || m.effect == Deny && res.effect != Deny || m.effect == res.effect && m.narrower(res) {
+				res, ok = m, true
+			}
+			return res.effect != Deny
+		}
+	})
+	return res, ok
+}
+
+// checkedMatch returns permission of the match if it allows access
+func checkedMatch(m permissionMatch, ok bool) Permission {
+	if ok && m.effect == Allow {
+		return m.perm
+	}
+	return nil
+}
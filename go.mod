@@ -5,11 +5,11 @@ go 1.21
 require (
 	github.com/demdxx/xtypes v0.2.0
 	github.com/stretchr/testify v1.9.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/exp v0.0.0-20240325151524-a685a6edb6d8 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
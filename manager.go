@@ -4,6 +4,8 @@ package rbac
 import (
 	"context"
 	"errors"
+	"reflect"
+	"slices"
 	"sync"
 	"time"
 
@@ -24,8 +26,8 @@ var owningTypes = []string{OwnOwner, OwnAccount, OwnAll}
 type RoleFilter func(ctx context.Context, role Role) bool
 
 type objectItem struct {
-	objType      any
-	checkCallbac any
+	objType     any
+	checkOption Option
 }
 
 // Manager of the roles and permissions
@@ -43,28 +45,104 @@ type objectItem struct {
 type Manager struct {
 	mx sync.RWMutex
 
-	roleAccessors RoleAccessors
+	roleAccessors  RoleAccessors
+	roleAccessorsE RoleAccessorsE
 
-	roles       map[string]Role
-	permissions map[string]Permission
+	// Hook of the role loading errors
+	onLoadError func(ctx context.Context, err error)
+
+	// Registered roles by tenant and name, global roles have empty tenant
+	roles   map[roleKey]Role
+	tenants map[string]bool
+
+	// Overrides of the global templates by tenants, composed with the current template on access
+	overrides map[roleKey]*role
+
+	// Roles of the global hierarchy rebuilt for tenants and composed overrides, the views
+	// are dropped and the generation is changed on every registration of the roles
+	views           map[roleKey]*tenantView
+	overrideViews   map[roleKey]*tenantView
+	viewsGeneration uint64
+
+	// Permissions indexed by blocks of the names
+	permissions nameTrie[Permission]
+
+	// Permissions with custom pattern matching, they are matched one by one
+	customPermissions map[string]Permission
+
+	// Default algorithm of combining allow and deny permissions
+	combining CombiningAlgorithm
+
+	// Algorithm of combining decisions of the subject roles
+	subjectCombining CombiningAlgorithm
+
+	// Compile roles into flattened permission indexes
+	flattenRoles bool
+
+	// Roles bound to subjects and groups
+	bindings *Bindings
 
 	// Object context data
 	objects map[string]*objectItem
 }
 
-// NewManager creates new manager
-func NewManager(roleAccessor RoleAccessors) *Manager {
-	return &Manager{
+// NewManager creates new manager, panics if some option is not applicable to the manager
+func NewManager(roleAccessor RoleAccessors, options ...Option) *Manager {
+	mng := &Manager{
 		roleAccessors: roleAccessor,
-		roles:         make(map[string]Role),
-		permissions:   make(map[string]Permission),
+		roles:         make(map[roleKey]Role),
+		tenants:       make(map[string]bool),
 		objects:       make(map[string]*objectItem),
 	}
+	if roleAccessor != nil {
+		mng.roleAccessorsE = AdaptRoleAccessors(roleAccessor)
+	}
+	if crl, ok := roleAccessor.(*cachedRoleLoader); ok {
+		crl.prepare = mng.prepareRole
+	}
+	for _, opt := range options {
+		if err := opt(mng); err != nil {
+			panic(err)
+		}
+	}
+	if mng.bindings == nil {
+		mng.bindings = NewBindings(nil)
+	}
+	return mng
 }
 
 // NewManagerWithLoader creates new manager with role loader
-func NewManagerWithLoader(roleLoader RoleLoader, lifetimeCache time.Duration) *Manager {
-	return NewManager(newCachedRoleLoader(roleLoader, lifetimeCache))
+func NewManagerWithLoader(roleLoader RoleLoader, lifetimeCache time.Duration, options ...Option) *Manager {
+	return NewManager(newCachedRoleLoader(AdaptRoleLoader(roleLoader), lifetimeCache), options...)
+}
+
+// NewManagerWithLoaderE creates new manager with error-aware role loader,
+// errors of the loader are available by RoleE, RolesE and WithLoadErrorHook
+func NewManagerWithLoaderE(roleLoader RoleLoaderE, lifetimeCache time.Duration, options ...Option) *Manager {
+	return NewManager(newCachedRoleLoader(roleLoader, lifetimeCache), options...)
+}
+
+// Invalidate cached roles by names and roles which inherit them,
+// no-op if the role accessors don't cache roles
+func (mng *Manager) Invalidate(names ...string) {
+	if inv, ok := mng.roleAccessors.(roleInvalidator); ok && len(names) > 0 {
+		inv.Invalidate(names...)
+	}
+}
+
+// InvalidateAll cached roles, they are reloaded on the next access
+func (mng *Manager) InvalidateAll() {
+	if inv, ok := mng.roleAccessors.(roleInvalidator); ok {
+		inv.InvalidateAll()
+	}
+}
+
+// CombiningAlgorithm returns default algorithm of combining allow and deny permissions
+func (mng *Manager) CombiningAlgorithm() CombiningAlgorithm {
+	if mng.combining.valid() {
+		return mng.combining
+	}
+	return DefaultCombiningAlgorithm
 }
 
 // ObjectByName returns object by name
@@ -78,119 +156,325 @@ func (mng *Manager) ObjectByName(name string) any {
 }
 
 // RegisterObject for processing
+//
+// The check callback is used for all resource permissions of the object type
+// registered by RegisterNewPermissions, see WithCustomCheck for the callback format.
 func (mng *Manager) RegisterObject(objType, checkCallbac any) *Manager {
+	var checkOption Option
+	if checkCallbac != nil {
+		checkOption = WithCustomCheck(checkCallbac)
+	}
+	return mng.registerObject(objType, checkOption)
+}
+
+// RegisterObject of the type T with typed check callback for processing
+//
+// Example:
+//
+//	rbac.RegisterObject(mng, func(ctx context.Context, user *model.User, perm rbac.Permission) bool {
+//	  return user.ID == currentUserID(ctx)
+//	})
+func RegisterObject[T any](mng *Manager, check func(ctx context.Context, resource T, perm Permission) bool) *Manager {
+	var checkOption Option
+	if check != nil {
+		checkOption = WithCheck(check)
+	}
+	return mng.registerObject(typedObject[T](), checkOption)
+}
+
+// RegisterObjectE of the type T with typed check callback which can fail
+//
+// Errors of the callback deny the access and are returned by the CheckPermissionsE methods.
+func RegisterObjectE[T any](mng *Manager, check func(ctx context.Context, resource T, perm Permission) (bool, error)) *Manager {
+	var checkOption Option
+	if check != nil {
+		checkOption = WithCheckE(check)
+	}
+	return mng.registerObject(typedObject[T](), checkOption)
+}
+
+// typedObject returns the object value of the type T (allocated for pointer types)
+func typedObject[T any]() any {
+	if tp := reflect.TypeOf((*T)(nil)).Elem(); tp.Kind() == reflect.Ptr {
+		return reflect.New(tp.Elem()).Interface()
+	}
+	return *new(T)
+}
+
+func (mng *Manager) registerObject(objType any, checkOption Option) *Manager {
+	mng.mx.Lock()
+	defer mng.mx.Unlock()
 	mng.objects[GetResName(objType)] = &objectItem{
-		objType:      objType,
-		checkCallbac: checkCallbac,
+		objType:     objType,
+		checkOption: checkOption,
 	}
 	return mng
 }
 
 func (mng *Manager) objectItem(obj any) *objectItem {
+	mng.mx.RLock()
+	defer mng.mx.RUnlock()
 	return mng.objects[GetResName(obj)]
 }
 
-// AddRole to the manager
+// Role returns role by name in the scope of the tenant of the context (see WithTenant)
 func (mng *Manager) Role(ctx context.Context, name string) Role {
-	mng.mx.RLock()
-	defer mng.mx.RUnlock()
-	if mng.roleAccessors != nil {
-		if ro := mng.roleAccessors.Role(ctx, name); ro != nil {
-			return mng.prepareRole(ctx, ro)
+	role, _ := mng.RoleE(ctx, name)
+	return role
+}
+
+// RoleE returns role by name or error of the role accessors,
+// registered role is returned even if the accessors failed
+//
+// Roles of the tenant of the context override global roles and child roles of the global hierarchy.
+func (mng *Manager) RoleE(ctx context.Context, name string) (Role, error) {
+	tenant := TenantFromContext(ctx)
+	if tenant != `` {
+		if ro := mng.tenantRole(ctx, tenant, name); ro != nil {
+			return mng.tenantRoleView(ctx, tenant, ro), nil
+		}
+	}
+	var err error
+	if mng.roleAccessorsE != nil {
+		var ro Role
+		if ro, err = mng.roleAccessorsE.RoleE(ctx, name); ro != nil {
+			return mng.tenantRoleView(ctx, tenant, mng.preparedRole(ctx, ro)), nil
 		}
+		mng.loadError(ctx, err)
+	}
+	if ro := mng.registeredRole(roleKey{name: name}); ro != nil {
+		return mng.tenantRoleView(ctx, tenant, ro), nil
 	}
-	return mng.roles[name]
+	return nil, err
+}
+
+// registeredRole returns the role registered in the manager by the key
+func (mng *Manager) registeredRole(key roleKey) Role {
+	mng.mx.RLock()
+	defer mng.mx.RUnlock()
+	return mng.roles[key]
 }
 
-// Role returns role by name
+// Roles returns roles by names or all roles if names are empty
 func (mng *Manager) Roles(ctx context.Context, names ...string) []Role {
+	roles, _ := mng.RolesE(ctx, names...)
+	return roles
+}
+
+// RolesE returns roles by names or all roles if names are empty and the first error of the role accessors,
+// the roles which have been resolved are returned with the error
+func (mng *Manager) RolesE(ctx context.Context, names ...string) ([]Role, error) {
 	if len(names) > 0 {
-		roles := make([]Role, 0, len(names))
+		var (
+			err   error
+			roles = make([]Role, 0, len(names))
+		)
 		for _, name := range names {
-			if role := mng.Role(ctx, name); role != nil {
+			role, roleErr := mng.RoleE(ctx, name)
+			if role != nil {
 				roles = append(roles, role)
+			} else if err == nil {
+				err = roleErr
 			}
 		}
-		return roles
+		return roles, err
 	}
 
 	// Return all roles
-	mng.mx.RLock()
-	defer mng.mx.RUnlock()
+	var (
+		err   error
+		roles []Role
+	)
+	if mng.roleAccessorsE != nil {
+		var loaded []Role
+		loaded, err = mng.roleAccessorsE.RolesE(ctx)
+		mng.loadError(ctx, err)
+		roles = append(roles, mng.prepareLoaded(ctx, loaded)...)
+	}
 
-	roles := make([]Role, 0, len(mng.roles))
-	if mng.roleAccessors != nil {
-		roles = append(roles,
-			xtypes.Slice[Role](mng.roleAccessors.Roles(ctx)).Apply(
-				func(role Role) Role { return mng.prepareRole(ctx, role) })...,
-		)
+	return append(roles, mng.tenantRoles(ctx, TenantFromContext(ctx))...), err
+}
+
+// prepareLoaded roles of the accessors in the scope of the tenant of the context
+func (mng *Manager) prepareLoaded(ctx context.Context, loaded []Role) []Role {
+	tenant := TenantFromContext(ctx)
+	return xtypes.Slice[Role](loaded).Apply(func(role Role) Role {
+		if tenant != `` && mng.hasTenantRole(roleKey{tenant: tenant, name: role.Name()}) {
+			// Tenant roles are listed separately
+			return nil
+		}
+		return mng.tenantRoleView(ctx, tenant, mng.preparedRole(ctx, role))
+	}).Filter(func(role Role) bool { return role != nil })
+}
+
+// Decide evaluates the roles by names with the combining algorithm of the manager
+// and returns the decision with explanation of the result
+func (mng *Manager) Decide(ctx context.Context, roleNames []string, resource any, patterns ...string) Decision {
+	var roles []Permission
+	if len(roleNames) > 0 {
+		list, err := mng.RolesE(ctx, roleNames...)
+		if err != nil {
+			return loadErrorDecision(resource, patterns, err)
+		}
+		for _, role := range list {
+			roles = append(roles, role)
+		}
 	}
+	return Decide(ctx, mng.CombiningAlgorithm(), roles, resource, patterns...)
+}
 
-	return append(roles, xtypes.Map[string, Role](mng.roles).Values()...)
+// CheckPermissionsE of the roles by names with the combining algorithm of the manager,
+// returns error if some check callback of the roles failed
+func (mng *Manager) CheckPermissionsE(ctx context.Context, roleNames []string, resource any, patterns ...string) (bool, error) {
+	if len(patterns) == 0 {
+		return false, ErrInvalidCheckParams
+	}
+	decision := mng.Decide(ctx, roleNames, resource, patterns...)
+	return decision.Allowed(), decision.Err
 }
 
 // RolesByFilter returns roles by filter
 func (mng *Manager) RolesByFilter(ctx context.Context, filter RoleFilter) []Role {
-	mng.mx.RLock()
-	defer mng.mx.RUnlock()
+	roles, _ := mng.RolesByFilterE(ctx, filter)
+	return roles
+}
 
-	roles := make([]Role, 0, len(mng.roles))
-	if mng.roleAccessors != nil {
-		roles = append(roles,
-			xtypes.Slice[Role](mng.roleAccessors.RolesByFilter(ctx, filter)).Apply(
-				func(role Role) Role { return mng.prepareRole(ctx, role) })...,
-		)
+// RolesByFilterE returns roles by filter and error of the role accessors
+func (mng *Manager) RolesByFilterE(ctx context.Context, filter RoleFilter) ([]Role, error) {
+	var (
+		err   error
+		roles []Role
+	)
+	if mng.roleAccessorsE != nil {
+		var loaded []Role
+		loaded, err = mng.roleAccessorsE.RolesByFilterE(ctx, filter)
+		mng.loadError(ctx, err)
+		roles = append(roles, mng.prepareLoaded(ctx, loaded)...)
 	}
 
-	for _, role := range mng.roles {
+	for _, role := range mng.tenantRoles(ctx, TenantFromContext(ctx)) {
 		if filter(ctx, role) {
 			roles = append(roles, role)
 		}
 	}
-	return roles
+	return roles, err
+}
+
+// loadError of the role accessors passes to the hook,
+// the cache of the role loader reports errors of the loader itself
+func (mng *Manager) loadError(ctx context.Context, err error) {
+	if err == nil || mng.onLoadError == nil {
+		return
+	}
+	if _, ok := mng.roleAccessors.(*cachedRoleLoader); !ok {
+		mng.onLoadError(ctx, err)
+	}
 }
 
-// Roles returns all or selected roles
+// RegisterRole in the manager, panics if the role hierarchy is invalid
 func (mng *Manager) RegisterRole(ctx context.Context, roles ...Role) *Manager {
+	if err := mng.RegisterRoleE(ctx, roles...); err != nil {
+		panic(err)
+	}
+	return mng
+}
+
+// RegisterRoleE in the manager, returns error if the role hierarchy has cycles or too deep
+//
+// Roles are registered in the global scope, see RegisterTenantRoleE for roles of the tenant.
+func (mng *Manager) RegisterRoleE(ctx context.Context, roles ...Role) error {
+	return mng.registerRoles(ctx, ``, roles)
+}
+
+func (mng *Manager) registerRoles(ctx context.Context, tenant string, roles []Role) error {
+	for _, role := range roles {
+		if err := ValidateRole(role); err != nil {
+			return err
+		}
+	}
 	for i, role := range roles {
 		roles[i] = mng.prepareRole(ctx, role)
 	}
 	mng.mx.Lock()
 	defer mng.mx.Unlock()
 	for _, role := range roles {
-		mng.roles[role.Name()] = role
+		key := roleKey{tenant: tenant, name: role.Name()}
+		mng.roles[key] = role
+		delete(mng.overrides, key)
 	}
-	return mng
+	if tenant != `` && len(roles) > 0 {
+		mng.tenants[tenant] = true
+	}
+	mng.resetViewsLocked()
+	return nil
+}
+
+// resetViewsLocked drops views of the tenants, they are rebuilt on the next access
+func (mng *Manager) resetViewsLocked() {
+	mng.views = nil
+	mng.overrideViews = nil
+	mng.viewsGeneration++
 }
 
 // AddRole to the manager
 func (mng *Manager) Permission(name string) Permission {
 	mng.mx.RLock()
 	defer mng.mx.RUnlock()
-	return mng.permissions[name]
+	perm, _ := mng.permissions.get(name)
+	return perm
 }
 
-// Permissions returns all or selected permissions
+// Permissions returns all or selected permissions, expired permissions are skipped
+//
+// Patterns are matched with the registered names, only the matching branches
+// of the permission index are visited. Permissions of other types than SimplePermission
+// and ResourcePermission are matched by MatchPermissionPattern.
 func (mng *Manager) Permissions(patterns ...string) []Permission {
+	return unexpiredPermissions(mng.registeredPermissions(patterns...), time.Now())
+}
+
+// PermissionsContext returns all or selected permissions like Permissions,
+// expired permissions are skipped by the time of the context clock (see WithClock)
+func (mng *Manager) PermissionsContext(ctx context.Context, patterns ...string) []Permission {
+	return unexpiredPermissions(mng.registeredPermissions(patterns...), CurrentTime(ctx))
+}
+
+// registeredPermissions returns all or selected permissions including expired ones
+func (mng *Manager) registeredPermissions(patterns ...string) []Permission {
 	mng.mx.RLock()
 	defer mng.mx.RUnlock()
 
-	allPermissions := xtypes.Map[string, Permission](mng.permissions).Values()
-
 	// Return all permissions
 	if len(patterns) == 0 || len(patterns) == 1 && patterns[0] == `*` {
-		return allPermissions
+		return mng.permissions.all()
 	}
-
-	// Filter by patterns
-	return allPermissions.Filter(func(perm Permission) bool {
-		for _, pattern := range patterns {
-			if perm.MatchPermissionPattern(pattern) {
-				return true
-			}
+	list := mng.permissions.match(patterns...)
+	if len(mng.customPermissions) == 0 {
+		return list
+	}
+	list = slices.DeleteFunc(list, func(perm Permission) bool { return !isNamePermission(perm) })
+	for _, perm := range mng.customPermissions {
+		if perm.MatchPermissionPattern(patterns...) {
+			list = append(list, perm)
 		}
-		return false
-	})
+	}
+	return list
+}
+
+// unexpiredPermissions removes permissions with the validity period over at the time
+func unexpiredPermissions(list []Permission, now time.Time) []Permission {
+	return slices.DeleteFunc(list, func(perm Permission) bool { return grantExpired(perm, now) })
+}
+
+// permissionRegistry reads all registered permissions of the manager including expired ones,
+// roles are prepared by the registry because the validity is checked by the clock of the check
+type permissionRegistry struct {
+	*Manager
+}
+
+// Permissions returns all or selected registered permissions
+func (r permissionRegistry) Permissions(patterns ...string) []Permission {
+	return r.registeredPermissions(patterns...)
 }
 
 // ObjectPermissions returns all or selected permissions for the object like .RBACResourceName() + `.` + pattern
@@ -211,11 +495,28 @@ func (mng *Manager) RegisterPermission(perms ...Permission) *Manager {
 	mng.mx.Lock()
 	defer mng.mx.Unlock()
 	for _, perm := range perms {
-		mng.permissions[perm.Name()] = perm
+		mng.permissions.put(perm.Name(), perm)
+		if isNamePermission(perm) {
+			delete(mng.customPermissions, perm.Name())
+		} else {
+			if mng.customPermissions == nil {
+				mng.customPermissions = make(map[string]Permission)
+			}
+			mng.customPermissions[perm.Name()] = perm
+		}
 	}
 	return mng
 }
 
+// isNamePermission returns true if the permission matches patterns by the name only
+func isNamePermission(perm Permission) bool {
+	switch perm.(type) {
+	case *SimplePermission, *ResourcePermission:
+		return true
+	}
+	return false
+}
+
 // RegisterNewPermission in the system
 func (mng *Manager) RegisterNewPermission(resType any, name string, options ...Option) error {
 	return mng.RegisterNewPermissions(resType, []string{name}, options...)
@@ -236,8 +537,8 @@ func (mng *Manager) RegisterNewPermissions(resType any, names []string, options
 		}
 	} else {
 		// Register resource permissions
-		if obj := mng.objectItem(resType); obj != nil && obj.checkCallbac != nil {
-			options = append([]Option{WithCustomCheck(obj.checkCallbac)}, options...)
+		if obj := mng.objectItem(resType); obj != nil && obj.checkOption != nil {
+			options = append([]Option{obj.checkOption}, options...)
 		}
 		for _, name := range names {
 			perm, err := NewResourcePermission(name, resType, options...)
@@ -253,25 +554,43 @@ func (mng *Manager) RegisterNewPermissions(resType any, names []string, options
 }
 
 // RegisterNewOwningPermissions modifies permissions for owning with extension of the name > name.owner, name.account and name.all
+//
+// The scope of the permission is checked automatically (see WithOwningScope) and the check
+// of the action without scope (`view`) selects the narrowest scope which allows access.
 func (mng *Manager) RegisterNewOwningPermissions(resType any, names []string, options ...Option) error {
 	if resType == nil {
 		return ErrResourceTypeRequired
 	}
 
-	newNames := make([]string, 0, len(names)*len(owningTypes))
-	for _, name := range names {
-		for _, own := range owningTypes {
+	for _, own := range owningTypes {
+		newNames := make([]string, 0, len(names))
+		for _, name := range names {
 			newNames = append(newNames, name+`.`+own)
 		}
+		if err := mng.RegisterNewPermissions(resType, newNames, append(options[:len(options):len(options)], WithOwningScope(own))...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preparedRole returns the role of the accessors prepared for usage,
+// roles of the cache are prepared once before they are shared
+func (mng *Manager) preparedRole(ctx context.Context, role Role) Role {
+	if crl, ok := mng.roleAccessors.(*cachedRoleLoader); ok && crl.prepare != nil {
+		return role
 	}
-	return mng.RegisterNewPermissions(resType, newNames, options...)
+	return mng.prepareRole(ctx, role)
 }
 
 func (mng *Manager) prepareRole(ctx context.Context, role Role) Role {
 	switch rolei := role.(type) {
 	case rolePreparer:
-		role = rolei.Prepare(ctx, mng)
+		role = rolei.Prepare(ctx, permissionRegistry{mng})
 	default:
 	}
+	if indexer, ok := role.(roleIndexer); ok && mng.flattenRoles {
+		indexer.enableIndex()
+	}
 	return role
 }
@@ -2,14 +2,28 @@ package rbac
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrRoleLoading if the role loader panics
+var ErrRoleLoading = errors.New(`role loading failed`)
+
+// failureRetryDivider defines the part of the cache lifetime to retry loading after the failure
+const failureRetryDivider = 10
+
 type permissionReader interface {
 	Permissions(patterns ...string) []Permission
 }
 
+type combiningProvider interface {
+	CombiningAlgorithm() CombiningAlgorithm
+}
+
 type rolePreparer interface {
 	Prepare(context.Context, permissionReader) Role
 }
@@ -19,6 +33,31 @@ type RoleLoader interface {
 	ListRoles(ctx context.Context) []Role
 }
 
+// IncrementalRoleLoader loads the single role with child roles,
+// used by the cache to reload only invalidated roles
+type IncrementalRoleLoader interface {
+	RoleLoader
+	LoadRole(ctx context.Context, name string) Role
+}
+
+// ChangeNotifier pushes the names of changed roles to the subscribers,
+// empty list of names means that all roles could be changed
+type ChangeNotifier interface {
+	SubscribeRoleChanges(fn func(names ...string))
+}
+
+// RoleLoaderE interface for loading roles with the error of the source
+type RoleLoaderE interface {
+	ListRolesE(ctx context.Context) ([]Role, error)
+}
+
+// IncrementalRoleLoaderE loads the single role with child roles with the error of the source,
+// nil role without error means that the role doesn't exist
+type IncrementalRoleLoaderE interface {
+	RoleLoaderE
+	LoadRoleE(ctx context.Context, name string) (Role, error)
+}
+
 // RoleAccessors interface for accessing roles
 type RoleAccessors interface {
 	Role(ctx context.Context, name string) Role
@@ -26,90 +65,448 @@ type RoleAccessors interface {
 	RolesByFilter(ctx context.Context, filter RoleFilter) []Role
 }
 
+// RoleAccessorsE interface for accessing roles with the error of the source
+type RoleAccessorsE interface {
+	RoleE(ctx context.Context, name string) (Role, error)
+	RolesE(ctx context.Context, names ...string) ([]Role, error)
+	RolesByFilterE(ctx context.Context, filter RoleFilter) ([]Role, error)
+}
+
+// AdaptRoleLoader to the error-aware interface, loaders implementing RoleLoaderE are returned as is
+func AdaptRoleLoader(loader RoleLoader) RoleLoaderE {
+	if le, ok := loader.(RoleLoaderE); ok {
+		return le
+	}
+	return roleLoaderAdapter{loader: loader}
+}
+
+// RoleLoaderFromE adapts the error-aware loader to RoleLoader interface, errors are dropped
+// by ListRoles but the result still implements RoleLoaderE for the manager
+func RoleLoaderFromE(loader RoleLoaderE) RoleLoader {
+	return roleLoaderE{RoleLoaderE: loader}
+}
+
+// AdaptRoleAccessors to the error-aware interface, accessors implementing RoleAccessorsE are returned as is
+func AdaptRoleAccessors(accessors RoleAccessors) RoleAccessorsE {
+	if ae, ok := accessors.(RoleAccessorsE); ok {
+		return ae
+	}
+	return roleAccessorsAdapter{accessors: accessors}
+}
+
+// RoleAccessorsFromE adapts the error-aware accessors to RoleAccessors interface, errors are dropped
+// by the methods but the result still implements RoleAccessorsE for the manager
+func RoleAccessorsFromE(accessors RoleAccessorsE) RoleAccessors {
+	return roleAccessorsE{RoleAccessorsE: accessors}
+}
+
+type roleLoaderAdapter struct {
+	loader RoleLoader
+}
+
+func (a roleLoaderAdapter) ListRolesE(ctx context.Context) ([]Role, error) {
+	return a.loader.ListRoles(ctx), nil
+}
+
+type roleLoaderE struct {
+	RoleLoaderE
+}
+
+func (a roleLoaderE) ListRoles(ctx context.Context) []Role {
+	roles, _ := a.ListRolesE(ctx)
+	return roles
+}
+
+type roleAccessorsAdapter struct {
+	accessors RoleAccessors
+}
+
+func (a roleAccessorsAdapter) RoleE(ctx context.Context, name string) (Role, error) {
+	return a.accessors.Role(ctx, name), nil
+}
+
+func (a roleAccessorsAdapter) RolesE(ctx context.Context, names ...string) ([]Role, error) {
+	return a.accessors.Roles(ctx, names...), nil
+}
+
+func (a roleAccessorsAdapter) RolesByFilterE(ctx context.Context, filter RoleFilter) ([]Role, error) {
+	return a.accessors.RolesByFilter(ctx, filter), nil
+}
+
+type roleAccessorsE struct {
+	RoleAccessorsE
+}
+
+func (a roleAccessorsE) Role(ctx context.Context, name string) Role {
+	role, _ := a.RoleE(ctx, name)
+	return role
+}
+
+func (a roleAccessorsE) Roles(ctx context.Context, names ...string) []Role {
+	roles, _ := a.RolesE(ctx, names...)
+	return roles
+}
+
+func (a roleAccessorsE) RolesByFilter(ctx context.Context, filter RoleFilter) []Role {
+	roles, _ := a.RolesByFilterE(ctx, filter)
+	return roles
+}
+
+// incrementalLoaderOf returns the error-aware incremental loader or nil
+func incrementalLoaderOf(loader any) func(ctx context.Context, name string) (Role, error) {
+	switch l := loader.(type) {
+	case IncrementalRoleLoaderE:
+		return l.LoadRoleE
+	case IncrementalRoleLoader:
+		return func(ctx context.Context, name string) (Role, error) {
+			return l.LoadRole(ctx, name), nil
+		}
+	}
+	return nil
+}
+
+// unwrapLoader returns the loader wrapped by the adapter
+func unwrapLoader(loader any) any {
+	switch l := loader.(type) {
+	case roleLoaderAdapter:
+		return l.loader
+	case roleLoaderE:
+		return l.RoleLoaderE
+	}
+	return loader
+}
+
+type roleInvalidator interface {
+	Invalidate(names ...string)
+	InvalidateAll()
+}
+
+// roleSnapshot is the immutable state of the cached roles
+type roleSnapshot struct {
+	roles     map[string]Role
+	updatedAt time.Time
+
+	// Error of the loading if no roles have been loaded yet
+	err error
+}
+
+// cachedRoleLoader caches roles of the loader
+//
+// Reads are lock-free and use the current snapshot of roles. Expired snapshot is returned
+// while the new one is loaded in the background (stale-while-revalidate), the snapshot
+// is kept if the loader fails. Invalidated roles are reloaded in the background on the next access,
+// failed reloads are retried after the part of the cache lifetime.
 type cachedRoleLoader struct {
-	mx sync.RWMutex
+	// Serializes loading of the roles
+	mx sync.Mutex
+
+	loader   RoleLoaderE
+	loadRole func(ctx context.Context, name string) (Role, error)
+	snapshot atomic.Pointer[roleSnapshot]
 
-	loader     RoleLoader
-	rolesCache map[string]Role
+	// Prepares the loaded roles before they are published in the snapshot (see Manager)
+	prepare func(ctx context.Context, role Role) Role
 
-	lastCacheUpdate time.Time
-	lifetimeCache   time.Duration
+	// Hook of the loading errors
+	onLoadError atomic.Pointer[func(ctx context.Context, err error)]
+
+	// Names of the invalidated roles to reload by the incremental loader
+	staleRoles map[string]bool
+	staleAll   bool
+	pending    atomic.Bool
+
+	// Time (unix nanoseconds) of the next retry of the failed reload of invalidated roles
+	retryAt atomic.Int64
+
+	refreshing    atomic.Bool
+	lifetimeCache time.Duration
 }
 
-func newCachedRoleLoader(loader RoleLoader, lifetimeCache time.Duration) *cachedRoleLoader {
-	return &cachedRoleLoader{
-		loader:          loader,
-		rolesCache:      make(map[string]Role),
-		lastCacheUpdate: time.Now().AddDate(-10, 0, 0),
-		lifetimeCache:   lifetimeCache,
+func newCachedRoleLoader(loader RoleLoaderE, lifetimeCache time.Duration) *cachedRoleLoader {
+	source := unwrapLoader(loader)
+	crl := &cachedRoleLoader{
+		loader:        loader,
+		loadRole:      incrementalLoaderOf(source),
+		staleRoles:    make(map[string]bool),
+		lifetimeCache: lifetimeCache,
+	}
+	if notifier, ok := source.(ChangeNotifier); ok {
+		notifier.SubscribeRoleChanges(func(names ...string) {
+			if len(names) == 0 {
+				crl.InvalidateAll()
+			} else {
+				crl.Invalidate(names...)
+			}
+		})
 	}
+	return crl
 }
 
 func (crl *cachedRoleLoader) Role(ctx context.Context, name string) Role {
-	if time.Since(crl.lastCacheUpdate) > crl.lifetimeCache {
-		crl.refreshCache(ctx)
-	}
-	crl.mx.RLock()
-	defer crl.mx.RUnlock()
-	return crl.rolesCache[name]
+	role, _ := crl.RoleE(ctx, name)
+	return role
 }
 
 func (crl *cachedRoleLoader) Roles(ctx context.Context, names ...string) []Role {
-	if time.Since(crl.lastCacheUpdate) > crl.lifetimeCache {
-		crl.refreshCache(ctx)
-	}
+	roles, _ := crl.RolesE(ctx, names...)
+	return roles
+}
 
-	crl.mx.RLock()
-	defer crl.mx.RUnlock()
+// RolesByFilter returns roles by filter
+func (crl *cachedRoleLoader) RolesByFilter(ctx context.Context, filter RoleFilter) []Role {
+	roles, _ := crl.RolesByFilterE(ctx, filter)
+	return roles
+}
 
+// RoleE returns cached role by name or error if roles have never been loaded
+func (crl *cachedRoleLoader) RoleE(ctx context.Context, name string) (Role, error) {
+	snap := crl.actual(ctx)
+	return snap.roles[name], snap.err
+}
+
+// RolesE returns cached roles by names (all if empty) or error if roles have never been loaded
+func (crl *cachedRoleLoader) RolesE(ctx context.Context, names ...string) ([]Role, error) {
+	snap := crl.actual(ctx)
 	if len(names) > 0 {
 		roles := make([]Role, 0, len(names))
 		for _, name := range names {
-			if role, ok := crl.rolesCache[name]; ok {
+			if role, ok := snap.roles[name]; ok {
 				roles = append(roles, role)
 			}
 		}
-		return roles
+		return roles, snap.err
 	}
 
-	roles := make([]Role, 0, len(crl.rolesCache))
-	for _, role := range crl.rolesCache {
+	roles := make([]Role, 0, len(snap.roles))
+	for _, role := range snap.roles {
 		roles = append(roles, role)
 	}
-	return roles
+	return roles, snap.err
 }
 
-// RolesByFilter returns roles by filter
-func (crl *cachedRoleLoader) RolesByFilter(ctx context.Context, filter RoleFilter) []Role {
-	if time.Since(crl.lastCacheUpdate) > crl.lifetimeCache {
-		crl.refreshCache(ctx)
-	}
-
-	crl.mx.RLock()
-	defer crl.mx.RUnlock()
-
-	roles := make([]Role, 0, len(crl.rolesCache))
-	for _, role := range crl.rolesCache {
+// RolesByFilterE returns roles by filter or error if roles have never been loaded
+func (crl *cachedRoleLoader) RolesByFilterE(ctx context.Context, filter RoleFilter) ([]Role, error) {
+	snap := crl.actual(ctx)
+	roles := make([]Role, 0, len(snap.roles))
+	for _, role := range snap.roles {
 		if filter(ctx, role) {
 			roles = append(roles, role)
 		}
 	}
-	return roles
+	return roles, snap.err
+}
+
+// Invalidate roles by names and all cached roles which inherit them,
+// the whole cache is invalidated if the loader is not incremental
+func (crl *cachedRoleLoader) Invalidate(names ...string) {
+	if crl.loadRole == nil {
+		crl.InvalidateAll()
+		return
+	}
+	crl.mx.Lock()
+	defer crl.mx.Unlock()
+	var cache map[string]Role
+	if snap := crl.snapshot.Load(); snap != nil {
+		cache = snap.roles
+	}
+	for _, name := range names {
+		crl.staleRoles[name] = true
+		for roleName, role := range cache {
+			if role.HasRole(name) {
+				crl.staleRoles[roleName] = true
+			}
+		}
+	}
+	crl.pending.Store(true)
 }
 
-func (crl *cachedRoleLoader) refreshCache(ctx context.Context) {
+// InvalidateAll roles, the cache is reloaded on the next access
+func (crl *cachedRoleLoader) InvalidateAll() {
 	crl.mx.Lock()
 	defer crl.mx.Unlock()
+	crl.staleAll = true
+	crl.pending.Store(true)
+}
+
+// actual returns actual snapshot of the cache
+func (crl *cachedRoleLoader) actual(ctx context.Context) *roleSnapshot {
+	snap := crl.snapshot.Load()
+	switch {
+	case snap == nil:
+		snap = crl.reload(ctx, nil)
+	case crl.pending.Load() && time.Now().UnixNano() >= crl.retryAt.Load():
+		if snap.err != nil {
+			// There are no good roles to serve while the invalidated ones are loading
+			snap = crl.reloadInvalidated(ctx)
+		} else {
+			crl.refreshAsync(ctx, crl.reloadInvalidated)
+		}
+	case time.Since(snap.updatedAt) > crl.lifetimeCache:
+		crl.refreshAsync(ctx, func(ctx context.Context) *roleSnapshot { return crl.reload(ctx, snap) })
+	}
+	return snap
+}
 
-	// Check if cache is expired
-	if time.Since(crl.lastCacheUpdate) <= crl.lifetimeCache {
+// refreshAsync runs the reload in the background if no reload is in progress
+func (crl *cachedRoleLoader) refreshAsync(ctx context.Context, reload func(ctx context.Context) *roleSnapshot) {
+	if !crl.refreshing.CompareAndSwap(false, true) {
 		return
 	}
+	go func() {
+		defer crl.refreshing.Store(false)
+		reload(context.WithoutCancel(ctx))
+	}()
+}
+
+// retryLater the reload of the invalidated roles after the part of the lifetime
+func (crl *cachedRoleLoader) retryLater() {
+	crl.retryAt.Store(time.Now().Add(crl.lifetimeCache / failureRetryDivider).UnixNano())
+}
+
+// reload all roles if the snapshot is not changed since prev,
+// keeps the previous roles if the loader failed
+func (crl *cachedRoleLoader) reload(ctx context.Context, prev *roleSnapshot) *roleSnapshot {
+	crl.mx.Lock()
+	defer crl.mx.Unlock()
+
+	if cur := crl.snapshot.Load(); cur != prev && cur != nil {
+		return cur
+	}
+	return crl.reloadLocked(ctx)
+}
+
+func (crl *cachedRoleLoader) reloadLocked(ctx context.Context) *roleSnapshot {
+	snap := &roleSnapshot{updatedAt: time.Now()}
+	roles, err := crl.listRoles(ctx)
+	if err != nil && errors.Is(err, ErrPartialRoleLoading) {
+		// Skipped roles are reported, the loaded ones are used
+		crl.loadError(ctx, err)
+		err = nil
+	}
+	if err == nil {
+		snap.roles = make(map[string]Role, len(roles))
+		for _, role := range roles {
+			snap.roles[role.Name()] = crl.prepared(ctx, role)
+		}
+	} else {
+		crl.loadError(ctx, err)
+		// Keep the last good roles and retry the full reload after the part of the lifetime
+		if prev := crl.snapshot.Load(); prev != nil && prev.err == nil {
+			snap.roles = prev.roles
+		} else {
+			snap.err = err
+		}
+		snap.updatedAt = snap.updatedAt.Add(-crl.lifetimeCache + crl.lifetimeCache/failureRetryDivider)
+		// Invalidated roles stay pending and are reloaded after the same delay
+		if crl.pending.Load() {
+			crl.retryLater()
+		}
+		crl.snapshot.Store(snap)
+		return snap
+	}
+	crl.staleRoles = make(map[string]bool)
+	crl.staleAll = false
+	crl.pending.Store(false)
+	crl.snapshot.Store(snap)
+	return snap
+}
+
+// reloadInvalidated roles by the incremental loader or all roles
+func (crl *cachedRoleLoader) reloadInvalidated(ctx context.Context) *roleSnapshot {
+	crl.mx.Lock()
+	defer crl.mx.Unlock()
+
+	prev := crl.snapshot.Load()
+	if !crl.pending.Load() {
+		return prev
+	}
+	if crl.staleAll || prev == nil {
+		return crl.reloadLocked(ctx)
+	}
+
+	snap := &roleSnapshot{roles: make(map[string]Role, len(prev.roles)), updatedAt: prev.updatedAt, err: prev.err}
+	for name, role := range prev.roles {
+		snap.roles[name] = role
+	}
+	failed := make(map[string]bool)
+	for name := range crl.staleRoles {
+		role, err := crl.loadRoleSafe(ctx, name)
+		switch {
+		case errors.Is(err, ErrUnknownRole):
+			delete(snap.roles, name)
+		case err != nil:
+			// Keep the last good role and retry after the part of the lifetime
+			crl.loadError(ctx, wrapError(err, `role `+name))
+			failed[name] = true
+		case role != nil:
+			snap.roles[name] = crl.prepared(ctx, role)
+		default:
+			delete(snap.roles, name)
+		}
+	}
+	crl.staleRoles = failed
+	crl.pending.Store(len(failed) > 0)
+	if len(failed) > 0 {
+		crl.retryLater()
+	}
+	crl.snapshot.Store(snap)
+	return snap
+}
+
+// prepared role of the loader, the roles of the snapshot are not changed after publishing
+func (crl *cachedRoleLoader) prepared(ctx context.Context, role Role) Role {
+	if crl.prepare == nil || role == nil {
+		return role
+	}
+	return crl.prepare(ctx, role)
+}
+
+// listRoles of the loader, the panic of the loader is returned as ErrRoleLoading
+func (crl *cachedRoleLoader) listRoles(ctx context.Context) (roles []Role, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			roles, err = nil, wrapError(ErrRoleLoading, fmt.Sprintf(`panic %v`, rec))
+		}
+	}()
+	return crl.loader.ListRolesE(ctx)
+}
+
+// loadRoleSafe by the incremental loader, the panic of the loader is returned as ErrRoleLoading
+func (crl *cachedRoleLoader) loadRoleSafe(ctx context.Context, name string) (role Role, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			role, err = nil, wrapError(ErrRoleLoading, fmt.Sprintf(`panic %v`, rec))
+		}
+	}()
+	return crl.loadRole(ctx, name)
+}
 
-	roles := crl.loader.ListRoles(ctx)
-	crl.rolesCache = make(map[string]Role, len(roles))
-	for _, role := range roles {
-		crl.rolesCache[role.Name()] = role
+// setLoadErrorHook of the loader failures
+func (crl *cachedRoleLoader) setLoadErrorHook(fn func(ctx context.Context, err error)) {
+	crl.onLoadError.Store(&fn)
+}
+
+// loadError passes the error to the hook
+func (crl *cachedRoleLoader) loadError(ctx context.Context, err error) {
+	if fn := crl.onLoadError.Load(); fn != nil {
+		(*fn)(ctx, err)
+	}
+}
+
+// runRefresher reloads the roles every interval with random jitter until the context is done
+func (crl *cachedRoleLoader) runRefresher(ctx context.Context, interval, jitter time.Duration) {
+	for {
+		delay := interval
+		if jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(jitter)))
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			crl.reload(ctx, crl.snapshot.Load())
+		}
 	}
-	crl.lastCacheUpdate = time.Now()
 }
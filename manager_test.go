@@ -2,7 +2,11 @@ package rbac
 
 import (
 	"context"
+	"errors"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -105,3 +109,378 @@ func TestManager(t *testing.T) {
 		assert.True(t, role.CheckPermissions(ctx, &testExt{}, `view.*`))
 	}
 }
+
+type testIncrementalLoader struct {
+	mx          sync.Mutex
+	roles       map[string]*PolicyRole
+	fail        bool
+	listCalls   int
+	loadCalls   []string
+	subscribers []func(names ...string)
+}
+
+func (l *testIncrementalLoader) ListRoles(context.Context) []Role {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.listCalls++
+	policy := Policy{}
+	for _, role := range l.roles {
+		policy.Roles = append(policy.Roles, *role)
+	}
+	roles, _ := policy.BuildRoles(nil)
+	return roles
+}
+
+func (l *testIncrementalLoader) LoadRole(_ context.Context, name string) Role {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.loadCalls = append(l.loadCalls, name)
+	if l.fail {
+		panic(`storage is not available`)
+	}
+	roles, _ := (&Policy{Roles: []PolicyRole{*l.roles[name]}}).BuildRoles(func(name string) Role {
+		roles, _ := (&Policy{Roles: []PolicyRole{*l.roles[name]}}).BuildRoles(nil)
+		return roles[0]
+	})
+	return roles[0]
+}
+
+func (l *testIncrementalLoader) SubscribeRoleChanges(fn func(names ...string)) {
+	l.subscribers = append(l.subscribers, fn)
+}
+
+// set the role without the change notification
+func (l *testIncrementalLoader) set(role *PolicyRole, fail bool) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.roles[role.Name], l.fail = role, fail
+}
+
+func (l *testIncrementalLoader) update(role *PolicyRole, fail bool) {
+	l.set(role, fail)
+	for _, fn := range l.subscribers {
+		fn(role.Name)
+	}
+}
+
+func (l *testIncrementalLoader) calls() (int, []string) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.listCalls, slices.Clone(l.loadCalls)
+}
+
+func TestManagerInvalidate(t *testing.T) {
+	ctx := context.TODO()
+	loader := &testIncrementalLoader{roles: map[string]*PolicyRole{
+		`viewer`: {Name: `viewer`, Permissions: []string{`view`}},
+		`editor`: {Name: `editor`, Roles: []string{`viewer`}, Permissions: []string{`edit`}},
+		`guest`:  {Name: `guest`},
+	}}
+	mng := NewManagerWithLoader(loader, time.Hour)
+	mng.RegisterPermission(MustNewSimplePermission(`view`), MustNewSimplePermission(`edit`))
+	crl := mng.roleAccessors.(*cachedRoleLoader)
+	reloaded := func() bool { return !crl.pending.Load() && !crl.refreshing.Load() }
+	canView := func(name string) bool { return mng.Role(ctx, name).CheckPermissions(ctx, nil, `view`) }
+
+	assert.True(t, canView(`editor`))
+	listCalls, _ := loader.calls()
+	assert.Equal(t, 1, listCalls)
+
+	// Change of the child role reloads only the role and roles which inherit it in the background
+	loader.update(&PolicyRole{Name: `viewer`}, false)
+	assert.Eventually(t, func() bool { return !canView(`editor`) && reloaded() }, time.Second, time.Millisecond)
+	assert.False(t, canView(`viewer`))
+	listCalls, loadCalls := loader.calls()
+	assert.ElementsMatch(t, []string{`viewer`, `editor`}, loadCalls)
+	assert.Equal(t, 1, listCalls)
+
+	// Manual invalidation
+	loader.set(&PolicyRole{Name: `guest`, Permissions: []string{`view`}}, false)
+	assert.False(t, canView(`guest`))
+	mng.Invalidate(`guest`)
+	assert.Eventually(t, func() bool { return canView(`guest`) && reloaded() }, time.Second, time.Millisecond)
+
+	// Failed role is kept, reads don't call the loader until the retry time
+	loader.update(&PolicyRole{Name: `guest`}, true)
+	assert.Eventually(t, func() bool {
+		assert.True(t, canView(`guest`))
+		_, loadCalls := loader.calls()
+		return len(loadCalls) == 4 && !crl.refreshing.Load()
+	}, time.Second, time.Millisecond)
+	for i := 0; i < 50; i++ {
+		assert.True(t, canView(`guest`))
+	}
+	_, loadCalls = loader.calls()
+	assert.Len(t, loadCalls, 4)
+	assert.True(t, crl.pending.Load())
+
+	loader.set(&PolicyRole{Name: `guest`}, false)
+	crl.retryAt.Store(0)
+	assert.Eventually(t, func() bool { return !canView(`guest`) && reloaded() }, time.Second, time.Millisecond)
+
+	mng.InvalidateAll()
+	assert.Eventually(t, func() bool {
+		listCalls, _ := loader.calls()
+		return len(mng.Roles(ctx)) == 3 && listCalls == 2 && reloaded()
+	}, time.Second, time.Millisecond)
+
+	// Not incremental loader reloads all roles
+	tm := NewManagerWithLoader(&testRoleLoader{}, time.Hour)
+	assert.NotNil(t, tm.Role(ctx, `test`))
+	tm.Invalidate(`test`)
+	assert.True(t, tm.roleAccessors.(*cachedRoleLoader).staleAll)
+	NewManager(nil).InvalidateAll()
+}
+
+type testSlowLoader struct {
+	mx      sync.Mutex
+	version int
+	fail    bool
+	block   chan struct{}
+	calls   atomic.Int32
+}
+
+func (l *testSlowLoader) ListRoles(context.Context) []Role {
+	l.calls.Add(1)
+	l.mx.Lock()
+	block, fail, version := l.block, l.fail, l.version
+	l.mx.Unlock()
+	if block != nil {
+		<-block
+	}
+	if fail {
+		panic(`storage is not available`)
+	}
+	return []Role{MustNewRole(`test`, WithExtData(version))}
+}
+
+func (l *testSlowLoader) set(version int, fail bool, block chan struct{}) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.version, l.fail, l.block = version, fail, block
+}
+
+func TestManagerStaleWhileRevalidate(t *testing.T) {
+	ctx := context.TODO()
+	loader := &testSlowLoader{version: 1}
+	mng := NewManagerWithLoader(loader, time.Millisecond*10)
+	assert.Equal(t, 1, mng.Role(ctx, `test`).Ext())
+
+	// Expired roles are returned while the new ones are loading
+	block := make(chan struct{})
+	loader.set(2, false, block)
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(t, 1, mng.Role(ctx, `test`).Ext())
+	assert.Equal(t, 1, mng.Role(ctx, `test`).Ext())
+	close(block)
+	assert.Eventually(t, func() bool { return mng.Role(ctx, `test`).Ext() == 2 },
+		time.Second, time.Millisecond)
+
+	// Failed loading keeps the last good roles
+	loader.set(3, true, nil)
+	time.Sleep(time.Millisecond * 20)
+	calls := loader.calls.Load()
+	assert.Eventually(t, func() bool {
+		return mng.Role(ctx, `test`) != nil && loader.calls.Load() > calls && !mng.roleAccessors.(*cachedRoleLoader).refreshing.Load()
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 2, mng.Role(ctx, `test`).Ext())
+	mng.InvalidateAll()
+	assert.Equal(t, 2, mng.Role(ctx, `test`).Ext())
+
+	loader.set(4, false, nil)
+	assert.Eventually(t, func() bool { return mng.Role(ctx, `test`).Ext() == 4 },
+		time.Second, time.Millisecond)
+
+	// Invalidated roles are loaded in the background too
+	block = make(chan struct{})
+	loader.set(5, false, block)
+	mng.InvalidateAll()
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, 4, mng.Role(ctx, `test`).Ext())
+	}
+	close(block)
+	assert.Eventually(t, func() bool { return mng.Role(ctx, `test`).Ext() == 5 },
+		time.Second, time.Millisecond)
+}
+
+func TestManagerBackgroundRefresh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	loader := &testSlowLoader{version: 1}
+	mng := NewManagerWithLoader(loader, time.Hour, WithBackgroundRefresh(ctx, time.Millisecond, time.Millisecond))
+	assert.Equal(t, 1, mng.Role(ctx, `test`).Ext())
+	loader.set(2, false, nil)
+	assert.Eventually(t, func() bool { return mng.Role(ctx, `test`).Ext() == 2 },
+		time.Second, time.Millisecond)
+
+	assert.Panics(t, func() { NewManager(nil, WithBackgroundRefresh(ctx, time.Second, 0)) })
+	assert.Panics(t, func() { NewManagerWithLoader(loader, time.Hour, WithBackgroundRefresh(ctx, 0, 0)) })
+	assert.Error(t, WithBackgroundRefresh(ctx, time.Second, 0)(&role{}))
+}
+
+type testErrorLoader struct {
+	mx    sync.Mutex
+	err   error
+	roles []Role
+	calls int
+}
+
+func (l *testErrorLoader) ListRolesE(context.Context) ([]Role, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.calls++
+	return l.roles, l.err
+}
+
+func (l *testErrorLoader) callCount() int {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.calls
+}
+
+func (l *testErrorLoader) set(err error, roles ...Role) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.err, l.roles = err, roles
+}
+
+func TestManagerLoadErrors(t *testing.T) {
+	var (
+		ctx        = context.TODO()
+		errStorage = errors.New(`storage is not available`)
+		loader     = &testErrorLoader{err: errStorage}
+		hookMx     sync.Mutex
+		hookErrs   []error
+	)
+	hookCount := func() int {
+		hookMx.Lock()
+		defer hookMx.Unlock()
+		return len(hookErrs)
+	}
+	mng := NewManagerWithLoaderE(loader, time.Hour,
+		WithLoadErrorHook(func(_ context.Context, err error) {
+			hookMx.Lock()
+			defer hookMx.Unlock()
+			hookErrs = append(hookErrs, err)
+		}))
+	crl := mng.roleAccessors.(*cachedRoleLoader)
+	mng.RegisterPermission(MustNewSimplePermission(`view`))
+	mng.RegisterRole(ctx, MustNewRole(`local`, WithPermissions(`view`)))
+
+	// Roles have never been loaded
+	role, err := mng.RoleE(ctx, `test`)
+	assert.Nil(t, role)
+	assert.ErrorIs(t, err, errStorage)
+	assert.Equal(t, 1, hookCount())
+
+	decision := mng.Check(ctx, NewSubject(1, 1, `test`), nil, `view`)
+	assert.Equal(t, Deny, decision.Effect)
+	assert.ErrorIs(t, decision.Err, errStorage)
+	allowed, err := mng.CheckPermissionsE(ctx, []string{`test`}, nil, `view`)
+	assert.False(t, allowed)
+	assert.ErrorIs(t, err, errStorage)
+
+	// Registered roles don't depend on the loader
+	role, err = mng.RoleE(ctx, `local`)
+	assert.NoError(t, err)
+	assert.NotNil(t, role)
+
+	// Invalidated roles are loaded synchronously if there are no good roles to serve
+	loader.set(nil, MustNewRole(`test`, WithPermissions(`view`)))
+	mng.InvalidateAll()
+	decision = mng.Check(ctx, NewSubject(1, 1, `test`), nil, `view`)
+	assert.True(t, decision.Allowed())
+	assert.NoError(t, decision.Err)
+
+	// Failure after the successful loading keeps the last good roles
+	loader.set(errStorage)
+	mng.InvalidateAll()
+	roles, err := mng.RolesE(ctx, `test`, `local`)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 2)
+	assert.Eventually(t, func() bool { return hookCount() == 2 && !crl.refreshing.Load() }, time.Second, time.Millisecond)
+
+	// Failed reload is retried after the part of the lifetime, reads don't call the loader
+	calls := loader.callCount()
+	for i := 0; i < 50; i++ {
+		roles, err = mng.RolesE(ctx, `test`)
+		assert.NoError(t, err)
+		assert.Len(t, roles, 1)
+	}
+	assert.Equal(t, calls, loader.callCount())
+	assert.True(t, crl.pending.Load())
+
+	loader.set(nil, MustNewRole(`test`))
+	crl.retryAt.Store(0)
+	assert.Eventually(t, func() bool {
+		role, err := mng.RoleE(ctx, `test`)
+		return err == nil && len(role.Permissions()) == 0
+	}, time.Second, time.Millisecond)
+
+	// Panic of the loader is the error
+	_, err = NewManagerWithLoader(roleLoaderFunc(func(context.Context) []Role { panic(`boom`) }), time.Hour).RolesE(ctx)
+	assert.ErrorIs(t, err, ErrRoleLoading)
+}
+
+func TestRoleLoaderAdapters(t *testing.T) {
+	ctx := context.TODO()
+	errStorage := errors.New(`storage is not available`)
+
+	roles, err := AdaptRoleLoader(&testRoleLoader{}).ListRolesE(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 1)
+	loader := RoleLoaderFromE(&testErrorLoader{err: errStorage})
+	assert.Nil(t, loader.ListRoles(ctx))
+	_, err = AdaptRoleLoader(loader).ListRolesE(ctx)
+	assert.ErrorIs(t, err, errStorage)
+
+	// Accessors without cache report errors to the hook on every access
+	var hookErrs []error
+	accessors := RoleAccessorsFromE(NewManagerWithLoaderE(&testErrorLoader{err: errStorage}, time.Hour))
+	assert.Nil(t, accessors.Role(ctx, `test`))
+	mng := NewManager(accessors,
+		WithLoadErrorHook(func(_ context.Context, err error) { hookErrs = append(hookErrs, err) }))
+	_, err = mng.RolesByFilterE(ctx, func(context.Context, Role) bool { return true })
+	assert.ErrorIs(t, err, errStorage)
+	_, err = mng.RolesE(ctx)
+	assert.ErrorIs(t, err, errStorage)
+	assert.Len(t, hookErrs, 2)
+
+	plain := AdaptRoleAccessors(struct{ RoleAccessors }{NewManagerWithLoader(&testRoleLoader{}, time.Hour)})
+	role, err := plain.RoleE(ctx, `test`)
+	assert.NoError(t, err)
+	assert.NotNil(t, role)
+	assert.Panics(t, func() { NewManager(nil, WithLoadErrorHook(nil)) })
+}
+
+func TestManagerLoadedRolesConcurrent(t *testing.T) {
+	ctx := context.TODO()
+	loader := roleLoaderFunc(func(context.Context) []Role {
+		viewer := MustNewRole(`viewer`, WithPermissions(`doc.*`))
+		return []Role{viewer, MustNewRole(`editor`, WithChildRoles(viewer), WithPermissions(`!doc.delete`, `doc.edit`))}
+	})
+	mng := NewManagerWithLoader(loader, time.Millisecond, WithFlattenedRoles())
+	mng.RegisterPermission(MustNewSimplePermission(`doc.view`), MustNewSimplePermission(`doc.edit`),
+		MustNewSimplePermission(`doc.delete`))
+
+	// Roles of the cache are prepared before they are shared by the readers
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				allowed, err := mng.CheckPermissionsE(ctx, []string{`editor`}, nil, `doc.edit`)
+				assert.NoError(t, err)
+				assert.True(t, allowed)
+				allowed, _ = mng.CheckPermissionsE(ctx, []string{`editor`}, nil, `doc.delete`)
+				assert.False(t, allowed)
+				if j%50 == 0 {
+					mng.Invalidate(`viewer`)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,127 @@
+package rbac
+
+import "strings"
+
+// nameTrie indexes values by dot-separated blocks of the names
+// so pattern lookups walk only the matching branches
+type nameTrie[T any] struct {
+	root trieNode[T]
+	size int
+}
+
+type trieNode[T any] struct {
+	value    T
+	set      bool
+	children map[string]*trieNode[T]
+}
+
+// len returns number of indexed names
+func (t *nameTrie[T]) len() int {
+	return t.size
+}
+
+// put the value by name, replaces the value with the same name
+func (t *nameTrie[T]) put(name string, value T) {
+	node := &t.root
+	for _, block := range strings.Split(name, `.`) {
+		child := node.children[block]
+		if child == nil {
+			if node.children == nil {
+				node.children = make(map[string]*trieNode[T])
+			}
+			child = &trieNode[T]{}
+			node.children[block] = child
+		}
+		node = child
+	}
+	if !node.set {
+		t.size++
+	}
+	node.value, node.set = value, true
+}
+
+// get value by the exact name
+func (t *nameTrie[T]) get(name string) (value T, ok bool) {
+	node := &t.root
+	for _, block := range strings.Split(name, `.`) {
+		if node = node.children[block]; node == nil {
+			return value, false
+		}
+	}
+	return node.value, node.set
+}
+
+// all returns all indexed values
+func (t *nameTrie[T]) all() []T {
+	list := make([]T, 0, t.size)
+	t.root.collectNodes(func(node *trieNode[T]) { list = append(list, node.value) })
+	return list
+}
+
+// match returns values which names match any of the patterns,
+// invalid patterns match nothing
+func (t *nameTrie[T]) match(patterns ...string) []T {
+	var (
+		list []T
+		seen map[*trieNode[T]]bool
+	)
+	if len(patterns) > 1 {
+		seen = make(map[*trieNode[T]]bool)
+	}
+	for _, pattern := range patterns {
+		compiled, _ := compilePatternCached(pattern)
+		if compiled == nil {
+			continue
+		}
+		if compiled.any {
+			return t.all()
+		}
+		t.root.walk(compiled.parts, func(node *trieNode[T]) {
+			if seen != nil {
+				if seen[node] {
+					return
+				}
+				seen[node] = true
+			}
+			list = append(list, node.value)
+		})
+	}
+	return list
+}
+
+// walk calls fn for every node with value matching the pattern parts
+func (n *trieNode[T]) walk(parts []patternPart, fn func(node *trieNode[T])) {
+	if len(parts) == 0 {
+		if n.set {
+			fn(n)
+		}
+		return
+	}
+	part := &parts[0]
+	if part.kind == partLiteral {
+		if child := n.children[part.value]; child != nil {
+			child.walk(parts[1:], fn)
+		}
+		return
+	}
+	for block, child := range n.children {
+		// Empty blocks are never matched by patterns
+		if block == `` || !part.match(block) {
+			continue
+		}
+		if part.kind == partTail {
+			child.collectNodes(fn)
+		} else {
+			child.walk(parts[1:], fn)
+		}
+	}
+}
+
+func (n *trieNode[T]) collectNodes(fn func(node *trieNode[T])) {
+	if n.set {
+		fn(n)
+	}
+	for _, child := range n.children {
+		child.collectNodes(fn)
+	}
+}
@@ -0,0 +1,118 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameTrie(t *testing.T) {
+	var trie nameTrie[Permission]
+	names := []string{
+		`access`, `rbac.testObject.view.owner`, `rbac.testObject.view.all`,
+		`rbac.testObject.edit.owner`, `rbac.testExt.view.owner`, `rbac.testExt.list`,
+	}
+	for _, name := range names {
+		trie.put(name, MustNewSimplePermission(name))
+	}
+	trie.put(`access`, MustNewSimplePermission(`access`, WithDescription(`replaced`)))
+	assert.Equal(t, len(names), trie.len())
+	assert.Len(t, trie.all(), len(names))
+	perm, ok := trie.get(`access`)
+	assert.True(t, ok)
+	assert.Equal(t, `replaced`, perm.(*SimplePermission).Description())
+	_, ok = trie.get(`rbac.testObject`)
+	assert.False(t, ok)
+	_, ok = trie.get(`rbac.unknown.view`)
+	assert.False(t, ok)
+
+	// The index must select the same permissions as the full scan
+	patterns := [][]string{
+		{`*`}, {`**`}, {`access`}, {`rbac.*`}, {`rbac.**`}, {`rbac.*.view.*`},
+		{`rbac.testObject.{view|edit}.owner`}, {`rbac.test???.list`}, {`rbac.%r{Ext$}.*`},
+		{`rbac.*.view.owner`, `rbac.testObject.**`}, {`rbac.**.owner`}, {`unknown.*`},
+	}
+	for _, pattern := range patterns {
+		var expected []string
+		for _, name := range names {
+			if checkPattern(name, pattern...) {
+				expected = append(expected, name)
+			}
+		}
+		var actual []string
+		for _, perm := range trie.match(pattern...) {
+			actual = append(actual, perm.Name())
+		}
+		assert.ElementsMatch(t, expected, actual, pattern)
+	}
+}
+
+// testAliasPermission matches the patterns by the name and the alias
+type testAliasPermission struct {
+	*SimplePermission
+	alias string
+}
+
+func (p *testAliasPermission) MatchPermissionPattern(patterns ...string) bool {
+	return p.SimplePermission.MatchPermissionPattern(patterns...) || checkPattern(p.alias, patterns...)
+}
+
+func TestManagerPermissionsCustomMatch(t *testing.T) {
+	mng := NewManager(nil)
+	mng.RegisterPermission(
+		MustNewSimplePermission(`report.view`),
+		&testAliasPermission{SimplePermission: MustNewSimplePermission(`report.export`), alias: `legacy.download`},
+		&testAliasPermission{SimplePermission: MustNewSimplePermission(`report.hidden`), alias: `legacy.hidden`},
+	)
+	names := func(perms []Permission) (list []string) {
+		for _, perm := range perms {
+			list = append(list, perm.Name())
+		}
+		return list
+	}
+	assert.ElementsMatch(t, []string{`report.export`}, names(mng.Permissions(`legacy.download`)))
+	assert.ElementsMatch(t, []string{`report.view`, `report.export`, `report.hidden`}, names(mng.Permissions(`report.*`)))
+	assert.Len(t, mng.Permissions(), 3)
+
+	// Custom permission replaced by the simple one is matched by the name only
+	mng.RegisterPermission(MustNewSimplePermission(`report.export`))
+	assert.Empty(t, mng.Permissions(`legacy.download`))
+	assert.Len(t, mng.Permissions(`report.*`), 3)
+}
+
+func benchmarkManager(count int) *Manager {
+	mng := NewManager(nil)
+	actions := []string{`view`, `list`, `edit`, `delete`, `create`}
+	for i := 0; i < count/(len(actions)*len(owningTypes)); i++ {
+		for _, action := range actions {
+			for _, own := range owningTypes {
+				mng.RegisterPermission(MustNewSimplePermission(fmt.Sprintf(`object%d.%s.%s`, i, action, own)))
+			}
+		}
+	}
+	return mng
+}
+
+func BenchmarkManagerPermissions(b *testing.B) {
+	for _, count := range []int{10_000, 100_000} {
+		mng := benchmarkManager(count)
+		b.Run(fmt.Sprintf(`%d/exact`, count), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = mng.Permissions(`object42.view.owner`)
+			}
+		})
+		b.Run(fmt.Sprintf(`%d/object`, count), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = mng.Permissions(`object42.*.*`)
+			}
+		})
+		b.Run(fmt.Sprintf(`%d/prepare`, count), func(b *testing.B) {
+			ctx := context.Background()
+			for i := 0; i < b.N; i++ {
+				_ = mng.prepareRole(ctx, MustNewRole(`editor`, WithPermissions(`object1.**`, `object2.{view|list}.*`, `!object2.delete.*`)))
+			}
+		})
+	}
+}
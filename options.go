@@ -1,8 +1,12 @@
 package rbac
 
 import (
+	"context"
 	"errors"
 	"reflect"
+	"slices"
+	"strings"
+	"time"
 )
 
 var (
@@ -33,11 +37,17 @@ func WithDescription(description string) Option {
 	}
 }
 
-// WithChildRoles of the role
+// WithChildRoles of the role, returns error if the role hierarchy has cycles
 func WithChildRoles(roles ...Role) Option {
 	return func(obj any) error {
 		switch o := obj.(type) {
 		case *role:
+			valid := map[string]bool{}
+			for _, child := range roles {
+				if err := validateRoleHierarchy(child, []string{o.name}, valid); err != nil {
+					return err
+				}
+			}
 			o.roles = roles
 		default:
 			return wrapError(ErrInvalidOption, `WithChildRoles`)
@@ -47,6 +57,9 @@ func WithChildRoles(roles ...Role) Option {
 }
 
 // WithPermissions apply subpermission
+//
+// String values are used as wildcard patterns to preload permissions from the manager,
+// patterns with `!` prefix preload permissions with Deny effect, e.g. `!user.delete.*`
 func WithPermissions(permissions ...any) Option {
 	vecPermissions := make([]Permission, 0, len(permissions))
 	verPermPreload := make([]string, 0, len(permissions))
@@ -86,58 +99,377 @@ func WithPermissions(permissions ...any) Option {
 	}
 }
 
+// WithEffect of the permission
+// Example:
+//
+//	perm := NewResourcePermission(`delete`, &model.User{}, WithEffect(Deny))
+func WithEffect(effect Effect) Option {
+	return func(obj any) error {
+		if effect != Allow && effect != Deny {
+			return wrapError(ErrInvalidOptionParam, `WithEffect`)
+		}
+		switch o := obj.(type) {
+		case *SimplePermission:
+			o.effect = effect
+		case *ResourcePermission:
+			o.effect = effect
+		default:
+			return wrapError(ErrInvalidOption, `WithEffect`)
+		}
+		return nil
+	}
+}
+
+// WithCombiningAlgorithm of allow and deny permissions for the role or manager
+func WithCombiningAlgorithm(alg CombiningAlgorithm) Option {
+	return func(obj any) error {
+		if !alg.valid() {
+			return wrapError(ErrInvalidOptionParam, `WithCombiningAlgorithm`)
+		}
+		switch o := obj.(type) {
+		case *role:
+			o.combining = alg
+		case *Manager:
+			o.combining = alg
+		default:
+			return wrapError(ErrInvalidOption, `WithCombiningAlgorithm`)
+		}
+		return nil
+	}
+}
+
+// WithSubjectCombiningAlgorithm of the subject roles decisions for the manager
+//
+// For example AllowOverrides grants access if any role of the subject allows it
+// even if another role denies.
+func WithSubjectCombiningAlgorithm(alg CombiningAlgorithm) Option {
+	return func(obj any) error {
+		if !alg.valid() {
+			return wrapError(ErrInvalidOptionParam, `WithSubjectCombiningAlgorithm`)
+		}
+		switch o := obj.(type) {
+		case *Manager:
+			o.subjectCombining = alg
+		default:
+			return wrapError(ErrInvalidOption, `WithSubjectCombiningAlgorithm`)
+		}
+		return nil
+	}
+}
+
+// WithBindings defines the storage of the subject role bindings of the manager,
+// the in-memory store is used by default
+func WithBindings(store BindingStore) Option {
+	return func(obj any) error {
+		mng, _ := obj.(*Manager)
+		if mng == nil || store == nil {
+			return wrapError(ErrInvalidOption, `WithBindings`)
+		}
+		mng.bindings = NewBindings(store)
+		return nil
+	}
+}
+
+// WithFlattenedRoles compiles every role registered or loaded by the manager into the flattened index
+// of effective permissions including child roles
+//
+// Checks of the indexed role evaluate only permissions selected by the names of the check patterns
+// instead of the walk through the whole hierarchy. The index is rebuilt when roles are changed,
+// hierarchies with different combining algorithms of the roles are evaluated as the tree.
+func WithFlattenedRoles() Option {
+	return func(obj any) error {
+		switch o := obj.(type) {
+		case *Manager:
+			o.flattenRoles = true
+		default:
+			return wrapError(ErrInvalidOption, `WithFlattenedRoles`)
+		}
+		return nil
+	}
+}
+
+// WithOwningScope of the resource permission which name ends with the scope (owner, account, all)
+//
+// The scope is checked automatically for resources implementing OwnerIDer and AccountIDer
+// by the subject of the context (see WithSubject).
+func WithOwningScope(scope string) Option {
+	return func(obj any) error {
+		if !slices.Contains(owningTypes, scope) {
+			return wrapError(ErrInvalidOptionParam, `WithOwningScope`)
+		}
+		switch o := obj.(type) {
+		case *ResourcePermission:
+			if !strings.HasSuffix(o.name, `.`+scope) {
+				return wrapError(ErrInvalidOptionParam, `WithOwningScope::(permission name must end with .`+scope+`)`)
+			}
+			o.owning = scope
+		default:
+			return wrapError(ErrInvalidOption, `WithOwningScope`)
+		}
+		return nil
+	}
+}
+
+// WithBackgroundRefresh of the manager roles cache every interval with random jitter
+// until the context is done
+//
+// The manager must be created with the role loader (NewManagerWithLoader, NewManagerWithStore).
+// The jitter spreads reloads of many instances sharing the same storage.
+func WithBackgroundRefresh(ctx context.Context, interval, jitter time.Duration) Option {
+	return func(obj any) error {
+		if interval <= 0 || jitter < 0 {
+			return wrapError(ErrInvalidOptionParam, `WithBackgroundRefresh`)
+		}
+		mng, _ := obj.(*Manager)
+		if mng == nil {
+			return wrapError(ErrInvalidOption, `WithBackgroundRefresh`)
+		}
+		crl, _ := mng.roleAccessors.(*cachedRoleLoader)
+		if crl == nil {
+			return wrapError(ErrInvalidOption, `WithBackgroundRefresh::(manager without role loader)`)
+		}
+		go crl.runRefresher(ctx, interval, jitter)
+		return nil
+	}
+}
+
+// WithLoadErrorHook of the manager called on every failure of the role loader or accessors
+//
+// The cache of the role loader keeps the last loaded roles on failure,
+// the hook is the way to log and monitor such failures.
+func WithLoadErrorHook(fn func(ctx context.Context, err error)) Option {
+	return func(obj any) error {
+		mng, _ := obj.(*Manager)
+		if mng == nil || fn == nil {
+			return wrapError(ErrInvalidOption, `WithLoadErrorHook`)
+		}
+		mng.onLoadError = fn
+		if crl, _ := mng.roleAccessors.(*cachedRoleLoader); crl != nil {
+			crl.setLoadErrorHook(fn)
+		}
+		return nil
+	}
+}
+
 // WithCustomCheck function and additional data if need to use in checker
+//
+// The callback must have the signature `func(context.Context, <resource type>, Permission) bool`
+// or `func(context.Context, <resource type>, Permission) (bool, error)`,
+// use WithCheck or WithCheckE for the compile-time type safety.
+// The acting subject and request data are available in the callback by SubjectFromContext and ExtData.
 // Example:
 //
-//	callback := func(ctx context.Context, resource any, names ...string) bool {
-//	  return ExtData(ctx).(*model.RoleContext).DebugMode
+//	callback := func(ctx context.Context, resource any, perm Permission) bool {
+//	  return perm.Ext().(*model.RoleContext).DebugMode
 //	}
 //	perm := NewResourcePermission(`view`, &model.User{}, WithCustomCheck(callback, &roleContext))
 func WithCustomCheck(f any, data ...any) Option {
+	return func(obj any) error {
+		fn, argType, err := reflectCheckFunc(f)
+		if err != nil {
+			return wrapError(ErrInvalidOptionParam, `WithCustomCheck::callback`)
+		}
+		return setCheckFunc(obj, `WithCustomCheck`, fn, argType, funcName(f), data...)
+	}
+}
+
+// WithCheck typed function and additional data if need to use in checker
+//
+// The callback is called only for resources of the type T.
+// Example:
+//
+//	perm := NewResourcePermission(`view`, &model.User{},
+//	  WithCheck(func(ctx context.Context, user *model.User, perm Permission) bool {
+//	    return user.IsActive()
+//	  }))
+func WithCheck[T any](f func(ctx context.Context, resource T, perm Permission) bool, data ...any) Option {
 	return func(obj any) error {
 		if f == nil {
-			return wrapError(ErrInvalidOptionParam, `WithCustomCheck`)
+			return wrapError(ErrInvalidOptionParam, `WithCheck`)
 		}
-		var dataVal any
-		if len(data) > 0 {
-			dataVal = data[0]
+		return setCheckFunc(obj, `WithCheck`, typedCheckFunc(f),
+			reflect.TypeOf((*T)(nil)).Elem(), funcName(f), data...)
+	}
+}
+
+// WithCheckE typed function which can fail and additional data if need to use in checker
+//
+// The error of the callback denies the access and is returned by the CheckPermissionsE methods.
+// Example:
+//
+//	perm := NewResourcePermission(`view`, &model.User{},
+//	  WithCheckE(func(ctx context.Context, user *model.User, perm Permission) (bool, error) {
+//	    return repo.IsMember(ctx, user.ID)
+//	  }))
+func WithCheckE[T any](f func(ctx context.Context, resource T, perm Permission) (bool, error), data ...any) Option {
+	return func(obj any) error {
+		if f == nil {
+			return wrapError(ErrInvalidOptionParam, `WithCheckE`)
+		}
+		return setCheckFunc(obj, `WithCheckE`, typedCheckFuncE(f),
+			reflect.TypeOf((*T)(nil)).Elem(), funcName(f), data...)
+	}
+}
+
+func setCheckFunc(obj any, optName string, fn checkFunc, argType reflect.Type, name string, data ...any) error {
+	var dataVal any
+	if len(data) > 0 {
+		dataVal = data[0]
+	}
+	switch o := obj.(type) {
+	case *SimplePermission:
+		o.checkFnk = fn
+		o.checkFnkName = name
+		o.extData = dataVal
+	case *ResourcePermission:
+		if !checkResourceArgType(argType, o.resType) {
+			return wrapError(ErrInvalidOptionParam, optName+`::(callback invalid argument != resource.Type)`)
+		}
+		o.checkFnk = fn
+		o.checkFnkName = name
+		o.extData = dataVal
+	default:
+		return wrapError(ErrInvalidOption, optName)
+	}
+	return nil
+}
+
+// WithoutCustomCheck remove custom check
+func WithoutCustomCheck(obj any) error {
+	switch o := obj.(type) {
+	case *SimplePermission:
+		o.checkFnk = nil
+	case *ResourcePermission:
+		o.checkFnk = nil
+	default:
+		return wrapError(ErrInvalidOption, `WithoutCustomCheck`)
+	}
+	return nil
+}
+
+// WithCondition of the permission which must be true to match the permission
+//
+// See Condition for the syntax of the expression.
+// Example:
+//
+//	perm := NewResourcePermission(`edit`, &model.Article{},
+//	  WithCondition(`resource.status == "draft" && subject.department == resource.department`))
+func WithCondition(expr string) Option {
+	return func(obj any) error {
+		cond, err := CompileCondition(expr)
+		if err != nil {
+			return wrapError(err, `WithCondition`)
 		}
 		switch o := obj.(type) {
 		case *SimplePermission:
-			o.checkFnk = reflect.ValueOf(f)
-			ftype := o.checkFnk.Type()
-			if ftype.NumIn() != 3 {
-				return wrapError(ErrInvalidOptionParam, `WithCustomCheck::callback`)
-			}
-			o.checkFnkResType = ftype.In(0)
-			o.extData = dataVal
+			o.condition = cond
 		case *ResourcePermission:
-			o.checkFnk = reflect.ValueOf(f)
-			ftype := o.checkFnk.Type()
-			if ftype.NumIn() != 3 {
-				return wrapError(ErrInvalidOptionParam, `WithCustomCheck::callback`)
-			}
-			o.checkFnkResType = ftype.In(0)
-			if o.checkFnkResType.Kind() != reflect.Interface && o.checkFnkResType != o.resType {
-				return wrapError(ErrInvalidOptionParam, `WithCustomCheck::(callback invalid argument != resource.Type)`)
-			}
-			o.extData = dataVal
+			o.condition = cond
 		default:
-			return wrapError(ErrInvalidOption, `WithCustomCheck`)
+			return wrapError(ErrInvalidOption, `WithCondition`)
 		}
 		return nil
 	}
 }
 
-// WithoutCustomCheck remove custom check
-func WithoutCustomCheck(obj any) error {
+// WithPatternConditions of the role permissions preloaded by the patterns (see WithPermissions)
+//
+// Permissions loaded by the pattern match only if the condition of the pattern is true.
+// Example:
+//
+//	role := NewRole(`editor`,
+//	  WithPermissions(`article.view.*`, `article.edit.*`),
+//	  WithPatternConditions(map[string]string{`article.edit.*`: `resource.status == "draft"`}))
+func WithPatternConditions(conditions map[string]string) Option {
+	return func(obj any) error {
+		o, _ := obj.(*role)
+		if o == nil {
+			return wrapError(ErrInvalidOption, `WithPatternConditions`)
+		}
+		if o.conditions == nil {
+			o.conditions = make(map[string]*Condition, len(conditions))
+		}
+		for pattern, expr := range conditions {
+			cond, err := CompileCondition(expr)
+			if err != nil {
+				return wrapError(err, `WithPatternConditions::`+pattern)
+			}
+			o.conditions[pattern] = cond
+		}
+		return nil
+	}
+}
+
+// WithValidity of the role or the permission in the period [from, to), zero time is not limited
+//
+// The role or the permission doesn't match out of the period, expired grants
+// are also skipped by Permissions and the policy export.
+// Example:
+//
+//	role := NewRole(`oncall`, WithPermissions(`incident.*`),
+//	  WithValidity(time.Now(), time.Now().Add(8*time.Hour)))
+func WithValidity(from, to time.Time) Option {
+	return func(obj any) error {
+		if !from.IsZero() && !to.IsZero() && !to.After(from) {
+			return wrapError(ErrInvalidOptionParam, `WithValidity (empty period)`)
+		}
+		return updateTimeWindow(obj, `WithValidity`, func(w *timeWindow) {
+			w.from, w.to = from, to
+		})
+	}
+}
+
+// WithSchedule of the role or the permission, the grant is active if any schedule matches
+// the current time (see Schedule for the syntax)
+//
+// Example:
+//
+//	perm := NewSimplePermission(`deploy`, WithSchedule(`* 9-17 * * mon-fri`))
+func WithSchedule(specs ...string) Option {
+	return func(obj any) error {
+		schedules := make([]*Schedule, 0, len(specs))
+		for _, spec := range specs {
+			schedule, err := ParseSchedule(spec)
+			if err != nil {
+				return wrapError(err, `WithSchedule`)
+			}
+			schedules = append(schedules, schedule)
+		}
+		return updateTimeWindow(obj, `WithSchedule`, func(w *timeWindow) {
+			w.schedules = append(w.schedules, schedules...)
+		})
+	}
+}
+
+func updateTimeWindow(obj any, option string, update func(w *timeWindow)) error {
+	var window **timeWindow
 	switch o := obj.(type) {
+	case *role:
+		window = &o.window
 	case *SimplePermission:
-		o.checkFnk = reflect.Value{}
+		window = &o.window
 	case *ResourcePermission:
-		o.checkFnk = reflect.Value{}
+		window = &o.window
 	default:
-		return wrapError(ErrInvalidOption, `WithoutCustomCheck`)
+		return wrapError(ErrInvalidOption, option)
+	}
+	if *window == nil {
+		*window = &timeWindow{}
+	}
+	update(*window)
+	return nil
+}
+
+// WithoutDecisionCache excludes checks of the permission from the decision cache
+// if the check callback depends on the state which can change during the request
+func WithoutDecisionCache(obj any) error {
+	switch o := obj.(type) {
+	case *SimplePermission:
+		o.noCache = true
+	case *ResourcePermission:
+		o.noCache = true
+	default:
+		return wrapError(ErrInvalidOption, `WithoutDecisionCache`)
 	}
 	return nil
 }
@@ -0,0 +1,66 @@
+package rbac
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// OwnerIDer is the resource which has the owner, used by the `owner` scope of owning permissions
+type OwnerIDer interface {
+	RBACOwnerID() uint64
+}
+
+// AccountIDer is the resource which belongs to the account, used by the `account` scope of owning permissions
+type AccountIDer interface {
+	RBACAccountID() uint64
+}
+
+// ownerRank is the rank of the narrowest owning scope
+const ownerRank = 1
+
+// owningRank returns rank of the owning scope of the permission,
+// the narrowest scope has the lowest rank and 0 means that permission has no scope
+func owningRank(perm Permission) int {
+	type scoper interface {
+		OwningScope() string
+	}
+	if sp, ok := perm.(scoper); ok {
+		return slices.Index(owningTypes, sp.OwningScope()) + 1
+	}
+	return 0
+}
+
+// checkOwning of the resource by the subject of the context
+//
+//	owner   - resource implements OwnerIDer and the subject ID equals to the owner ID
+//	account - resource implements AccountIDer and the subject account ID equals to the resource account ID
+//	all     - always passes
+//
+// Resources which do not implement the interface of the scope are not matched (fail closed),
+// unless custom is set: the permission has the check callback which decides instead,
+// or the permission denies access and must apply to such resources.
+func checkOwning(ctx context.Context, scope string, resource any, custom bool) bool {
+	switch scope {
+	case OwnOwner:
+		if owner, ok := resource.(OwnerIDer); ok {
+			subject := SubjectFromContext(ctx)
+			return subject != nil && subject.RBACSubjectID() != 0 &&
+				subject.RBACSubjectID() == owner.RBACOwnerID()
+		}
+		return custom
+	case OwnAccount:
+		if account, ok := resource.(AccountIDer); ok {
+			subject := SubjectFromContext(ctx)
+			return subject != nil && subject.RBACAccountID() != 0 &&
+				subject.RBACAccountID() == account.RBACAccountID()
+		}
+		return custom
+	}
+	return true
+}
+
+// owningAction returns the permission name without the owning scope suffix
+func owningAction(name, scope string) string {
+	return strings.TrimSuffix(name, `.`+scope)
+}
@@ -0,0 +1,89 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testDocument struct {
+	ownerID   uint64
+	accountID uint64
+}
+
+func (d *testDocument) RBACOwnerID() uint64   { return d.ownerID }
+func (d *testDocument) RBACAccountID() uint64 { return d.accountID }
+
+func TestOwningPermissions(t *testing.T) {
+	ctx := context.TODO()
+	mng := NewManager(nil)
+	assert.NoError(t, mng.RegisterNewOwningPermissions((*testDocument)(nil), []string{`view`, `edit`}))
+	mng.RegisterRole(ctx,
+		MustNewRole(`user`, WithPermissions(`rbac.testDocument.*.owner`, `rbac.testDocument.view.account`)),
+		MustNewRole(`admin`, WithPermissions(`rbac.testDocument.*.*`)),
+	)
+
+	doc := &testDocument{ownerID: 1, accountID: 10}
+	tests := []struct {
+		name       string
+		subject    Subject
+		pattern    string
+		allowed    bool
+		permission string
+	}{
+		{name: `owner`, subject: NewSubject(1, 10, `user`), pattern: `edit`, allowed: true, permission: `rbac.testDocument.edit.owner`},
+		{name: `owner-scope`, subject: NewSubject(1, 10, `user`), pattern: `edit.owner`, allowed: true, permission: `rbac.testDocument.edit.owner`},
+		{name: `not-owner`, subject: NewSubject(2, 10, `user`), pattern: `edit`, allowed: false},
+		{name: `account`, subject: NewSubject(2, 10, `user`), pattern: `view`, allowed: true, permission: `rbac.testDocument.view.account`},
+		{name: `other-account`, subject: NewSubject(2, 11, `user`), pattern: `view`, allowed: false},
+		{name: `no-subject-id`, subject: NewSubject(0, 0, `user`), pattern: `view.*`, allowed: false},
+		{name: `all`, subject: NewSubject(3, 11, `admin`), pattern: `edit`, allowed: true, permission: `rbac.testDocument.edit.all`},
+		{name: `narrowest-owner`, subject: NewSubject(1, 10, `admin`), pattern: `edit`, allowed: true, permission: `rbac.testDocument.edit.owner`},
+		{name: `narrowest-account`, subject: NewSubject(2, 10, `admin`, `user`), pattern: `view.*`, allowed: true, permission: `rbac.testDocument.view.account`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := mng.Check(ctx, test.subject, doc, test.pattern)
+			assert.Equal(t, test.allowed, decision.Allowed())
+			if test.allowed {
+				assert.Equal(t, test.permission, decision.PermissionName())
+			}
+		})
+	}
+
+	t.Run(`context`, func(t *testing.T) {
+		role := mng.Role(ctx, `user`)
+		assert.False(t, role.CheckPermissions(ctx, doc, `edit`))
+		assert.True(t, role.CheckPermissions(WithSubject(ctx, NewSubject(1, 10)), doc, `edit`))
+	})
+
+	t.Run(`without-interfaces`, func(t *testing.T) {
+		mng := NewManager(nil)
+		assert.NoError(t, mng.RegisterNewOwningPermissions((*testObject)(nil), []string{`view`}))
+		mng.RegisterRole(ctx, MustNewRole(`user`, WithPermissions(`rbac.testObject.view.owner`)))
+		assert.False(t, mng.Check(ctx, NewSubject(1, 10, `user`), &testObject{}, `view`).Allowed())
+
+		// Deny permissions of the scope still apply
+		mng.RegisterRole(ctx, MustNewRole(`guest`, WithPermissions(`rbac.testObject.view.all`, `!rbac.testObject.view.owner`)))
+		assert.False(t, mng.Check(ctx, NewSubject(1, 10, `guest`), &testObject{}, `view`).Allowed())
+
+		// The custom check decides about the owning of the resource without interfaces
+		mng = NewManager(nil)
+		assert.NoError(t, mng.RegisterNewOwningPermissions((*testObject)(nil), []string{`view`},
+			WithCustomCheck(func(ctx context.Context, obj *testObject, perm Permission) bool {
+				return obj.name == `shared`
+			})))
+		mng.RegisterRole(ctx, MustNewRole(`user`, WithPermissions(`rbac.testObject.view.owner`)))
+		assert.True(t, mng.Check(ctx, NewSubject(1, 10, `user`), &testObject{name: `shared`}, `view`).Allowed())
+		assert.False(t, mng.Check(ctx, NewSubject(1, 10, `user`), &testObject{}, `view`).Allowed())
+	})
+
+	perm := mng.Permission(`rbac.testDocument.view.account`)
+	if assert.NotNil(t, perm) {
+		assert.Equal(t, OwnAccount, perm.(*ResourcePermission).OwningScope())
+	}
+	assert.Error(t, WithOwningScope(`team`)(&ResourcePermission{SimplePermission: SimplePermission{name: `view.team`}}))
+	assert.Error(t, WithOwningScope(OwnOwner)(&ResourcePermission{SimplePermission: SimplePermission{name: `view`}}))
+	assert.Error(t, WithOwningScope(OwnOwner)(&SimplePermission{name: `view.owner`}))
+}
@@ -0,0 +1,220 @@
+package rbac
+
+import (
+	"container/list"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// DefaultPatternCacheSize is the number of compiled patterns kept by the package cache
+const DefaultPatternCacheSize = 4096
+
+type patternPartKind uint8
+
+const (
+	partLiteral      patternPartKind = iota
+	partAny                          // `*` any single block
+	partTail                         // `**` one or more blocks at the end
+	partMask                         // `?` matches any single character
+	partAlternatives                 // `{a|b}`
+	partRegexp                       // `%r{...}`
+)
+
+type patternPart struct {
+	kind  patternPartKind
+	value string
+	alts  []string
+	re    *regexp.Regexp
+}
+
+func (p *patternPart) match(name string) bool {
+	switch p.kind {
+	case partAny, partTail:
+		return true
+	case partRegexp:
+		return p.re.MatchString(name)
+	case partAlternatives:
+		for _, alt := range p.alts {
+			if alt == name {
+				return true
+			}
+		}
+		return matchEqual(p.value, name)
+	case partMask:
+		return matchEqual(p.value, name)
+	}
+	return p.value == name
+}
+
+// Pattern is the compiled permission pattern, it's safe for concurrent use
+//
+// See MatchName for the syntax of the pattern.
+type Pattern struct {
+	source string
+	any    bool
+	parts  []patternPart
+}
+
+// CompilePattern parses the permission pattern and compiles its regexp blocks
+func CompilePattern(pattern string) (*Pattern, error) {
+	if pattern == `` {
+		return nil, wrapError(ErrInvalidPattern, `empty pattern`)
+	}
+	p := &Pattern{source: pattern, any: pattern == `*` || pattern == `**`}
+	if p.any {
+		return p, nil
+	}
+	blocks := strings.Split(pattern, `.`)
+	p.parts = make([]patternPart, 0, len(blocks))
+	for i, block := range blocks {
+		part := patternPart{value: block}
+		switch {
+		case block == ``:
+			return nil, wrapError(ErrInvalidPattern, `empty block in `+pattern)
+		case block == `*`:
+			part.kind = partAny
+		case block == `**`:
+			if i != len(blocks)-1 {
+				return nil, wrapError(ErrInvalidPattern, `** must be at the end`)
+			}
+			part.kind = partTail
+		case strings.HasPrefix(block, `%r{`):
+			if !strings.HasSuffix(block, `}`) {
+				return nil, wrapError(ErrInvalidPattern, `unclosed regexp block `+block)
+			}
+			re, err := regexp.Compile(block[3 : len(block)-1])
+			if err != nil {
+				return nil, wrapError(ErrInvalidPattern, err.Error())
+			}
+			part.kind, part.re = partRegexp, re
+		case strings.HasPrefix(block, `{`) != strings.HasSuffix(block, `}`):
+			return nil, wrapError(ErrInvalidPattern, `unbalanced braces in `+block)
+		case strings.HasPrefix(block, `{`):
+			part.kind, part.alts = partAlternatives, strings.Split(block[1:len(block)-1], `|`)
+		case strings.Contains(block, `?`):
+			part.kind = partMask
+		}
+		p.parts = append(p.parts, part)
+	}
+	return p, nil
+}
+
+// MustCompilePattern or produce panic
+func MustCompilePattern(pattern string) *Pattern {
+	p, err := CompilePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the source of the pattern
+func (p *Pattern) String() string {
+	return p.source
+}
+
+// Match returns true if the permission name matches the pattern
+func (p *Pattern) Match(name string) bool {
+	if p.any {
+		return true
+	}
+	pos := 0
+	for i := range p.parts {
+		end := nextBlockIndex(name, pos)
+		if end <= pos {
+			return false
+		}
+		if p.parts[i].kind == partTail {
+			return true
+		}
+		if !p.parts[i].match(name[pos:end]) {
+			return false
+		}
+		pos = end + 1
+	}
+	return pos > len(name)
+}
+
+// compiledPatterns is the package cache of the patterns used by checks
+var compiledPatterns = newPatternCache(DefaultPatternCacheSize)
+
+// SetPatternCacheSize changes the number of compiled patterns kept by the package cache
+func SetPatternCacheSize(size int) {
+	compiledPatterns.resize(size)
+}
+
+// compilePatternCached returns the compiled pattern from the package cache
+func compilePatternCached(pattern string) (*Pattern, error) {
+	return compiledPatterns.get(pattern)
+}
+
+type patternCacheItem struct {
+	pattern  string
+	compiled *Pattern
+	err      error
+}
+
+// patternCache is LRU cache of the compiled patterns (including invalid ones)
+type patternCache struct {
+	mx    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+func newPatternCache(size int) *patternCache {
+	return &patternCache{
+		size:  size,
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+	}
+}
+
+func (c *patternCache) get(pattern string) (*Pattern, error) {
+	c.mx.Lock()
+	if el, ok := c.items[pattern]; ok {
+		c.order.MoveToFront(el)
+		item := el.Value.(*patternCacheItem)
+		c.mx.Unlock()
+		return item.compiled, item.err
+	}
+	c.mx.Unlock()
+
+	// Compile out of the lock, concurrent compilation of the same pattern is harmless
+	compiled, err := CompilePattern(pattern)
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.size <= 0 {
+		return compiled, err
+	}
+	if el, ok := c.items[pattern]; ok {
+		c.order.MoveToFront(el)
+	} else {
+		c.items[pattern] = c.order.PushFront(&patternCacheItem{pattern: pattern, compiled: compiled, err: err})
+		c.evict()
+	}
+	return compiled, err
+}
+
+func (c *patternCache) resize(size int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.size = size
+	c.evict()
+}
+
+func (c *patternCache) len() int {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.order.Len()
+}
+
+func (c *patternCache) evict() {
+	for c.order.Len() > max(c.size, 0) {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.items, el.Value.(*patternCacheItem).pattern)
+	}
+}
@@ -0,0 +1,84 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		match   []string
+		noMatch []string
+		err     bool
+	}{
+		{pattern: `*`, match: []string{`test`, `test.it.owner`}},
+		{pattern: `**`, match: []string{`test`, `test.it.owner`}},
+		{pattern: `test`, match: []string{`test`}, noMatch: []string{`test.it`, `tes`, ``}},
+		{pattern: `test.*`, match: []string{`test.it`}, noMatch: []string{`test`, `test.it.owner`, `test.`}},
+		{pattern: `test.**`, match: []string{`test.it`, `test.it.owner`}, noMatch: []string{`test`, `tost.it`}},
+		{pattern: `test.??.admin`, match: []string{`test.es.admin`}, noMatch: []string{`test.e.admin`, `test.es.owner`}},
+		{pattern: `test.{foo|boo}.admin`, match: []string{`test.boo.admin`}, noMatch: []string{`test.goo.admin`}},
+		{pattern: `test.%r{^[a-z]+$}`, match: []string{`test.it`}, noMatch: []string{`test.IT`, `test.it.owner`}},
+		{pattern: ``, err: true},
+		{pattern: `test..it`, err: true},
+		{pattern: `test.**.it`, err: true},
+		{pattern: `test.%r{[a-z}`, err: true},
+		{pattern: `test.%r{a`, err: true},
+		{pattern: `test.{a|b`, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			p, err := CompilePattern(test.pattern)
+			if test.err {
+				assert.ErrorIs(t, err, ErrInvalidPattern)
+				assert.Panics(t, func() { MustCompilePattern(test.pattern) })
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.pattern, p.String())
+				for _, name := range test.match {
+					assert.True(t, p.Match(name), name)
+				}
+				for _, name := range test.noMatch {
+					assert.False(t, p.Match(name), name)
+				}
+			}
+		})
+	}
+}
+
+func TestPatternCache(t *testing.T) {
+	cache := newPatternCache(2)
+	p1, _ := cache.get(`a.*`)
+	_, _ = cache.get(`b.*`)
+	p1Again, _ := cache.get(`a.*`)
+	assert.Same(t, p1, p1Again)
+
+	// The least recently used pattern is evicted
+	_, _ = cache.get(`c.*`)
+	assert.Equal(t, 2, cache.len())
+	assert.Contains(t, cache.items, `a.*`)
+	assert.NotContains(t, cache.items, `b.*`)
+
+	// Invalid patterns are cached with the error
+	_, err := cache.get(`a.**.b`)
+	assert.ErrorIs(t, err, ErrInvalidPattern)
+	_, err = cache.get(`a.**.b`)
+	assert.ErrorIs(t, err, ErrInvalidPattern)
+
+	cache.resize(0)
+	assert.Equal(t, 0, cache.len())
+	p, err := cache.get(`a.*`)
+	assert.NoError(t, err)
+	assert.True(t, p.Match(`a.b`))
+	assert.Equal(t, 0, cache.len())
+}
+
+func BenchmarkMatchName(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = MatchName(`test.%r{[a-z]*}.{owner|admin}`, `test.object.admin`)
+	}
+}
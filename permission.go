@@ -23,9 +23,16 @@ type Permission interface {
 	// CheckPermissions to accept to resource
 	CheckPermissions(ctx context.Context, resource any, patterns ...string) bool
 
+	// CheckPermissionsE to accept to resource, returns error if some check callback failed
+	CheckPermissionsE(ctx context.Context, resource any, patterns ...string) (bool, error)
+
 	// CheckedPermission returns child permission for resource which has been checked as allowed
 	CheckedPermissions(ctx context.Context, resource any, patterns ...string) Permission
 
+	// CheckedPermissionsE returns child permission for resource which has been checked as allowed
+	// or error if some check callback failed
+	CheckedPermissionsE(ctx context.Context, resource any, patterns ...string) (Permission, error)
+
 	// ChildPermissions list returns list of child permissions
 	ChildPermissions() []Permission
 
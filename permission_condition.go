@@ -0,0 +1,84 @@
+package rbac
+
+import "context"
+
+// conditionalPermission matches the wrapped permission only if the condition is true,
+// it's used for conditions of the preloaded role permissions (see WithPatternConditions)
+type conditionalPermission struct {
+	perm Permission
+	cond *Condition
+}
+
+// Name of the wrapped permission
+func (p *conditionalPermission) Name() string { return p.perm.Name() }
+
+// Description of the wrapped permission
+func (p *conditionalPermission) Description() string { return p.perm.Description() }
+
+// ChildPermissions of the wrapped permission
+func (p *conditionalPermission) ChildPermissions() []Permission { return p.perm.ChildPermissions() }
+
+// Permission returns permission by name
+func (p *conditionalPermission) Permission(name string) Permission { return p.perm.Permission(name) }
+
+// Permissions returns list of permissions by pattern
+func (p *conditionalPermission) Permissions(patterns ...string) []Permission {
+	return p.perm.Permissions(patterns...)
+}
+
+// HasPermission returns true if permission has child permission
+func (p *conditionalPermission) HasPermission(patterns ...string) bool {
+	return p.perm.HasPermission(patterns...)
+}
+
+// MatchPermissionPattern returns true if permission matches any of the patterns
+func (p *conditionalPermission) MatchPermissionPattern(patterns ...string) bool {
+	return p.perm.MatchPermissionPattern(patterns...)
+}
+
+// Ext returns additional user data
+func (p *conditionalPermission) Ext() any { return p.perm.Ext() }
+
+// Effect of the wrapped permission
+func (p *conditionalPermission) Effect() Effect { return PermissionEffect(p.perm) }
+
+// Condition of the permission
+func (p *conditionalPermission) Condition() *Condition { return p.cond }
+
+// CheckPermissions of the wrapped permission if the condition is true
+func (p *conditionalPermission) CheckPermissions(ctx context.Context, resource any, patterns ...string) bool {
+	if len(patterns) == 0 {
+		panic(ErrInvalidCheckParams)
+	}
+	return p.CheckedPermissions(ctx, resource, patterns...) != nil
+}
+
+// CheckPermissionsE of the wrapped permission if the condition is true
+func (p *conditionalPermission) CheckPermissionsE(ctx context.Context, resource any, patterns ...string) (bool, error) {
+	perm, err := p.CheckedPermissionsE(ctx, resource, patterns...)
+	return perm != nil, err
+}
+
+// CheckedPermissions returns the checked permission if the condition is true
+func (p *conditionalPermission) CheckedPermissions(ctx context.Context, resource any, patterns ...string) Permission {
+	perm, _ := p.CheckedPermissionsE(ctx, resource, patterns...)
+	return perm
+}
+
+// CheckedPermissionsE returns the checked permission if the condition is true
+// or error if the condition or the check callback failed
+func (p *conditionalPermission) CheckedPermissionsE(ctx context.Context, resource any, patterns ...string) (Permission, error) {
+	if len(patterns) == 0 {
+		return nil, ErrInvalidCheckParams
+	}
+	return checkedPermissionE(newEvalState(ctx, resource, patterns), DefaultCombiningAlgorithm, p)
+}
+
+func (p *conditionalPermission) visitMatches(st *evalState, fn matchFunc) bool {
+	return visitMatches(st, p.perm, func(m permissionMatch) bool {
+		if !p.cond.evaluate(st, m.perm) {
+			return st.err == nil
+		}
+		return fn(m)
+	})
+}
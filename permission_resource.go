@@ -10,6 +10,9 @@ type ResourcePermission struct {
 	SimplePermission
 	resName string
 	resType reflect.Type
+
+	// Owning scope of the permission (owner, account, all)
+	owning string
 }
 
 // NewResourcePermission object with custom checker and base type
@@ -59,40 +62,70 @@ func (perm *ResourcePermission) ResourceType() reflect.Type {
 
 // CheckPermissions to accept to resource
 func (perm *ResourcePermission) CheckPermissions(ctx context.Context, resource any, patterns ...string) bool {
-	if perm == nil || len(patterns) == 0 || resource == nil {
-		return false
-	}
-	if true &&
-		checkResourcePattern(perm.resName, perm.name, patterns...) &&
-		perm.CheckType(resource) &&
-		perm.callCallback(ctx, perm, resource, patterns...) {
-		return true
-	}
-	for _, p := range perm.permissions {
-		if p.CheckPermissions(ctx, resource, patterns...) {
-			return true
-		}
-	}
-	return false
+	return perm.CheckedPermissions(ctx, resource, patterns...) != nil
+}
+
+// CheckPermissionsE to accept to resource, returns error if some check callback failed
+func (perm *ResourcePermission) CheckPermissionsE(ctx context.Context, resource any, patterns ...string) (bool, error) {
+	p, err := perm.CheckedPermissionsE(ctx, resource, patterns...)
+	return p != nil, err
 }
 
 // CheckedPermission returns child permission for resource which has been checked as allowed
 func (perm *ResourcePermission) CheckedPermissions(ctx context.Context, resource any, patterns ...string) Permission {
-	if perm == nil || len(patterns) == 0 || resource == nil {
-		return nil
+	p, _ := perm.CheckedPermissionsE(ctx, resource, patterns...)
+	return p
+}
+
+// CheckedPermissionsE returns child permission for resource which has been checked as allowed
+// or error if some check callback failed
+func (perm *ResourcePermission) CheckedPermissionsE(ctx context.Context, resource any, patterns ...string) (Permission, error) {
+	if len(patterns) == 0 {
+		return nil, ErrInvalidCheckParams
+	}
+	if perm == nil || resource == nil {
+		return nil, nil
+	}
+	return checkedPermissionE(newEvalState(ctx, resource, patterns), DefaultCombiningAlgorithm, perm)
+}
+
+func (perm *ResourcePermission) visitMatches(st *evalState, fn matchFunc) bool {
+	if st.resource == nil || !perm.window.active(st.now()) {
+		return true
 	}
 	if true &&
-		checkResourcePattern(perm.resName, perm.name, patterns...) &&
-		perm.CheckType(resource) &&
-		perm.callCallback(ctx, perm, resource, patterns...) {
-		return perm
+		perm.matchCheckPattern(st.patterns...) &&
+		perm.CheckType(st.resource) &&
+		checkOwning(st.ctx, perm.owning, st.resource, perm.checkFnk != nil || perm.effect == Deny || st.denying) &&
+		perm.checkCondition(st, perm) &&
+		perm.callCallback(st, perm) {
+		if !fn(st.match(perm, perm.effect)) {
+			return false
+		}
+	}
+	if st.err != nil {
+		return false
 	}
 	for _, p := range perm.permissions {
-		if r := p.CheckedPermissions(ctx, resource, patterns...); r != nil {
-			return r
+		if !visitMatches(st, p, fn) {
+			return false
 		}
 	}
-	return nil
+	return true
+}
+
+// matchCheckPattern returns true if the permission matches the check patterns,
+// owning permissions also match patterns of the action without scope (`view` for `view.owner`)
+func (perm *ResourcePermission) matchCheckPattern(patterns ...string) bool {
+	if checkResourcePattern(perm.resName, perm.name, patterns...) {
+		return true
+	}
+	return perm.owning != `` && checkResourcePattern(perm.resName, owningAction(perm.name, perm.owning), patterns...)
+}
+
+// OwningScope returns owning scope of the permission (owner, account, all) or empty string
+func (perm *ResourcePermission) OwningScope() string {
+	return perm.owning
 }
 
 // CheckType of resource and target type
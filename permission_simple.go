@@ -1,18 +1,25 @@
 package rbac
 
-import (
-	"context"
-	"reflect"
-)
+import "context"
 
 // SimplePermission implementation with simple functionality
 type SimplePermission struct {
-	name            string
-	description     string
-	extData         any
-	checkFnkResType reflect.Type
-	checkFnk        reflect.Value // func(ctx, resource, names ...string)
-	permissions     []Permission
+	name         string
+	description  string
+	extData      any
+	effect       Effect
+	checkFnk     checkFunc
+	checkFnkName string
+	permissions  []Permission
+
+	// Results of the check callback can't be memoized (see WithDecisionCache)
+	noCache bool
+
+	// Attribute-based condition of the permission
+	condition *Condition
+
+	// Validity period and schedule of the permission (see WithValidity, WithSchedule)
+	window *timeWindow
 }
 
 // NewSimplePermission object with custom checker
@@ -56,20 +63,48 @@ func (perm *SimplePermission) CheckPermissions(ctx context.Context, resource any
 	return perm.CheckedPermissions(ctx, resource, patterns...) != nil
 }
 
+// CheckPermissionsE to accept to resource, returns error if some check callback failed
+func (perm *SimplePermission) CheckPermissionsE(ctx context.Context, resource any, patterns ...string) (bool, error) {
+	p, err := perm.CheckedPermissionsE(ctx, resource, patterns...)
+	return p != nil, err
+}
+
 // CheckedPermission returns child permission for resource which has been checked as allowed
 func (perm *SimplePermission) CheckedPermissions(ctx context.Context, resource any, patterns ...string) Permission {
-	if len(patterns) == 0 || perm == nil {
-		return nil
+	p, _ := perm.CheckedPermissionsE(ctx, resource, patterns...)
+	return p
+}
+
+// CheckedPermissionsE returns child permission for resource which has been checked as allowed
+// or error if some check callback failed
+func (perm *SimplePermission) CheckedPermissionsE(ctx context.Context, resource any, patterns ...string) (Permission, error) {
+	if len(patterns) == 0 {
+		return nil, ErrInvalidCheckParams
 	}
-	if perm.MatchPermissionPattern(patterns...) && perm.callCallback(ctx, nil, resource, patterns...) {
-		return perm
+	if perm == nil {
+		return nil, nil
+	}
+	return checkedPermissionE(newEvalState(ctx, resource, patterns), DefaultCombiningAlgorithm, perm)
+}
+
+func (perm *SimplePermission) visitMatches(st *evalState, fn matchFunc) bool {
+	if !perm.window.active(st.now()) {
+		return true
+	}
+	if perm.MatchPermissionPattern(st.patterns...) && perm.checkCondition(st, perm) && perm.callCallback(st, perm) {
+		if !fn(st.match(perm, perm.effect)) {
+			return false
+		}
+	}
+	if st.err != nil {
+		return false
 	}
 	for _, p := range perm.permissions {
-		if r := p.CheckedPermissions(ctx, resource, patterns...); r != nil {
-			return r
+		if !visitMatches(st, p, fn) {
+			return false
 		}
 	}
-	return nil
+	return true
 }
 
 // ChildPermissions returns list of child permissions
@@ -122,32 +157,39 @@ func (perm *SimplePermission) MatchPermissionPattern(patterns ...string) bool {
 	return perm != nil && checkPattern(perm.name, patterns...)
 }
 
+// Effect of the permission (Allow or Deny)
+func (perm *SimplePermission) Effect() Effect {
+	return perm.effect
+}
+
 // Ext returns additional user data
 func (perm *SimplePermission) Ext() any {
 	return perm.extData
 }
 
-func (perm *SimplePermission) callCallback(ctx context.Context, curPerm Permission, resource any, _ ...string) bool {
-	if perm.checkFnk.Kind() != reflect.Func {
-		return true
-	}
+// Condition of the permission or nil
+func (perm *SimplePermission) Condition() *Condition {
+	return perm.condition
+}
 
-	// Get reflect resource value
-	res := reflect.ValueOf(resource)
+// checkCondition of the permission, returns false and fails the evaluation if the condition can't be evaluated
+func (perm *SimplePermission) checkCondition(st *evalState, curPerm Permission) bool {
+	return perm.condition == nil || perm.condition.evaluate(st, curPerm)
+}
 
-	// Check first parameter type
-	if perm.checkFnkResType.Kind() != reflect.Interface && perm.checkFnkResType != res.Type() {
-		return false
-	}
-	if curPerm == nil {
-		curPerm = perm
+// callCallback of the permission, returns false and fails the evaluation if the callback returns error
+func (perm *SimplePermission) callCallback(st *evalState, curPerm Permission) bool {
+	if perm.checkFnk == nil {
+		return true
 	}
-	in := []reflect.Value{
-		reflect.ValueOf(ctx), res,
-		reflect.ValueOf((Permission)(curPerm)),
+	if perm.noCache {
+		st.uncacheable = true
 	}
-	if resp := perm.checkFnk.Call(in); len(resp) == 1 {
-		return resp[0].Bool()
+	result, err := perm.checkFnk(st.ctx, st.resource, curPerm)
+	traceCallback(st.ctx, curPerm, perm.checkFnkName, result, err)
+	if err != nil {
+		st.fail(curPerm, err)
+		return false
 	}
-	return false
+	return result
 }
@@ -0,0 +1,339 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/demdxx/xtypes"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrInvalidPolicy if policy document is not valid
+	ErrInvalidPolicy = errors.New(`invalid policy`)
+
+	// ErrUnknownRole if role is not defined in the policy or manager
+	ErrUnknownRole = errors.New(`unknown role`)
+
+	// ErrUnknownPermission if pattern does not match any registered permission
+	ErrUnknownPermission = errors.New(`unknown permission`)
+)
+
+// Policy document describes roles and permissions of the system
+//
+// Example (YAML):
+//
+//	roles:
+//	  - name: viewer
+//	    permissions: [user.view.*, user.list.*]
+//	  - name: admin
+//	    description: System administrator
+//	    combining: deny-overrides
+//	    roles: [viewer]
+//	    permissions:
+//	      - user.*.all
+//	      - "!user.delete.*"
+//	    conditions:
+//	      user.*.all: resource.account_id == subject.account_id
+//	    ext:
+//	      level: 10
+//	  - name: oncall
+//	    roles: [admin]
+//	    valid_to: 2024-06-01T00:00:00Z
+//	    schedule: ["* 0-8 * * *", "* * * * sat,sun"]
+type Policy struct {
+	Roles []PolicyRole `json:"roles" yaml:"roles"`
+
+	// Permissions registered in the manager, informational section of the exported policy
+	// which is ignored by the loader
+	Permissions []PolicyPermission `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+}
+
+// PolicyRole describes the role of the policy document
+type PolicyRole struct {
+	// Name of the role
+	Name string `json:"name" yaml:"name"`
+
+	// Description of the role
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+
+	// Combining algorithm of the role: deny-overrides, allow-overrides, first-applicable
+	Combining string `json:"combining,omitempty" yaml:"combining,omitempty"`
+
+	// Roles is the list of child role names
+	Roles []string `json:"roles,omitempty" yaml:"roles,omitempty"`
+
+	// Permissions is the list of permission patterns, `!` prefix defines deny permissions
+	Permissions []string `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+
+	// Conditions of the permission patterns, see Condition for the syntax
+	Conditions map[string]string `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+
+	// ValidFrom and ValidTo limit the validity period of the role, see WithValidity
+	ValidFrom *time.Time `json:"valid_from,omitempty" yaml:"valid_from,omitempty"`
+	ValidTo   *time.Time `json:"valid_to,omitempty" yaml:"valid_to,omitempty"`
+
+	// Schedule of the role activity, see Schedule for the syntax
+	Schedule []string `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+
+	// Ext is additional data of the role
+	Ext any `json:"ext,omitempty" yaml:"ext,omitempty"`
+
+	// Preloaded is the list of permissions resolved from the wildcard patterns,
+	// informational field of the exported policy which is ignored by the loader
+	Preloaded []string `json:"preloaded,omitempty" yaml:"preloaded,omitempty"`
+}
+
+// PolicyPermission describes the registered permission of the policy document
+type PolicyPermission struct {
+	// Name of the permission
+	Name string `json:"name" yaml:"name"`
+
+	// Description of the permission
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+
+	// Effect of the permission if it is not allow
+	Effect string `json:"effect,omitempty" yaml:"effect,omitempty"`
+}
+
+// ParsePolicy document in YAML or JSON format
+func ParsePolicy(r io.Reader) (*Policy, error) {
+	var policy Policy
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&policy); err != nil {
+		if errors.Is(err, io.EOF) {
+			return &policy, nil
+		}
+		return nil, wrapError(ErrInvalidPolicy, err.Error())
+	}
+	return &policy, nil
+}
+
+// Validate policy document structure, patterns and role hierarchy
+func (p *Policy) Validate() error {
+	_, err := p.BuildRoles(nil)
+	return err
+}
+
+// BuildRoles of the policy document
+//
+// Child roles which are not defined in the policy are requested from the resolve function if defined.
+func (p *Policy) BuildRoles(resolve func(name string) Role) ([]Role, error) {
+	builder, err := p.builder(resolve)
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]Role, 0, len(p.Roles))
+	for _, def := range p.Roles {
+		role, err := builder.build(def.Name)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// builder of the policy roles with the index of definitions
+func (p *Policy) builder(resolve func(name string) Role) (*policyBuilder, error) {
+	builder := &policyBuilder{
+		index:   make(map[string]*PolicyRole, len(p.Roles)),
+		roles:   make(map[string]Role, len(p.Roles)),
+		resolve: resolve,
+		visited: map[string]bool{},
+	}
+	for i := range p.Roles {
+		def := &p.Roles[i]
+		if def.Name == `` {
+			return nil, wrapError(ErrInvalidPolicy, `role without name`)
+		}
+		if builder.index[def.Name] != nil {
+			return nil, wrapError(ErrInvalidPolicy, `duplicate role `+def.Name)
+		}
+		builder.index[def.Name] = def
+	}
+	return builder, nil
+}
+
+// validatePermissions checks that every pattern of the policy matches some registered permission
+func (p *Policy) validatePermissions(perms permissionReader) error {
+	for _, def := range p.Roles {
+		for _, pattern := range def.Permissions {
+			if err := validatePattern(strings.TrimPrefix(pattern, `!`)); err != nil {
+				return wrapError(err, `role `+def.Name+` permission `+pattern)
+			}
+			if len(perms.Permissions(strings.TrimPrefix(pattern, `!`))) == 0 {
+				return wrapError(ErrUnknownPermission, `role `+def.Name+` permission `+pattern)
+			}
+		}
+	}
+	return nil
+}
+
+type policyBuilder struct {
+	index   map[string]*PolicyRole
+	roles   map[string]Role
+	resolve func(name string) Role
+	stack   []string
+	visited map[string]bool
+}
+
+func (b *policyBuilder) build(name string) (Role, error) {
+	if role := b.roles[name]; role != nil {
+		return role, nil
+	}
+	def := b.index[name]
+	if def == nil {
+		if b.resolve != nil {
+			if role := b.resolve(name); role != nil {
+				return role, nil
+			}
+		}
+		return nil, wrapError(ErrUnknownRole, name)
+	}
+	if b.visited[name] {
+		return nil, &RoleCycleError{Path: append(append([]string{}, b.stack[indexOfRole(b.stack, name):]...), name)}
+	}
+
+	b.visited[name] = true
+	b.stack = append(b.stack, name)
+	defer func() {
+		b.stack = b.stack[:len(b.stack)-1]
+		delete(b.visited, name)
+	}()
+
+	children := make([]Role, 0, len(def.Roles))
+	for _, childName := range def.Roles {
+		child, err := b.build(childName)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+
+	permissions := make([]any, 0, len(def.Permissions))
+	for _, pattern := range def.Permissions {
+		if err := validatePattern(strings.TrimPrefix(pattern, `!`)); err != nil {
+			return nil, wrapError(err, `role `+name+` permission `+pattern)
+		}
+		permissions = append(permissions, pattern)
+	}
+
+	options := []Option{
+		WithDescription(def.Description),
+		WithChildRoles(children...),
+		WithPermissions(permissions...),
+	}
+	if len(def.Conditions) > 0 {
+		for pattern := range def.Conditions {
+			if !xtypes.Slice[string](def.Permissions).Has(func(p string) bool { return p == pattern }) {
+				return nil, wrapError(ErrInvalidPolicy, `role `+name+` condition of unknown permission `+pattern)
+			}
+		}
+		options = append(options, WithPatternConditions(def.Conditions))
+	}
+	if def.Combining != `` {
+		alg, err := ParseCombiningAlgorithm(def.Combining)
+		if err != nil {
+			return nil, wrapError(err, `role `+name)
+		}
+		options = append(options, WithCombiningAlgorithm(alg))
+	}
+	if def.ValidFrom != nil || def.ValidTo != nil {
+		var from, to time.Time
+		if def.ValidFrom != nil {
+			from = *def.ValidFrom
+		}
+		if def.ValidTo != nil {
+			to = *def.ValidTo
+		}
+		options = append(options, WithValidity(from, to))
+	}
+	if len(def.Schedule) > 0 {
+		options = append(options, WithSchedule(def.Schedule...))
+	}
+	if def.Ext != nil {
+		options = append(options, WithExtData(def.Ext))
+	}
+
+	role, err := NewRole(name, options...)
+	if err != nil {
+		return nil, wrapError(err, `role `+name)
+	}
+	b.roles[name] = role
+	return role, nil
+}
+
+// PolicyLoader loads roles from the policy document and implements RoleLoader interface
+type PolicyLoader struct {
+	policy   *Policy
+	filename string
+}
+
+// NewPolicyLoader returns role loader of the parsed policy document
+func NewPolicyLoader(policy *Policy) *PolicyLoader {
+	return &PolicyLoader{policy: policy}
+}
+
+// NewFilePolicyLoader returns role loader which reads the policy file on every loading
+func NewFilePolicyLoader(filename string) *PolicyLoader {
+	return &PolicyLoader{filename: filename}
+}
+
+// LoadRoles builds the roles of the policy document
+func (l *PolicyLoader) LoadRoles(_ context.Context) ([]Role, error) {
+	policy := l.policy
+	if l.filename != `` {
+		file, err := os.Open(l.filename)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = file.Close() }()
+		if policy, err = ParsePolicy(file); err != nil {
+			return nil, wrapError(err, l.filename)
+		}
+	}
+	if policy == nil {
+		return nil, nil
+	}
+	return policy.BuildRoles(nil)
+}
+
+// ListRolesE implements RoleLoaderE interface
+func (l *PolicyLoader) ListRolesE(ctx context.Context) ([]Role, error) {
+	return l.LoadRoles(ctx)
+}
+
+// ListRoles implements RoleLoader interface, returns nil if the policy is invalid
+func (l *PolicyLoader) ListRoles(ctx context.Context) []Role {
+	roles, _ := l.LoadRoles(ctx)
+	return roles
+}
+
+// LoadPolicy document in YAML or JSON format and register its roles in the manager
+//
+// All permissions used in the policy must be registered before loading.
+func (mng *Manager) LoadPolicy(ctx context.Context, r io.Reader) error {
+	policy, err := ParsePolicy(r)
+	if err != nil {
+		return err
+	}
+	return mng.ApplyPolicy(ctx, policy)
+}
+
+// ApplyPolicy registers roles of the policy document in the manager
+func (mng *Manager) ApplyPolicy(ctx context.Context, policy *Policy) error {
+	if err := policy.validatePermissions(permissionRegistry{mng}); err != nil {
+		return err
+	}
+	roles, err := policy.BuildRoles(func(name string) Role { return mng.Role(ctx, name) })
+	if err != nil {
+		return err
+	}
+	return mng.RegisterRoleE(ctx, roles...)
+}
@@ -0,0 +1,143 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/demdxx/xtypes"
+	"gopkg.in/yaml.v3"
+)
+
+// ExportPolicy returns the policy document of all roles and permissions of the manager
+//
+// Roles and permissions are sorted by name to produce the canonical document,
+// the order of child roles and permissions is kept for roles with first-applicable algorithm.
+// Expired roles and permissions are skipped by the time of the context clock (see WithClock).
+func (mng *Manager) ExportPolicy(ctx context.Context) *Policy {
+	exported := map[string]bool{}
+	policy := &Policy{}
+	now := CurrentTime(ctx)
+
+	var exportRole func(role Role)
+	exportRole = func(role Role) {
+		if role == nil || exported[role.Name()] || grantExpired(role, now) {
+			return
+		}
+		exported[role.Name()] = true
+		policy.Roles = append(policy.Roles, policyRoleOf(role, now))
+		for _, child := range role.ChildRoles() {
+			exportRole(child)
+		}
+	}
+	for _, role := range mng.Roles(ctx) {
+		exportRole(role)
+	}
+	sort.Slice(policy.Roles, func(i, j int) bool {
+		return policy.Roles[i].Name < policy.Roles[j].Name
+	})
+
+	for _, perm := range mng.PermissionsContext(ctx) {
+		policy.Permissions = append(policy.Permissions, policyPermissionOf(perm))
+	}
+	sort.Slice(policy.Permissions, func(i, j int) bool {
+		return policy.Permissions[i].Name < policy.Permissions[j].Name
+	})
+	return policy
+}
+
+// WritePolicy writes the exported policy document of the manager in YAML format
+func (mng *Manager) WritePolicy(ctx context.Context, w io.Writer) error {
+	return mng.ExportPolicy(ctx).WriteYAML(w)
+}
+
+// WriteYAML writes policy document in YAML format
+func (p *Policy) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(p); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// WriteJSON writes policy document in JSON format
+func (p *Policy) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent(``, `  `)
+	return enc.Encode(p)
+}
+
+func policyRoleOf(ro Role, now time.Time) PolicyRole {
+	def := PolicyRole{
+		Name:        ro.Name(),
+		Description: ro.Description(),
+		Ext:         ro.Ext(),
+	}
+	for _, child := range ro.ChildRoles() {
+		if !grantExpired(child, now) {
+			def.Roles = append(def.Roles, child.Name())
+		}
+	}
+
+	r, _ := ro.(*role)
+	if r != nil {
+		def.Combining = r.combiningAlgorithm().String()
+		def.Permissions = append(def.Permissions, r.preloadedPatterns...)
+		def.Permissions = append(def.Permissions, r.preloadPermissions...)
+		for pattern, cond := range r.conditions {
+			if def.Conditions == nil {
+				def.Conditions = make(map[string]string, len(r.conditions))
+			}
+			def.Conditions[pattern] = cond.String()
+		}
+		if w := r.window; w != nil {
+			if from := w.from; !from.IsZero() {
+				def.ValidFrom = &from
+			}
+			if to := w.to; !to.IsZero() {
+				def.ValidTo = &to
+			}
+			for _, schedule := range w.schedules {
+				def.Schedule = append(def.Schedule, schedule.String())
+			}
+		}
+	}
+	for _, perm := range ro.ChildPermissions() {
+		if grantExpired(perm, now) {
+			continue
+		}
+		name := perm.Name()
+		if PermissionEffect(perm) == Deny {
+			name = `!` + name
+		}
+		if r != nil && r.preloaded[keyOfPermission(perm)] {
+			// Permissions loaded by the exact name are defined by the pattern itself
+			if !xtypes.Slice[string](def.Permissions).Has(func(p string) bool { return p == name }) {
+				def.Preloaded = append(def.Preloaded, name)
+			}
+		} else {
+			def.Permissions = append(def.Permissions, name)
+		}
+	}
+
+	if r == nil || r.combiningAlgorithm() != FirstApplicable {
+		sort.Strings(def.Roles)
+		sort.Strings(def.Permissions)
+	}
+	sort.Strings(def.Preloaded)
+	return def
+}
+
+func policyPermissionOf(perm Permission) PolicyPermission {
+	def := PolicyPermission{
+		Name:        perm.Name(),
+		Description: perm.Description(),
+	}
+	if effect := PermissionEffect(perm); effect != Allow {
+		def.Effect = effect.String()
+	}
+	return def
+}
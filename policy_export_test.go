@@ -0,0 +1,70 @@
+package rbac
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagerExportPolicy(t *testing.T) {
+	ctx := context.TODO()
+	policy, err := ParsePolicy(strings.NewReader(`{"roles": [{"name": "loaded", "permissions": ["rbac.testObject.list.*"]}]}`))
+	assert.NoError(t, err)
+
+	mng := NewManagerWithLoader(NewPolicyLoader(policy), time.Minute)
+	assert.NoError(t, mng.RegisterNewOwningPermissions((*testObject)(nil), []string{`view`, `list`, `delete`}))
+	assert.NoError(t, mng.RegisterNewPermission(nil, `custom`, WithDescription(`Custom permission`)))
+	assert.NoError(t, mng.LoadPolicy(ctx, strings.NewReader(testPolicyYAML)))
+	mng.RegisterRole(ctx, MustNewRole(`custom`, WithPermissions(MustNewSimplePermission(`custom`))))
+
+	exported := mng.ExportPolicy(ctx)
+	if assert.Equal(t, 4, len(exported.Roles)) {
+		assert.Equal(t, []string{`admin`, `custom`, `loaded`, `viewer`}, []string{
+			exported.Roles[0].Name, exported.Roles[1].Name, exported.Roles[2].Name, exported.Roles[3].Name,
+		})
+		admin := exported.Roles[0]
+		assert.Equal(t, `deny-overrides`, admin.Combining)
+		assert.Equal(t, []string{`viewer`}, admin.Roles)
+		assert.Equal(t, []string{`!rbac.testObject.delete.*`, `rbac.testObject.*.all`}, admin.Permissions)
+		assert.Equal(t, []string{
+			`!rbac.testObject.delete.account`,
+			`!rbac.testObject.delete.all`,
+			`!rbac.testObject.delete.owner`,
+			`rbac.testObject.delete.all`,
+			`rbac.testObject.list.all`,
+			`rbac.testObject.view.all`,
+		}, admin.Preloaded)
+		assert.Equal(t, []string{`custom`}, exported.Roles[1].Permissions)
+		assert.Empty(t, exported.Roles[1].Preloaded)
+	}
+	assert.Equal(t, 10, len(exported.Permissions))
+	assert.Equal(t, PolicyPermission{Name: `custom`, Description: `Custom permission`}, exported.Permissions[0])
+
+	t.Run(`canonical`, func(t *testing.T) {
+		var buf1, buf2 bytes.Buffer
+		assert.NoError(t, mng.WritePolicy(ctx, &buf1))
+		assert.NoError(t, mng.WritePolicy(ctx, &buf2))
+		assert.Equal(t, buf1.String(), buf2.String())
+		assert.Contains(t, buf1.String(), "  - name: admin\n")
+	})
+
+	t.Run(`roundtrip`, func(t *testing.T) {
+		for _, write := range []func(*Policy, *bytes.Buffer) error{
+			func(p *Policy, buf *bytes.Buffer) error { return p.WriteYAML(buf) },
+			func(p *Policy, buf *bytes.Buffer) error { return p.WriteJSON(buf) },
+		} {
+			var buf bytes.Buffer
+			assert.NoError(t, write(exported, &buf))
+
+			mng2 := NewManager(nil)
+			assert.NoError(t, mng2.RegisterNewOwningPermissions((*testObject)(nil), []string{`view`, `list`, `delete`}))
+			assert.NoError(t, mng2.RegisterNewPermission(nil, `custom`, WithDescription(`Custom permission`)))
+			assert.NoError(t, mng2.LoadPolicy(ctx, &buf))
+			assert.Equal(t, exported, mng2.ExportPolicy(ctx))
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicyYAML = `
+roles:
+  - name: viewer
+    permissions: [rbac.testObject.view.*, rbac.testObject.list.*]
+  - name: admin
+    description: System administrator
+    combining: deny-overrides
+    roles: [viewer]
+    permissions:
+      - rbac.testObject.*.all
+      - "!rbac.testObject.delete.*"
+    ext:
+      level: 10
+`
+
+const testPolicyJSON = `{
+  "roles": [
+    {"name": "viewer", "permissions": ["rbac.testObject.view.*", "rbac.testObject.list.*"]},
+    {"name": "admin", "roles": ["viewer"], "permissions": ["rbac.testObject.*.all", "!rbac.testObject.delete.*"]}
+  ]
+}`
+
+func newTestPolicyManager(t *testing.T) *Manager {
+	mng := NewManager(nil)
+	assert.NoError(t, mng.RegisterNewOwningPermissions((*testObject)(nil),
+		[]string{`view`, `list`, `update`, `delete`}))
+	return mng
+}
+
+func TestManagerLoadPolicy(t *testing.T) {
+	ctx := context.TODO()
+	obj := &testObject{name: `test`}
+
+	for name, data := range map[string]string{`yaml`: testPolicyYAML, `json`: testPolicyJSON} {
+		t.Run(name, func(t *testing.T) {
+			mng := newTestPolicyManager(t)
+			assert.NoError(t, mng.LoadPolicy(ctx, strings.NewReader(data)))
+			assert.Equal(t, 2, len(mng.Roles(ctx)))
+
+			admin := mng.Role(ctx, `admin`)
+			if assert.NotNil(t, admin) {
+				assert.True(t, admin.HasRole(`viewer`))
+				assert.True(t, admin.CheckPermissions(ctx, obj, `update.all`))
+				// The object without owner is not granted by the inherited owner scope
+				assert.True(t, admin.HasPermission(`rbac.testObject.view.owner`))
+				assert.False(t, admin.CheckPermissions(ctx, obj, `view.owner`))
+				assert.False(t, admin.CheckPermissions(ctx, obj, `delete.all`))
+			}
+			viewer := mng.Role(ctx, `viewer`)
+			if assert.NotNil(t, viewer) {
+				assert.Equal(t, 6, len(viewer.Permissions()))
+				assert.False(t, viewer.CheckPermissions(ctx, obj, `update.all`))
+			}
+		})
+	}
+
+	t.Run(`ext`, func(t *testing.T) {
+		mng := newTestPolicyManager(t)
+		assert.NoError(t, mng.LoadPolicy(ctx, strings.NewReader(testPolicyYAML)))
+		admin := mng.Role(ctx, `admin`)
+		assert.Equal(t, `System administrator`, admin.Description())
+		assert.Equal(t, map[string]any{`level`: 10}, admin.Ext())
+	})
+
+	t.Run(`existing-child-role`, func(t *testing.T) {
+		mng := newTestPolicyManager(t)
+		mng.RegisterRole(ctx, MustNewRole(`base`, WithPermissions(`rbac.testObject.list.*`)))
+		assert.NoError(t, mng.LoadPolicy(ctx, strings.NewReader(`{"roles": [{"name": "ext", "roles": ["base"]}]}`)))
+		assert.True(t, mng.Role(ctx, `ext`).CheckPermissions(ctx, obj, `list.all`))
+	})
+}
+
+func TestManagerLoadPolicyErrors(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name   string
+		policy string
+		err    error
+	}{
+		{name: `syntax`, policy: `roles: [`, err: ErrInvalidPolicy},
+		{name: `unknown-field`, policy: `{"roles": [{"name": "a", "perms": []}]}`, err: ErrInvalidPolicy},
+		{name: `no-name`, policy: `{"roles": [{"description": "a"}]}`, err: ErrInvalidPolicy},
+		{name: `duplicate`, policy: `{"roles": [{"name": "a"}, {"name": "a"}]}`, err: ErrInvalidPolicy},
+		{name: `unknown-permission`, policy: `{"roles": [{"name": "a", "permissions": ["rbac.other.*"]}]}`, err: ErrUnknownPermission},
+		{name: `invalid-pattern`, policy: `{"roles": [{"name": "a", "permissions": ["rbac.**.view"]}]}`, err: ErrInvalidPattern},
+		{name: `invalid-regexp`, policy: `{"roles": [{"name": "a", "permissions": ["rbac.%r{[a-z}"]}]}`, err: ErrInvalidPattern},
+		{name: `unknown-role`, policy: `{"roles": [{"name": "a", "roles": ["b"]}]}`, err: ErrUnknownRole},
+		{name: `combining`, policy: `{"roles": [{"name": "a", "combining": "any"}]}`, err: ErrInvalidOptionParam},
+		{name: `cycle`, policy: `{"roles": [
+			{"name": "a", "roles": ["b"]},
+			{"name": "b", "roles": ["c"]},
+			{"name": "c", "roles": ["a"]}
+		]}`, err: ErrRoleCycle},
+		{name: `self-cycle`, policy: `{"roles": [{"name": "a", "roles": ["a"]}]}`, err: ErrRoleCycle},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mng := newTestPolicyManager(t)
+			err := mng.LoadPolicy(ctx, strings.NewReader(test.policy))
+			assert.True(t, errors.Is(err, test.err), `error: %v`, err)
+			assert.Equal(t, 0, len(mng.Roles(ctx)))
+		})
+	}
+
+	t.Run(`cycle-path`, func(t *testing.T) {
+		policy, err := ParsePolicy(strings.NewReader(`{"roles": [
+			{"name": "a", "roles": ["b"]},
+			{"name": "b", "roles": ["a"]}
+		]}`))
+		assert.NoError(t, err)
+		assert.EqualError(t, policy.Validate(), `role cycle: a -> b -> a`)
+	})
+}
+
+func TestPolicyLoader(t *testing.T) {
+	ctx := context.TODO()
+	obj := &testObject{name: `test`}
+
+	t.Run(`policy`, func(t *testing.T) {
+		policy, err := ParsePolicy(strings.NewReader(testPolicyYAML))
+		assert.NoError(t, err)
+		mng := NewManagerWithLoader(NewPolicyLoader(policy), time.Minute)
+		assert.NoError(t, mng.RegisterNewOwningPermissions((*testObject)(nil), []string{`view`, `delete`}))
+		assert.Equal(t, 2, len(mng.Roles(ctx)))
+		assert.True(t, mng.Role(ctx, `admin`).CheckPermissions(ctx, obj, `view.all`))
+		assert.False(t, mng.Role(ctx, `admin`).CheckPermissions(ctx, obj, `delete.all`))
+	})
+
+	t.Run(`file`, func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), `policy.yml`)
+		assert.NoError(t, os.WriteFile(filename, []byte(testPolicyYAML), 0o600))
+		roles, err := NewFilePolicyLoader(filename).LoadRoles(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(roles))
+
+		_, err = NewFilePolicyLoader(filename + `.not-exists`).LoadRoles(ctx)
+		assert.Error(t, err)
+		assert.Nil(t, NewFilePolicyLoader(filename+`.not-exists`).ListRoles(ctx))
+	})
+}
@@ -2,6 +2,10 @@ package rbac
 
 import (
 	"context"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/demdxx/xtypes"
 )
@@ -18,6 +22,9 @@ type Role interface {
 
 	// HasRole returns true if role has role
 	HasRole(name string) bool
+
+	// Decide returns the decision of the check with explanation of the result
+	Decide(ctx context.Context, resource any, patterns ...string) Decision
 }
 
 // Role base object
@@ -36,8 +43,28 @@ type role struct {
 
 	// List of wildcard permissions to preload
 	// after role creation and register in the manager
+	// (patterns with `!` prefix are preloaded as deny permissions)
 	preloadPermissions []string
 
+	// Patterns of preloaded permissions and keys of the permissions loaded by them
+	preloadedPatterns []string
+	preloaded         map[permissionKey]bool
+
+	// Conditions of the permissions preloaded by the patterns
+	conditions map[string]*Condition
+
+	// Algorithm of combining allow and deny permissions
+	combining CombiningAlgorithm
+
+	// Validity period and schedule of the role (see WithValidity, WithSchedule)
+	window *timeWindow
+
+	// Flattened effective permissions of the role (see WithFlattenedRoles)
+	index atomic.Pointer[roleIndex]
+
+	// Changes of the role which make the flattened indexes outdated
+	generation atomic.Uint64
+
 	// Additional data
 	extData any
 }
@@ -77,35 +104,105 @@ func (r *role) CheckPermissions(ctx context.Context, resource any, names ...stri
 	if len(names) == 0 {
 		panic(ErrInvalidCheckParams)
 	}
-	for _, p := range r.permissions {
-		if p.CheckPermissions(ctx, resource, names...) {
-			return true
-		}
-	}
-	for _, r := range r.roles {
-		if r.CheckPermissions(ctx, resource, names...) {
-			return true
-		}
-	}
-	return false
+	return r.CheckedPermissions(ctx, resource, names...) != nil
+}
+
+// CheckPermissionsE of some resource, returns error if some check callback failed
+func (r *role) CheckPermissionsE(ctx context.Context, resource any, names ...string) (bool, error) {
+	p, err := r.CheckedPermissionsE(ctx, resource, names...)
+	return p != nil, err
 }
 
 // CheckedPermission returns child permission for resource which has been checked as allowed
 func (r *role) CheckedPermissions(ctx context.Context, resource any, names ...string) Permission {
+	p, _ := r.CheckedPermissionsE(ctx, resource, names...)
+	return p
+}
+
+// CheckedPermissionsE returns child permission for resource which has been checked as allowed
+// or error if some check callback of the role or child roles failed
+//
+// The result is memoized by the decision cache of the context (see WithDecisionCache).
+func (r *role) CheckedPermissionsE(ctx context.Context, resource any, names ...string) (Permission, error) {
 	if len(names) == 0 {
-		return nil
+		return nil, ErrInvalidCheckParams
 	}
-	for _, p := range r.permissions {
-		if perm := p.CheckedPermissions(ctx, resource, names...); perm != nil {
-			return perm
+	cache := decisionCacheFromContext(ctx)
+	if cache == nil {
+		return checkedPermissionE(newEvalState(ctx, resource, names), FirstApplicable, r)
+	}
+	key, ok := cache.key(ctx, r.name, resource, names)
+	if ok {
+		if perm, ok := cache.get(key); ok {
+			return perm, nil
 		}
 	}
-	for _, r := range r.roles {
-		if perm := r.CheckedPermissions(ctx, resource, names...); perm != nil {
-			return perm
+	st := newEvalState(ctx, resource, names)
+	perm, err := checkedPermissionE(st, FirstApplicable, r)
+	if ok && err == nil && !st.uncacheable {
+		cache.set(key, perm)
+	}
+	return perm, err
+}
+
+// visitMatches of the role returns the combined result of the role as the single match
+//
+// Roles which are already on the evaluation path (cycles) or exceed MaxRoleDepth are skipped,
+// roles shared by several parents are evaluated once per check.
+func (r *role) visitMatches(st *evalState, fn matchFunc) bool {
+	if !r.window.active(st.now()) {
+		return true
+	}
+	res, evaluated := st.roles[r]
+	if !evaluated {
+		idx := r.actualIndex()
+		indexed := idx != nil && idx.applicable(st.path)
+		if !st.enterRole(r.name) {
+			return true
+		}
+		var (
+			m  permissionMatch
+			ok bool
+		)
+		if indexed {
+			m, ok = r.combineIndexed(st, idx)
+		} else {
+			m, ok = combineMatches(r.combiningAlgorithm(), func(fn matchFunc) bool {
+				for _, p := range r.permissions {
+					if !visitMatches(st, p, fn) {
+						return false
+					}
+				}
+				for _, r := range r.roles {
+					if !visitMatches(st, r, fn) {
+						return false
+					}
+				}
+				return true
+			})
 		}
+		st.leaveRole()
+		if st.err != nil {
+			return false
+		}
+		res = st.remember(r, m, ok)
 	}
-	return nil
+	if res.ok {
+		return fn(st.matchOf(res))
+	}
+	return true
+}
+
+// Decide returns the decision of the check with explanation of the result
+func (r *role) Decide(ctx context.Context, resource any, patterns ...string) Decision {
+	return Decide(ctx, r.combiningAlgorithm(), []Permission{r}, resource, patterns...)
+}
+
+func (r *role) combiningAlgorithm() CombiningAlgorithm {
+	if r.combining.valid() {
+		return r.combining
+	}
+	return DefaultCombiningAlgorithm
 }
 
 // ChildPermissions returns list of child permissions
@@ -115,6 +212,16 @@ func (r *role) ChildPermissions() []Permission {
 
 // Permission returns child permission by name
 func (r *role) Permission(name string) Permission {
+	return r.permission(name, nil, map[*role]bool{})
+}
+
+// permission searches the hierarchy, visited roles don't contain the permission
+func (r *role) permission(name string, path []string, visited map[*role]bool) Permission {
+	if visited[r] || !canEnterRole(path, r.name) {
+		return nil
+	}
+	visited[r] = true
+	path = append(path, r.name)
 	for _, p := range r.permissions {
 		if p.Name() == name {
 			return p
@@ -122,31 +229,60 @@ func (r *role) Permission(name string) Permission {
 			return child
 		}
 	}
-	for _, r := range r.roles {
-		if p := r.Permission(name); p != nil {
+	for _, child := range r.roles {
+		var p Permission
+		if cr, ok := child.(*role); ok {
+			p = cr.permission(name, path, visited)
+		} else {
+			p = child.Permission(name)
+		}
+		if p != nil {
 			return p
 		}
 	}
 	return nil
 }
 
-// Permissions returns list of child permissions
+// Permissions returns list of unique child permissions, expired permissions and roles are skipped
 func (r *role) Permissions(patterns ...string) []Permission {
+	return uniquePermissions(r.permissionList(patterns, nil, map[*role]bool{}, time.Now()))
+}
+
+// permissionList collects permissions of the hierarchy, roles shared by several parents are listed once
+func (r *role) permissionList(patterns, path []string, visited map[*role]bool, now time.Time) []Permission {
+	if visited[r] || !canEnterRole(path, r.name) || r.window.expired(now) {
+		return nil
+	}
+	visited[r] = true
+	path = append(path, r.name)
 	var result []Permission
 	for _, p := range r.permissions {
+		if grantExpired(p, now) {
+			continue
+		}
 		if len(patterns) == 0 || patterns[0] == `*` || p.MatchPermissionPattern(patterns...) {
 			result = append(result, p)
 		}
 	}
-	for _, r := range r.roles {
-		result = append(result, r.Permissions(patterns...)...)
+	for _, child := range r.roles {
+		if cr, ok := child.(*role); ok {
+			result = append(result, cr.permissionList(patterns, path, visited, now)...)
+		} else if !grantExpired(child, now) {
+			result = append(result, child.Permissions(patterns...)...)
+		}
 	}
 	return result
 }
 
-// HasPermission returns true if permission has permission
+// HasPermission returns true if the role grants any permission of the patterns,
+// deny permissions are not counted
 func (r *role) HasPermission(patterns ...string) bool {
-	return len(r.Permissions(patterns...)) > 0
+	for _, perm := range r.Permissions(patterns...) {
+		if PermissionEffect(perm) == Allow {
+			return true
+		}
+	}
+	return false
 }
 
 // MatchPermissionPattern returns true if permission matches any of the patterns
@@ -161,15 +297,32 @@ func (r *role) ChildRoles() []Role {
 
 // Role returns role by name
 func (r *role) Role(name string) Role {
+	return r.findRole(name, nil, map[*role]bool{})
+}
+
+// findRole searches the hierarchy, visited roles don't contain the role
+func (r *role) findRole(name string, path []string, visited map[*role]bool) Role {
 	if r.Name() == name {
 		return r
 	}
-	for _, r := range r.roles {
-		if r.Name() == name {
-			return r
-		} else if child := r.Role(name); child != nil {
+	if visited[r] || !canEnterRole(path, r.name) {
+		return nil
+	}
+	visited[r] = true
+	path = append(path, r.name)
+	for _, child := range r.roles {
+		if child.Name() == name {
 			return child
 		}
+		var found Role
+		if cr, ok := child.(*role); ok {
+			found = cr.findRole(name, path, visited)
+		} else {
+			found = child.Role(name)
+		}
+		if found != nil {
+			return found
+		}
 	}
 	return nil
 }
@@ -186,14 +339,74 @@ func (r *role) Ext() any {
 
 // Prepare role for usage
 func (r *role) Prepare(ctx context.Context, perms permissionReader) Role {
+	return r.prepare(ctx, perms, nil, map[*role]bool{})
+}
+
+// prepare the hierarchy, roles shared by several parents are prepared once
+func (r *role) prepare(ctx context.Context, perms permissionReader, path []string, visited map[*role]bool) Role {
+	if visited[r] || !canEnterRole(path, r.name) {
+		return r
+	}
+	visited[r] = true
+	path = append(path, r.name)
 	if len(r.preloadPermissions) > 0 {
-		r.AddPermissions(perms.Permissions(r.preloadPermissions...)...)
+		var preloaded []Permission
+		// Patterns are resolved in the declaration order for FirstApplicable, consecutive
+		// patterns of the same effect are resolved together, conditional ones separately
+		for i := 0; i < len(r.preloadPermissions); {
+			pattern := r.preloadPermissions[i]
+			cond, deny := r.conditions[pattern], strings.HasPrefix(pattern, `!`)
+			run := []string{strings.TrimPrefix(pattern, `!`)}
+			for i++; cond == nil && i < len(r.preloadPermissions); i++ {
+				next := r.preloadPermissions[i]
+				if r.conditions[next] != nil || strings.HasPrefix(next, `!`) != deny {
+					break
+				}
+				run = append(run, strings.TrimPrefix(next, `!`))
+			}
+			// Matched permissions are ordered by name for the stable decisions
+			list := slices.Clone(perms.Permissions(run...))
+			slices.SortStableFunc(list, func(a, b Permission) int { return strings.Compare(a.Name(), b.Name()) })
+			for _, p := range list {
+				if deny {
+					p = &deniedPermission{perm: p}
+				}
+				if cond != nil {
+					p = &conditionalPermission{perm: p, cond: cond}
+				}
+				preloaded = append(preloaded, p)
+			}
+		}
+		if r.preloaded == nil {
+			r.preloaded = make(map[permissionKey]bool, len(preloaded))
+		}
+		for _, p := range preloaded {
+			r.preloaded[keyOfPermission(p)] = true
+		}
+		r.AddPermissions(preloaded...)
+		r.preloadedPatterns = append(r.preloadedPatterns, r.preloadPermissions...)
 		r.preloadPermissions = nil
 	}
-	for i, role := range r.roles {
-		switch rolei := role.(type) {
+	if !r.combining.valid() {
+		if cp, ok := perms.(combiningProvider); ok {
+			r.combining = cp.CombiningAlgorithm()
+			r.generation.Add(1)
+		}
+	}
+	for i, child := range r.roles {
+		var prepared Role
+		switch rolei := child.(type) {
+		case *role:
+			prepared = rolei.prepare(ctx, perms, path, visited)
 		case rolePreparer:
-			r.roles[i] = rolei.Prepare(ctx, perms)
+			prepared = rolei.Prepare(ctx, perms)
+		default:
+			continue
+		}
+		// The prepared hierarchy is not changed by the repeated preparing
+		if prepared != child {
+			r.roles[i] = prepared
+			r.generation.Add(1)
 		}
 	}
 	return r
@@ -201,14 +414,34 @@ func (r *role) Prepare(ctx context.Context, perms permissionReader) Role {
 
 // AddPermissions to the role and remove duplicates
 func (r *role) AddPermissions(permissions ...Permission) {
-	r.permissions = append(r.permissions, permissions...)
-	names := map[string]bool{}
-	r.permissions = xtypes.Slice[Permission](r.permissions).Filter(func(p Permission) bool {
-		name := p.Name()
-		not := !names[name]
+	defer r.generation.Add(1)
+	r.permissions = uniquePermissions(append(r.permissions, permissions...))
+}
+
+// uniquePermissions removes duplicates of the permissions keeping the first one
+func uniquePermissions(permissions []Permission) []Permission {
+	keys := map[permissionKey]bool{}
+	return xtypes.Slice[Permission](permissions).Filter(func(p Permission) bool {
+		key := keyOfPermission(p)
+		not := !keys[key]
 		if not {
-			names[name] = true
+			keys[key] = true
 		}
 		return not
 	})
 }
+
+// permissionKey identifies the permission in the role
+type permissionKey struct {
+	name      string
+	effect    Effect
+	condition string
+}
+
+func keyOfPermission(p Permission) permissionKey {
+	key := permissionKey{name: p.Name(), effect: PermissionEffect(p)}
+	if cp, ok := p.(*conditionalPermission); ok {
+		key.condition = cp.cond.String()
+	}
+	return key
+}
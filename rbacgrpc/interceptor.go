@@ -0,0 +1,207 @@
+// Package rbacgrpc provides gRPC server interceptors for the permission enforcement
+package rbacgrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/demdxx/rbac"
+)
+
+// ErrUnauthenticated if the credentials of the call are invalid,
+// errors of the subject extractor which wrap it are returned as codes.Unauthenticated
+var ErrUnauthenticated = errors.New(`unauthenticated`)
+
+// MethodPermissions maps gRPC methods to the permission patterns
+//
+// Keys are full method names `pkg.Service/Method` (leading slash is optional)
+// or service wildcards `pkg.Service/*`. The exact method has priority over the wildcard.
+//
+//	rbacgrpc.MethodPermissions{
+//	  `blog.Articles/*`:          {`article.view.*`},
+//	  `blog.Articles/Update`:     {`article.edit.*`},
+//	}
+type MethodPermissions map[string][]string
+
+// Patterns returns permission patterns of the full method name
+func (m MethodPermissions) Patterns(fullMethod string) ([]string, bool) {
+	method := strings.TrimPrefix(fullMethod, `/`)
+	if patterns, ok := m[method]; ok {
+		return patterns, true
+	}
+	if patterns, ok := m[`/`+method]; ok {
+		return patterns, true
+	}
+	if idx := strings.LastIndexByte(method, '/'); idx >= 0 {
+		service := method[:idx+1] + `*`
+		if patterns, ok := m[service]; ok {
+			return patterns, true
+		}
+		if patterns, ok := m[`/`+service]; ok {
+			return patterns, true
+		}
+	}
+	return nil, false
+}
+
+// SubjectExtractor returns the subject of the call, nil subject means unauthenticated call
+//
+// Errors which wrap ErrUnauthenticated (invalid credentials) are returned as codes.Unauthenticated,
+// other errors as codes.Internal.
+type SubjectExtractor func(ctx context.Context) (rbac.Subject, error)
+
+// ResourceResolver returns the resource of the call to check,
+// the request is nil for the streaming calls
+type ResourceResolver func(ctx context.Context, fullMethod string, req any) (any, error)
+
+// Interceptor of the gRPC server calls
+type Interceptor struct {
+	mng                 *rbac.Manager
+	methods             MethodPermissions
+	subjectExtractor    SubjectExtractor
+	resourceResolver    ResourceResolver
+	allowUnknownMethods bool
+	decisionReasons     bool
+}
+
+// Option of the interceptor
+type Option func(icp *Interceptor)
+
+// WithSubjectExtractor of the call, the subject of the context by default
+func WithSubjectExtractor(extractor SubjectExtractor) Option {
+	return func(icp *Interceptor) {
+		icp.subjectExtractor = extractor
+	}
+}
+
+// WithResourceResolver of the call, nil resource by default
+func WithResourceResolver(resolver ResourceResolver) Option {
+	return func(icp *Interceptor) {
+		icp.resourceResolver = resolver
+	}
+}
+
+// WithAllowUnknownMethods passes calls of the methods which are not in the permissions table
+// without check, such methods are denied by default
+func WithAllowUnknownMethods() Option {
+	return func(icp *Interceptor) {
+		icp.allowUnknownMethods = true
+	}
+}
+
+// WithDecisionReasons returns the reason of the denied access in the status message,
+// the reason contains names of the permissions and roles, so it's intended for internal services and debugging
+func WithDecisionReasons() Option {
+	return func(icp *Interceptor) {
+		icp.decisionReasons = true
+	}
+}
+
+// New interceptor of the manager permissions
+func New(mng *rbac.Manager, methods MethodPermissions, options ...Option) *Interceptor {
+	icp := &Interceptor{
+		mng:              mng,
+		methods:          methods,
+		subjectExtractor: SubjectFromContext,
+	}
+	for _, opt := range options {
+		opt(icp)
+	}
+	return icp
+}
+
+// Unary returns server interceptor of the unary calls
+func (icp *Interceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := icp.check(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns server interceptor of the streaming calls
+func (icp *Interceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := icp.check(ss.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (icp *Interceptor) check(ctx context.Context, fullMethod string, req any) (context.Context, error) {
+	patterns, ok := icp.methods.Patterns(fullMethod)
+	if !ok || len(patterns) == 0 {
+		if icp.allowUnknownMethods {
+			return ctx, nil
+		}
+		return nil, status.Error(codes.PermissionDenied, `method `+fullMethod+` is not allowed`)
+	}
+	subject, err := icp.subjectExtractor(ctx)
+	if errors.Is(err, ErrUnauthenticated) {
+		return nil, status.Error(codes.Unauthenticated, ErrUnauthenticated.Error())
+	}
+	if err != nil {
+		return nil, errInternal
+	}
+	if subject == nil {
+		return nil, status.Error(codes.Unauthenticated, ErrUnauthenticated.Error())
+	}
+	var resource any
+	if icp.resourceResolver != nil {
+		if resource, err = icp.resourceResolver(ctx, fullMethod, req); err != nil {
+			return nil, errInternal
+		}
+	}
+	ctx, decision := icp.mng.Authorize(ctx, subject, resource, patterns...)
+	if decision.Err != nil {
+		return nil, errInternal
+	}
+	if !decision.Allowed() {
+		if icp.decisionReasons {
+			return nil, status.Error(codes.PermissionDenied, decision.Reason())
+		}
+		return nil, status.Error(codes.PermissionDenied, `permission denied`)
+	}
+	return ctx, nil
+}
+
+// errInternal doesn't expose errors of the subject extractor, the resource resolver and the role loading
+var errInternal = status.Error(codes.Internal, `internal error`)
+
+// serverStream with the context of the checked call
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context of the stream
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// SubjectFromContext extracts the subject from the call context (see rbac.WithSubject)
+func SubjectFromContext(ctx context.Context) (rbac.Subject, error) {
+	return rbac.SubjectFromContext(ctx), nil
+}
+
+// SubjectFromMetadata extracts the subject by the value of the incoming metadata key,
+// calls without the key are unauthenticated
+func SubjectFromMetadata(key string, lookup func(ctx context.Context, value string) (rbac.Subject, error)) SubjectExtractor {
+	return func(ctx context.Context) (rbac.Subject, error) {
+		values := metadata.ValueFromIncomingContext(ctx, key)
+		if len(values) == 0 || values[0] == `` {
+			return nil, nil
+		}
+		return lookup(ctx, values[0])
+	}
+}
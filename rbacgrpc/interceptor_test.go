@@ -0,0 +1,169 @@
+package rbacgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/demdxx/rbac"
+)
+
+func testSubjectLookup(_ context.Context, value string) (rbac.Subject, error) {
+	switch value {
+	case `expired`:
+		return nil, fmt.Errorf(`token expired: %w`, ErrUnauthenticated)
+	case `failed`:
+		return nil, errors.New(`users database is down`)
+	}
+	return rbac.NewSubject(1, 1, value), nil
+}
+
+func newTestClient(t *testing.T, icp *Interceptor) healthpb.HealthClient {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(icp.Unary()),
+		grpc.StreamInterceptor(icp.Stream()),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(`passthrough:///bufnet`,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestInterceptor(t *testing.T) {
+	ctx := context.TODO()
+	mng := rbac.NewManager(nil)
+	mng.RegisterPermission(
+		rbac.MustNewSimplePermission(`health.check`),
+		rbac.MustNewSimplePermission(`health.watch`),
+	)
+	mng.RegisterRole(ctx,
+		rbac.MustNewRole(`monitor`, rbac.WithPermissions(`health.*`)),
+		rbac.MustNewRole(`probe`, rbac.WithPermissions(`health.check`)),
+	)
+	client := newTestClient(t, New(mng, MethodPermissions{
+		`grpc.health.v1.Health/*`:      {`health.watch`},
+		`/grpc.health.v1.Health/Check`: {`health.check`},
+	}, WithSubjectExtractor(SubjectFromMetadata(`x-role`, testSubjectLookup))))
+
+	withRole := func(role string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, `x-role`, role)
+	}
+
+	t.Run(`unary`, func(t *testing.T) {
+		_, err := client.Check(withRole(`probe`), &healthpb.HealthCheckRequest{})
+		assert.NoError(t, err)
+
+		_, err = client.Check(withRole(`unknown`), &healthpb.HealthCheckRequest{})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Equal(t, `permission denied`, status.Convert(err).Message())
+
+		_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		_, err = client.Check(withRole(`expired`), &healthpb.HealthCheckRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		_, err = client.Check(withRole(`failed`), &healthpb.HealthCheckRequest{})
+		assert.Equal(t, codes.Internal, status.Code(err))
+		assert.Equal(t, `internal error`, status.Convert(err).Message())
+	})
+
+	t.Run(`stream`, func(t *testing.T) {
+		stream, err := client.Watch(withRole(`monitor`), &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.NoError(t, err)
+
+		stream, err = client.Watch(withRole(`probe`), &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+}
+
+func TestInterceptorUnknownMethods(t *testing.T) {
+	ctx := context.TODO()
+	mng := rbac.NewManager(nil)
+	methods := MethodPermissions{`grpc.health.v1.Health/Watch`: {`health.watch`}}
+
+	_, err := newTestClient(t, New(mng, methods)).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = newTestClient(t, New(mng, methods, WithAllowUnknownMethods())).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+}
+
+func TestInterceptorDecisionReasons(t *testing.T) {
+	ctx := context.TODO()
+	mng := rbac.NewManager(nil)
+	mng.RegisterPermission(rbac.MustNewSimplePermission(`health.check`))
+	client := newTestClient(t, New(mng, MethodPermissions{`grpc.health.v1.Health/*`: {`health.check`}},
+		WithSubjectExtractor(SubjectFromMetadata(`x-role`, testSubjectLookup)), WithDecisionReasons()))
+
+	_, err := client.Check(metadata.AppendToOutgoingContext(ctx, `x-role`, `unknown`), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, `no permission matches health.check`, status.Convert(err).Message())
+}
+
+func TestMethodPermissions(t *testing.T) {
+	methods := MethodPermissions{
+		`pkg.Service/*`:         {`service.*`},
+		`pkg.Service/Get`:       {`service.get`},
+		`/pkg.Other/List`:       {`other.list`},
+		`/pkg.Wildcard/*`:       {`wildcard.*`},
+		`pkg.Service/Forbidden`: nil,
+	}
+	tests := []struct {
+		method   string
+		patterns []string
+		ok       bool
+	}{
+		{method: `/pkg.Service/Get`, patterns: []string{`service.get`}, ok: true},
+		{method: `/pkg.Service/List`, patterns: []string{`service.*`}, ok: true},
+		{method: `/pkg.Other/List`, patterns: []string{`other.list`}, ok: true},
+		{method: `/pkg.Wildcard/Any`, patterns: []string{`wildcard.*`}, ok: true},
+		{method: `/pkg.Other/Get`},
+	}
+	for _, test := range tests {
+		patterns, ok := methods.Patterns(test.method)
+		assert.Equal(t, test.ok, ok, test.method)
+		assert.Equal(t, test.patterns, patterns, test.method)
+	}
+}
+
+func TestInterceptorContext(t *testing.T) {
+	ctx := context.TODO()
+	mng := rbac.NewManager(nil)
+	mng.RegisterPermission(rbac.MustNewSimplePermission(`health.check`))
+	mng.RegisterRole(ctx, rbac.MustNewRole(`probe`, rbac.WithPermissions(`health.check`)))
+	icp := New(mng, MethodPermissions{`grpc.health.v1.Health/*`: {`health.check`}})
+
+	resp, err := icp.Unary()(rbac.WithSubject(ctx, rbac.NewSubject(1, 1, `probe`)), nil,
+		&grpc.UnaryServerInfo{FullMethod: `/grpc.health.v1.Health/Check`},
+		func(ctx context.Context, _ any) (any, error) {
+			assert.NotNil(t, rbac.SubjectFromContext(ctx))
+			assert.Equal(t, 1, len(rbac.RolesFromContext(ctx)))
+			return `ok`, nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, `ok`, resp)
+}
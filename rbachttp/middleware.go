@@ -0,0 +1,222 @@
+// Package rbachttp provides net/http middleware for the permission enforcement
+package rbachttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/demdxx/rbac"
+)
+
+var (
+	// ErrUnauthorized if the subject of the request is not defined
+	ErrUnauthorized = errors.New(`unauthorized`)
+
+	// ErrForbidden if the subject has no permission to access the resource
+	ErrForbidden = errors.New(`forbidden`)
+)
+
+// DeniedError describes the decision which denied the access
+type DeniedError struct {
+	Decision rbac.Decision
+}
+
+// Error returns the reason of the decision
+func (e *DeniedError) Error() string {
+	return e.Decision.Reason()
+}
+
+// Unwrap returns ErrForbidden
+func (e *DeniedError) Unwrap() error {
+	return ErrForbidden
+}
+
+// SubjectExtractor returns the subject of the request, nil subject means unauthorized request
+//
+// Errors which wrap ErrUnauthorized (invalid credentials) are responded with 401, other errors with 500.
+type SubjectExtractor func(r *http.Request) (rbac.Subject, error)
+
+// ResourceResolver returns the resource of the request to check
+type ResourceResolver func(r *http.Request) (any, error)
+
+// ErrorHandler writes the response of the failed check, the error contains the details
+// of the decision which must not be exposed to the clients
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+// Enforcer of the permissions for HTTP handlers
+type Enforcer struct {
+	mng              *rbac.Manager
+	subjectExtractor SubjectExtractor
+	resourceResolver ResourceResolver
+	errorHandler     ErrorHandler
+}
+
+// Option of the enforcer
+type Option func(enf *Enforcer)
+
+// WithSubjectExtractor of the request, the subject of the request context by default
+func WithSubjectExtractor(extractor SubjectExtractor) Option {
+	return func(enf *Enforcer) {
+		enf.subjectExtractor = extractor
+	}
+}
+
+// WithResourceResolver of the request, nil resource by default
+func WithResourceResolver(resolver ResourceResolver) Option {
+	return func(enf *Enforcer) {
+		enf.resourceResolver = resolver
+	}
+}
+
+// WithResource to check for every request
+func WithResource(resource any) Option {
+	return WithResourceResolver(func(*http.Request) (any, error) { return resource, nil })
+}
+
+// WithErrorHandler of the failed checks, JSON response by default
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(enf *Enforcer) {
+		enf.errorHandler = handler
+	}
+}
+
+// New enforcer of the manager permissions
+func New(mng *rbac.Manager, options ...Option) *Enforcer {
+	enf := &Enforcer{
+		mng:              mng,
+		subjectExtractor: SubjectFromRequestContext,
+		errorHandler:     JSONError,
+	}
+	for _, opt := range options {
+		opt(enf)
+	}
+	return enf
+}
+
+// Require returns middleware which passes only requests allowed by any of the patterns
+//
+// The decision, the subject and its roles are put in the request context for the handlers.
+// Responses:
+//
+//	401 - subject is not defined or its credentials are invalid (see SubjectExtractor)
+//	403 - access is denied
+//	500 - subject, resource, roles loading or check callback error
+func (enf *Enforcer) Require(patterns ...string) func(http.Handler) http.Handler {
+	if len(patterns) == 0 {
+		panic(rbac.ErrInvalidCheckParams)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, status, err := enf.check(r, patterns)
+			if err != nil {
+				enf.errorHandler(w, r, status, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Require returns middleware with default enforcer of the manager
+func Require(mng *rbac.Manager, patterns ...string) func(http.Handler) http.Handler {
+	return New(mng).Require(patterns...)
+}
+
+func (enf *Enforcer) check(r *http.Request, patterns []string) (context.Context, int, error) {
+	subject, err := enf.subjectExtractor(r)
+	if errors.Is(err, ErrUnauthorized) {
+		return nil, http.StatusUnauthorized, err
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if subject == nil {
+		return nil, http.StatusUnauthorized, ErrUnauthorized
+	}
+	var resource any
+	if enf.resourceResolver != nil {
+		if resource, err = enf.resourceResolver(r); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+	ctx, decision := enf.mng.Authorize(r.Context(), subject, resource, patterns...)
+	if decision.Err != nil {
+		return nil, http.StatusInternalServerError, decision.Err
+	}
+	if !decision.Allowed() {
+		return nil, http.StatusForbidden, &DeniedError{Decision: decision}
+	}
+	return context.WithValue(ctx, decisionCtxKey{}, decision), http.StatusOK, nil
+}
+
+type decisionCtxKey struct{}
+
+// DecisionFromContext returns the decision of the middleware
+func DecisionFromContext(ctx context.Context) (rbac.Decision, bool) {
+	decision, ok := ctx.Value(decisionCtxKey{}).(rbac.Decision)
+	return decision, ok
+}
+
+// PermissionFromContext returns the checked permission which allowed the request or nil
+func PermissionFromContext(ctx context.Context) rbac.Permission {
+	decision, _ := DecisionFromContext(ctx)
+	return decision.Permission
+}
+
+// SubjectFromRequestContext extracts the subject from the request context (see rbac.WithSubject)
+func SubjectFromRequestContext(r *http.Request) (rbac.Subject, error) {
+	return rbac.SubjectFromContext(r.Context()), nil
+}
+
+// SubjectFromContextKey extracts the subject from the request context value by the key
+func SubjectFromContextKey(key any) SubjectExtractor {
+	return func(r *http.Request) (rbac.Subject, error) {
+		subject, _ := r.Context().Value(key).(rbac.Subject)
+		return subject, nil
+	}
+}
+
+// SubjectFromHeader extracts the subject by the value of the request header,
+// requests without the header are unauthorized
+func SubjectFromHeader(header string, lookup func(ctx context.Context, value string) (rbac.Subject, error)) SubjectExtractor {
+	return func(r *http.Request) (rbac.Subject, error) {
+		value := r.Header.Get(header)
+		if value == `` {
+			return nil, nil
+		}
+		return lookup(r.Context(), value)
+	}
+}
+
+// JSONError writes the error response in JSON format without the details of the error
+//
+//	{"code": 403, "error": "Forbidden"}
+func JSONError(w http.ResponseWriter, _ *http.Request, status int, _ error) {
+	writeJSONError(w, status, ``)
+}
+
+// JSONErrorWithReason writes the error response in JSON format with the reason of the denied access,
+// the reason contains names of the permissions and roles, so it's intended for internal services and debugging.
+// The reason of internal errors is not exposed.
+//
+//	{"code": 403, "error": "Forbidden", "reason": "no permission matches view"}
+func JSONErrorWithReason(w http.ResponseWriter, _ *http.Request, status int, err error) {
+	reason := ``
+	if status < http.StatusInternalServerError {
+		reason = err.Error()
+	}
+	writeJSONError(w, status, reason)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, reason string) {
+	resp := struct {
+		Code   int    `json:"code"`
+		Error  string `json:"error"`
+		Reason string `json:"reason,omitempty"`
+	}{Code: status, Error: http.StatusText(status), Reason: reason}
+	w.Header().Set(`Content-Type`, `application/json; charset=utf-8`)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,172 @@
+package rbachttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/demdxx/rbac"
+)
+
+type article struct {
+	id       uint64
+	authorID uint64
+}
+
+func (a *article) RBACOwnerID() uint64 { return a.authorID }
+
+func newTestManager(t *testing.T) *rbac.Manager {
+	ctx := context.TODO()
+	mng := rbac.NewManager(nil)
+	assert.NoError(t, mng.RegisterNewOwningPermissions((*article)(nil), []string{`view`, `edit`}))
+	mng.RegisterRole(ctx,
+		rbac.MustNewRole(`reader`, rbac.WithPermissions(`rbachttp.article.view.all`, `rbachttp.article.edit.owner`)),
+	)
+	return mng
+}
+
+func testSubjectLookup(_ context.Context, value string) (rbac.Subject, error) {
+	if value == `expired` {
+		return nil, fmt.Errorf(`token expired: %w`, ErrUnauthorized)
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return rbac.NewSubject(id, 1, `reader`), nil
+}
+
+func TestRequire(t *testing.T) {
+	mng := newTestManager(t)
+	enf := New(mng,
+		WithSubjectExtractor(SubjectFromHeader(`X-User-ID`, testSubjectLookup)),
+		WithResourceResolver(func(r *http.Request) (any, error) {
+			if r.URL.Query().Get(`fail`) != `` {
+				return nil, errors.New(`resolve failed`)
+			}
+			return &article{id: 1, authorID: 10}, nil
+		}),
+	)
+	var handled rbac.Permission
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled = PermissionFromContext(r.Context())
+		assert.NotNil(t, rbac.SubjectFromContext(r.Context()))
+		assert.Equal(t, 1, len(rbac.RolesFromContext(r.Context())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name       string
+		pattern    string
+		userID     string
+		query      string
+		status     int
+		permission string
+		body       string
+	}{
+		{name: `view`, pattern: `view`, userID: `20`, status: http.StatusNoContent, permission: `rbachttp.article.view.all`},
+		{name: `edit-owner`, pattern: `edit`, userID: `10`, status: http.StatusNoContent, permission: `rbachttp.article.edit.owner`},
+		{name: `edit-forbidden`, pattern: `edit`, userID: `20`, status: http.StatusForbidden,
+			body: `{"code":403,"error":"Forbidden"}`},
+		{name: `unauthorized`, pattern: `view`, status: http.StatusUnauthorized,
+			body: `{"code":401,"error":"Unauthorized"}`},
+		{name: `invalid-credentials`, pattern: `view`, userID: `expired`, status: http.StatusUnauthorized},
+		{name: `invalid-subject`, pattern: `view`, userID: `invalid`, status: http.StatusInternalServerError,
+			body: `{"code":500,"error":"Internal Server Error"}`},
+		{name: `resolve-error`, pattern: `view`, userID: `10`, query: `?fail=1`, status: http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handled = nil
+			req := httptest.NewRequest(http.MethodGet, `/articles/1`+test.query, nil)
+			if test.userID != `` {
+				req.Header.Set(`X-User-ID`, test.userID)
+			}
+			rec := httptest.NewRecorder()
+			enf.Require(test.pattern)(handler).ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+			if test.permission != `` && assert.NotNil(t, handled) {
+				assert.Equal(t, test.permission, handled.Name())
+			}
+			if test.body != `` {
+				assert.JSONEq(t, test.body, rec.Body.String())
+				assert.Equal(t, `application/json; charset=utf-8`, rec.Header().Get(`Content-Type`))
+			}
+		})
+	}
+}
+
+func TestRequireDefaults(t *testing.T) {
+	type subjectKey struct{}
+	mng := newTestManager(t)
+	mng.RegisterPermission(rbac.MustNewSimplePermission(`dashboard`))
+	mng.RegisterRole(context.TODO(), rbac.MustNewRole(`admin`, rbac.WithPermissions(`dashboard`)))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decision, ok := DecisionFromContext(r.Context())
+		assert.True(t, ok)
+		assert.True(t, decision.Allowed())
+	})
+
+	req := httptest.NewRequest(http.MethodGet, `/`, nil)
+	rec := httptest.NewRecorder()
+	Require(mng, `dashboard`)(handler).ServeHTTP(rec, req.WithContext(
+		rbac.WithSubject(req.Context(), rbac.NewSubject(1, 1, `admin`))))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var status int
+	enf := New(mng,
+		WithSubjectExtractor(SubjectFromContextKey(subjectKey{})),
+		WithResource(&article{authorID: 1}),
+		WithErrorHandler(func(w http.ResponseWriter, _ *http.Request, code int, err error) {
+			status = code
+			assert.ErrorIs(t, err, ErrForbidden)
+			w.WriteHeader(http.StatusNotFound)
+		}),
+	)
+	rec = httptest.NewRecorder()
+	enf.Require(`edit`)(handler).ServeHTTP(rec, req.WithContext(
+		context.WithValue(req.Context(), subjectKey{}, rbac.NewSubject(2, 1, `reader`))))
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	enf.Require(`edit`)(handler).ServeHTTP(rec, req.WithContext(
+		context.WithValue(req.Context(), subjectKey{}, rbac.NewSubject(1, 1, `reader`))))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Panics(t, func() { Require(mng) })
+}
+
+func TestRequireTenantRoles(t *testing.T) {
+	ctx := context.TODO()
+	mng := newTestManager(t)
+	mng.RegisterTenantRole(ctx, `acme`, rbac.MustNewRole(`reader`,
+		rbac.WithPermissions(`!rbachttp.article.view.*`, `rbachttp.article.edit.owner`)))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	enf := New(mng, WithResource(&article{authorID: 1}), WithErrorHandler(JSONErrorWithReason))
+
+	// Roles of the context are resolved in the scope of the subject tenant
+	req := httptest.NewRequest(http.MethodGet, `/`, nil)
+	rec := httptest.NewRecorder()
+	enf.Require(`view`)(handler).ServeHTTP(rec, req.WithContext(rbac.WithSubject(ctx,
+		&rbac.SimpleSubject{ID: 2, Tenant: `acme`, Roles: []string{`reader`}})))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.JSONEq(t, `{"code":403,"error":"Forbidden","reason":"denied by permission rbachttp.article.view.account of role reader"}`, rec.Body.String())
+
+	var roles []rbac.Role
+	enf.Require(`edit`)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		roles = rbac.RolesFromContext(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), req.WithContext(rbac.WithSubject(ctx,
+		&rbac.SimpleSubject{ID: 1, Tenant: `acme`, Roles: []string{`reader`}})))
+	if assert.Len(t, roles, 1) {
+		assert.Same(t, mng.Role(rbac.WithTenant(ctx, `acme`), `reader`), roles[0])
+	}
+}
@@ -0,0 +1,168 @@
+package rbacsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/demdxx/rbac"
+)
+
+// BindRoles assigns roles to the principal in the tenant of the context, bound roles are skipped
+func (s *Store) BindRoles(ctx context.Context, principal rbac.Principal, roles ...string) error {
+	if principal == (rbac.Principal{}) && len(roles) > 0 {
+		return fmt.Errorf(`bind roles: %w`, rbac.ErrInvalidPrincipal)
+	}
+	values := make([][]any, 0, len(roles))
+	for _, role := range uniqueStrings(roles) {
+		values = append(values, []any{role})
+	}
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		return s.insertOrdered(ctx, tx, `role_bindings`,
+			[]string{`tenant`, `subject_id`, `group_name`},
+			[]any{rbac.TenantFromContext(ctx), principal.SubjectID, principal.Group},
+			[]string{`role_name`}, values)
+	})
+}
+
+// UnbindRoles removes roles from the principal in the tenant of the context
+func (s *Store) UnbindRoles(ctx context.Context, principal rbac.Principal, roles ...string) error {
+	tenant := rbac.TenantFromContext(ctx)
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		for _, role := range roles {
+			if err := s.exec(ctx, tx,
+				`DELETE FROM {prefix}role_bindings WHERE tenant = ? AND subject_id = ? AND group_name = ? AND role_name = ?`,
+				tenant, principal.SubjectID, principal.Group, role); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddMembers to the group in the tenant of the context, existing members are skipped
+func (s *Store) AddMembers(ctx context.Context, group string, members ...rbac.Principal) error {
+	if group == `` && len(members) > 0 {
+		return fmt.Errorf(`add members (empty group): %w`, rbac.ErrInvalidPrincipal)
+	}
+	values := make([][]any, 0, len(members))
+	for _, member := range members {
+		values = append(values, []any{member.SubjectID, member.Group})
+	}
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		return s.insertOrdered(ctx, tx, `group_members`,
+			[]string{`tenant`, `group_name`},
+			[]any{rbac.TenantFromContext(ctx), group},
+			[]string{`member_subject_id`, `member_group`}, values)
+	})
+}
+
+// RemoveMembers from the group in the tenant of the context
+func (s *Store) RemoveMembers(ctx context.Context, group string, members ...rbac.Principal) error {
+	tenant := rbac.TenantFromContext(ctx)
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		for _, member := range members {
+			if err := s.exec(ctx, tx,
+				`DELETE FROM {prefix}group_members WHERE tenant = ? AND group_name = ? AND member_subject_id = ? AND member_group = ?`,
+				tenant, group, member.SubjectID, member.Group); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PrincipalRoles returns names of the roles bound to the principal in order of binding
+func (s *Store) PrincipalRoles(ctx context.Context, principal rbac.Principal) ([]string, error) {
+	return s.roleLinks(ctx,
+		`SELECT role_name FROM {prefix}role_bindings WHERE tenant = ? AND subject_id = ? AND group_name = ? ORDER BY sort_order`,
+		rbac.TenantFromContext(ctx), principal.SubjectID, principal.Group)
+}
+
+// SubjectRoles returns names of the roles bound to the subject directly and through the nested groups
+// in the tenant of the context, the groups are resolved by the single recursive query
+func (s *Store) SubjectRoles(ctx context.Context, subjectID uint64) ([]string, error) {
+	tenant := rbac.TenantFromContext(ctx)
+	return s.roleLinks(ctx,
+		`WITH RECURSIVE subject_groups (group_name) AS (
+			SELECT group_name FROM {prefix}group_members
+			WHERE tenant = ? AND member_subject_id = ? AND member_group = ''
+			UNION
+			SELECT m.group_name FROM {prefix}group_members m
+			JOIN subject_groups g ON m.member_group = g.group_name
+			WHERE m.tenant = ?
+		)
+		SELECT DISTINCT role_name FROM {prefix}role_bindings
+		WHERE tenant = ? AND (subject_id = ? AND group_name = ''
+			OR subject_id = 0 AND group_name IN (SELECT group_name FROM subject_groups))
+		ORDER BY role_name`,
+		tenant, subjectID, tenant, tenant, subjectID)
+}
+
+// RoleBindings returns principals the role is bound to
+func (s *Store) RoleBindings(ctx context.Context, role string) ([]rbac.Principal, error) {
+	return s.principals(ctx,
+		`SELECT subject_id, group_name FROM {prefix}role_bindings WHERE tenant = ? AND role_name = ? ORDER BY group_name, subject_id`,
+		rbac.TenantFromContext(ctx), role)
+}
+
+// Groups returns names of the groups which contain the principal
+func (s *Store) Groups(ctx context.Context, member rbac.Principal) ([]string, error) {
+	return s.roleLinks(ctx,
+		`SELECT group_name FROM {prefix}group_members WHERE tenant = ? AND member_subject_id = ? AND member_group = ? ORDER BY group_name`,
+		rbac.TenantFromContext(ctx), member.SubjectID, member.Group)
+}
+
+// Members returns subjects and groups of the group in order of adding
+func (s *Store) Members(ctx context.Context, group string) ([]rbac.Principal, error) {
+	return s.principals(ctx,
+		`SELECT member_subject_id, member_group FROM {prefix}group_members WHERE tenant = ? AND group_name = ? ORDER BY sort_order`,
+		rbac.TenantFromContext(ctx), group)
+}
+
+// insertOrdered rows of the key with the values in order of adding, existing values are skipped
+func (s *Store) insertOrdered(ctx context.Context, tx *sql.Tx, table string, keys []string, keyArgs []any, columns []string, values [][]any) error {
+	where := strings.Join(keys, ` = ? AND `) + ` = ?`
+	var order sql.NullInt64
+	if err := tx.QueryRowContext(ctx, s.query(
+		`SELECT MAX(sort_order) FROM {prefix}`+table+` WHERE `+where), keyArgs...).Scan(&order); err != nil {
+		return err
+	}
+	insert := `INSERT INTO {prefix}` + table + ` (` + strings.Join(keys, `, `) + `, ` + strings.Join(columns, `, `) +
+		`, sort_order) VALUES (?` + strings.Repeat(`, ?`, len(keys)+len(columns)) + `)`
+	for _, value := range values {
+		var n int
+		args := append(append([]any{}, keyArgs...), value...)
+		if err := tx.QueryRowContext(ctx, s.query(
+			`SELECT COUNT(*) FROM {prefix}`+table+` WHERE `+where+` AND `+strings.Join(columns, ` = ? AND `)+` = ?`),
+			args...).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		order.Int64++
+		if err := s.exec(ctx, tx, insert, append(args, order.Int64)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) principals(ctx context.Context, q string, args ...any) ([]rbac.Principal, error) {
+	rows, err := s.db.QueryContext(ctx, s.query(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	var principals []rbac.Principal
+	for rows.Next() {
+		var principal rbac.Principal
+		if err := rows.Scan(&principal.SubjectID, &principal.Group); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		principals = append(principals, principal)
+	}
+	return principals, closeRows(rows)
+}
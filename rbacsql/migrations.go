@@ -0,0 +1,126 @@
+package rbacsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// migration of the database schema
+type migration struct {
+	version    int
+	statements []string
+}
+
+// migrations of the schema in order of versions, `{prefix}` is replaced with the table prefix
+//
+// Columns with defaults are VARCHAR, MySQL before 8.0.13 doesn't support defaults of TEXT columns.
+var migrations = []migration{
+	{
+		version: 1,
+		statements: []string{
+			`CREATE TABLE {prefix}roles (
+				name        VARCHAR(255)  NOT NULL PRIMARY KEY,
+				description VARCHAR(1024) NOT NULL DEFAULT '',
+				combining   VARCHAR(64)   NOT NULL DEFAULT '',
+				validity    TEXT,
+				ext         TEXT
+			)`,
+			`CREATE TABLE {prefix}role_permissions (
+				role_name      VARCHAR(255)  NOT NULL,
+				pattern        VARCHAR(255)  NOT NULL,
+				condition_expr VARCHAR(2048) NOT NULL DEFAULT '',
+				sort_order     INTEGER       NOT NULL DEFAULT 0,
+				PRIMARY KEY (role_name, pattern)
+			)`,
+			`CREATE TABLE {prefix}role_children (
+				role_name  VARCHAR(255) NOT NULL,
+				child_name VARCHAR(255) NOT NULL,
+				sort_order INTEGER      NOT NULL DEFAULT 0,
+				PRIMARY KEY (role_name, child_name)
+			)`,
+			`CREATE TABLE {prefix}role_bindings (
+				tenant     VARCHAR(128) NOT NULL DEFAULT '',
+				subject_id BIGINT       NOT NULL DEFAULT 0,
+				group_name VARCHAR(255) NOT NULL DEFAULT '',
+				role_name  VARCHAR(255) NOT NULL,
+				sort_order INTEGER      NOT NULL DEFAULT 0,
+				PRIMARY KEY (tenant, subject_id, group_name, role_name)
+			)`,
+			`CREATE INDEX {prefix}role_bindings_role_name_idx ON {prefix}role_bindings (tenant, role_name)`,
+			`CREATE TABLE {prefix}group_members (
+				tenant            VARCHAR(128) NOT NULL DEFAULT '',
+				group_name        VARCHAR(255) NOT NULL,
+				member_subject_id BIGINT       NOT NULL DEFAULT 0,
+				member_group      VARCHAR(255) NOT NULL DEFAULT '',
+				sort_order        INTEGER      NOT NULL DEFAULT 0,
+				PRIMARY KEY (tenant, group_name, member_subject_id, member_group)
+			)`,
+			`CREATE INDEX {prefix}group_members_member_idx ON {prefix}group_members (tenant, member_subject_id, member_group)`,
+		},
+	},
+}
+
+// Migrate the database schema to the latest version
+//
+// Every migration is applied in the transaction and registered in the `schema_migrations` table.
+func (s *Store) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, s.query(
+		`CREATE TABLE IF NOT EXISTS {prefix}schema_migrations (version INTEGER NOT NULL PRIMARY KEY)`))
+	if err != nil {
+		return fmt.Errorf(`create migrations table: %w`, err)
+	}
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := s.tx(ctx, func(tx *sql.Tx) error {
+			for _, stmt := range m.statements {
+				if _, err := tx.ExecContext(ctx, s.query(stmt)); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx, s.query(`INSERT INTO {prefix}schema_migrations (version) VALUES (?)`), m.version)
+			return err
+		}); err != nil {
+			return fmt.Errorf(`migration %d: %w`, m.version, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the applied migrations, 0 if the schema is not migrated
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	var version sql.NullInt64
+	err := s.db.QueryRowContext(ctx, s.query(`SELECT MAX(version) FROM {prefix}schema_migrations`)).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf(`schema version: %w`, err)
+	}
+	return int(version.Int64), nil
+}
+
+// query with the table prefix and placeholders of the dialect
+func (s *Store) query(q string) string {
+	q = strings.ReplaceAll(q, `{prefix}`, s.tablePrefix)
+	if !s.dollarPlaceholders {
+		return q
+	}
+	var (
+		buf strings.Builder
+		n   int
+	)
+	for _, c := range q {
+		if c == '?' {
+			n++
+			fmt.Fprintf(&buf, `$%d`, n)
+		} else {
+			buf.WriteRune(c)
+		}
+	}
+	return buf.String()
+}
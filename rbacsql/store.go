@@ -0,0 +1,515 @@
+// Package rbacsql implements rbac.Store on top of database/sql
+//
+// The store works with SQLite, PostgreSQL (see WithDollarPlaceholders) and MySQL (8.0+),
+// the schema is created by the Migrate method. Stored roles are global, see rbac.Store.
+package rbacsql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/demdxx/rbac"
+)
+
+// Store of the roles in the SQL database
+type Store struct {
+	db                 *sql.DB
+	tablePrefix        string
+	dollarPlaceholders bool
+
+	mx          sync.RWMutex
+	subscribers []func(names ...string)
+}
+
+var (
+	_ rbac.Store               = (*Store)(nil)
+	_ rbac.BindingStore        = (*Store)(nil)
+	_ rbac.SubjectRoleResolver = (*Store)(nil)
+	_ rbac.ChangeNotifier      = (*Store)(nil)
+)
+
+// Option of the store
+type Option func(s *Store)
+
+// WithTablePrefix of the store tables, `rbac_` by default
+func WithTablePrefix(prefix string) Option {
+	return func(s *Store) {
+		s.tablePrefix = prefix
+	}
+}
+
+// WithDollarPlaceholders of the query parameters ($1, $2...) for PostgreSQL
+func WithDollarPlaceholders() Option {
+	return func(s *Store) {
+		s.dollarPlaceholders = true
+	}
+}
+
+// New store of the database
+func New(db *sql.DB, options ...Option) *Store {
+	s := &Store{db: db, tablePrefix: `rbac_`}
+	for _, opt := range options {
+		opt(s)
+	}
+	return s
+}
+
+// SubscribeRoleChanges of the store, the subscriber receives names of the changed roles
+//
+// Changes made by other processes are not tracked and should be pushed by Notify.
+func (s *Store) SubscribeRoleChanges(fn func(names ...string)) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// Notify subscribers about the changed roles, empty list means all roles
+func (s *Store) Notify(names ...string) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	for _, fn := range s.subscribers {
+		fn(names...)
+	}
+}
+
+// notify subscribers if the change was successful
+func (s *Store) notify(err error, names ...string) error {
+	if err == nil {
+		s.Notify(names...)
+	}
+	return err
+}
+
+// ListRoles returns all stored role definitions ordered by name
+func (s *Store) ListRoles(ctx context.Context) ([]rbac.PolicyRole, error) {
+	rows, err := s.db.QueryContext(ctx, s.query(
+		`SELECT name, description, combining, ext, validity FROM {prefix}roles ORDER BY name`))
+	if err != nil {
+		return nil, err
+	}
+	var (
+		roles []rbac.PolicyRole
+		index = map[string]int{}
+	)
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		index[role.Name] = len(roles)
+		roles = append(roles, *role)
+	}
+	if err := closeRows(rows); err != nil {
+		return nil, err
+	}
+	err = s.scanPermissions(ctx, `SELECT role_name, pattern, condition_expr FROM {prefix}role_permissions ORDER BY role_name, sort_order`,
+		func(role, pattern, condition string) {
+			if i, ok := index[role]; ok {
+				addPermission(&roles[i], pattern, condition)
+			}
+		})
+	if err != nil {
+		return nil, err
+	}
+	err = s.scanLinks(ctx, s.db, `SELECT role_name, child_name FROM {prefix}role_children ORDER BY role_name, sort_order`,
+		func(role, child string) {
+			if i, ok := index[role]; ok {
+				roles[i].Roles = append(roles[i].Roles, child)
+			}
+		})
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// GetRole returns role definition by name or rbac.ErrUnknownRole
+func (s *Store) GetRole(ctx context.Context, name string) (*rbac.PolicyRole, error) {
+	role, err := scanRole(s.db.QueryRowContext(ctx, s.query(
+		`SELECT name, description, combining, ext, validity FROM {prefix}roles WHERE name = ?`), name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf(`%s: %w`, name, rbac.ErrUnknownRole)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = s.scanPermissions(ctx,
+		`SELECT role_name, pattern, condition_expr FROM {prefix}role_permissions WHERE role_name = ? ORDER BY sort_order`,
+		func(_, pattern, condition string) { addPermission(role, pattern, condition) }, name); err != nil {
+		return nil, err
+	}
+	if role.Roles, err = s.roleLinks(ctx,
+		`SELECT child_name FROM {prefix}role_children WHERE role_name = ? ORDER BY sort_order`, name); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// CreateRole with permissions and child roles, returns rbac.ErrRoleExists if the role is already stored
+func (s *Store) CreateRole(ctx context.Context, role *rbac.PolicyRole) error {
+	ext, validity, err := validateRole(role)
+	if err != nil {
+		return err
+	}
+	return s.notify(s.tx(ctx, func(tx *sql.Tx) error {
+		if exists, err := s.roleExists(ctx, tx, role.Name); err != nil {
+			return err
+		} else if exists {
+			return fmt.Errorf(`%s: %w`, role.Name, rbac.ErrRoleExists)
+		}
+		if _, err := tx.ExecContext(ctx, s.query(
+			`INSERT INTO {prefix}roles (name, description, combining, ext, validity) VALUES (?, ?, ?, ?, ?)`),
+			role.Name, role.Description, role.Combining, ext, validity); err != nil {
+			return err
+		}
+		if err := s.insertLinks(ctx, tx, role); err != nil {
+			return err
+		}
+		return s.validateChildren(ctx, tx, role.Name, role.Roles)
+	}), role.Name)
+}
+
+// UpdateRole replaces the role definition, returns rbac.ErrUnknownRole if the role is not stored
+func (s *Store) UpdateRole(ctx context.Context, role *rbac.PolicyRole) error {
+	ext, validity, err := validateRole(role)
+	if err != nil {
+		return err
+	}
+	return s.notify(s.tx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, s.query(
+			`UPDATE {prefix}roles SET description = ?, combining = ?, ext = ?, validity = ? WHERE name = ?`),
+			role.Description, role.Combining, ext, validity, role.Name)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf(`%s: %w`, role.Name, rbac.ErrUnknownRole)
+		}
+		if err := s.exec(ctx, tx, `DELETE FROM {prefix}role_permissions WHERE role_name = ?`, role.Name); err != nil {
+			return err
+		}
+		if err := s.exec(ctx, tx, `DELETE FROM {prefix}role_children WHERE role_name = ?`, role.Name); err != nil {
+			return err
+		}
+		if err := s.insertLinks(ctx, tx, role); err != nil {
+			return err
+		}
+		return s.validateChildren(ctx, tx, role.Name, role.Roles)
+	}), role.Name)
+}
+
+// DeleteRole with its permissions, inheritance links and bindings of all tenants
+func (s *Store) DeleteRole(ctx context.Context, name string) error {
+	return s.notify(s.tx(ctx, func(tx *sql.Tx) error {
+		if err := s.exec(ctx, tx, `DELETE FROM {prefix}role_children WHERE role_name = ? OR child_name = ?`,
+			name, name); err != nil {
+			return err
+		}
+		for _, q := range []string{
+			`DELETE FROM {prefix}role_permissions WHERE role_name = ?`,
+			`DELETE FROM {prefix}role_bindings WHERE role_name = ?`,
+			`DELETE FROM {prefix}roles WHERE name = ?`,
+		} {
+			if err := s.exec(ctx, tx, q, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}), name)
+}
+
+// AddRolePermissions assigns permission patterns to the role, assigned patterns are skipped
+func (s *Store) AddRolePermissions(ctx context.Context, role string, patterns ...string) error {
+	return s.notify(s.addLinks(ctx, `role_permissions`, `pattern`, role, patterns, nil), role)
+}
+
+// RemoveRolePermissions unassigns permission patterns from the role
+func (s *Store) RemoveRolePermissions(ctx context.Context, role string, patterns ...string) error {
+	return s.notify(s.removeLinks(ctx, `role_permissions`, `pattern`, role, patterns), role)
+}
+
+// AddChildRoles to the role, linked roles are skipped
+//
+// Returns rbac.ErrUnknownChildRole if the child is not stored and rbac.RoleCycleError
+// if the child inherits the role.
+func (s *Store) AddChildRoles(ctx context.Context, role string, children ...string) error {
+	return s.notify(s.addLinks(ctx, `role_children`, `child_name`, role, children,
+		func(tx *sql.Tx) error { return s.validateChildren(ctx, tx, role, children) }), role)
+}
+
+// RemoveChildRoles from the role
+func (s *Store) RemoveChildRoles(ctx context.Context, role string, children ...string) error {
+	return s.notify(s.removeLinks(ctx, `role_children`, `child_name`, role, children), role)
+}
+
+func (s *Store) addLinks(ctx context.Context, table, column, role string, values []string, validate func(tx *sql.Tx) error) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		if exists, err := s.roleExists(ctx, tx, role); err != nil {
+			return err
+		} else if !exists {
+			return fmt.Errorf(`%s: %w`, role, rbac.ErrUnknownRole)
+		}
+		rows := make([][]any, 0, len(values))
+		for _, value := range values {
+			rows = append(rows, []any{value})
+		}
+		if err := s.insertOrdered(ctx, tx, table, []string{`role_name`}, []any{role}, []string{column}, rows); err != nil {
+			return err
+		}
+		if validate != nil {
+			return validate(tx)
+		}
+		return nil
+	})
+}
+
+func (s *Store) removeLinks(ctx context.Context, table, column, role string, values []string) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		for _, value := range values {
+			if err := s.exec(ctx, tx,
+				`DELETE FROM {prefix}`+table+` WHERE role_name = ? AND `+column+` = ?`, role, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) insertLinks(ctx context.Context, tx *sql.Tx, role *rbac.PolicyRole) error {
+	for i, pattern := range uniqueStrings(role.Permissions) {
+		if err := s.exec(ctx, tx,
+			`INSERT INTO {prefix}role_permissions (role_name, pattern, sort_order, condition_expr) VALUES (?, ?, ?, ?)`,
+			role.Name, pattern, i+1, role.Conditions[pattern]); err != nil {
+			return err
+		}
+	}
+	for i, child := range uniqueStrings(role.Roles) {
+		if err := s.exec(ctx, tx,
+			`INSERT INTO {prefix}role_children (role_name, child_name, sort_order) VALUES (?, ?, ?)`,
+			role.Name, child, i+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateChildren of the role in the write transaction, the children must be stored
+// and must not inherit the role
+func (s *Store) validateChildren(ctx context.Context, tx *sql.Tx, role string, children []string) error {
+	for _, child := range children {
+		if exists, err := s.roleExists(ctx, tx, child); err != nil {
+			return err
+		} else if !exists {
+			return fmt.Errorf(`role %s child %s: %w`, role, child, rbac.ErrUnknownChildRole)
+		}
+	}
+	links := map[string][]string{}
+	err := s.scanLinks(ctx, tx, `SELECT role_name, child_name FROM {prefix}role_children ORDER BY role_name, sort_order`,
+		func(role, child string) { links[role] = append(links[role], child) })
+	if err != nil {
+		return err
+	}
+	if path := inheritancePath(links, role, []string{role}, map[string]bool{}); path != nil {
+		return &rbac.RoleCycleError{Path: path}
+	}
+	return nil
+}
+
+// inheritancePath from the last role of the path to the target role, nil if the target is not inherited
+func inheritancePath(links map[string][]string, target string, path []string, visited map[string]bool) []string {
+	for _, child := range links[path[len(path)-1]] {
+		if child == target {
+			return append(append([]string{}, path...), child)
+		}
+		if visited[child] {
+			continue
+		}
+		visited[child] = true
+		if found := inheritancePath(links, target, append(path, child), visited); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func (s *Store) roleExists(ctx context.Context, tx *sql.Tx, name string) (bool, error) {
+	var n int
+	err := tx.QueryRowContext(ctx, s.query(`SELECT COUNT(*) FROM {prefix}roles WHERE name = ?`), name).Scan(&n)
+	return n > 0, err
+}
+
+func (s *Store) roleLinks(ctx context.Context, q string, args ...any) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.query(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, closeRows(rows)
+}
+
+func (s *Store) scanLinks(ctx context.Context, db queryer, q string, fn func(role, value string)) error {
+	rows, err := db.QueryContext(ctx, s.query(q))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var role, value string
+		if err := rows.Scan(&role, &value); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		fn(role, value)
+	}
+	return closeRows(rows)
+}
+
+// scanPermissions of the roles with conditions
+func (s *Store) scanPermissions(ctx context.Context, q string, fn func(role, pattern, condition string), args ...any) error {
+	rows, err := s.db.QueryContext(ctx, s.query(q), args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var role, pattern, condition string
+		if err := rows.Scan(&role, &pattern, &condition); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		fn(role, pattern, condition)
+	}
+	return closeRows(rows)
+}
+
+func addPermission(role *rbac.PolicyRole, pattern, condition string) {
+	role.Permissions = append(role.Permissions, pattern)
+	if condition != `` {
+		if role.Conditions == nil {
+			role.Conditions = map[string]string{}
+		}
+		role.Conditions[pattern] = condition
+	}
+}
+
+func (s *Store) exec(ctx context.Context, tx *sql.Tx, q string, args ...any) error {
+	_, err := tx.ExecContext(ctx, s.query(q), args...)
+	return err
+}
+
+func (s *Store) tx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryer is the database or the transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// roleValidity is the stored validity period and schedule of the role
+type roleValidity struct {
+	ValidFrom *time.Time `json:"valid_from,omitempty"`
+	ValidTo   *time.Time `json:"valid_to,omitempty"`
+	Schedule  []string   `json:"schedule,omitempty"`
+}
+
+func scanRole(row rowScanner) (*rbac.PolicyRole, error) {
+	var (
+		role          rbac.PolicyRole
+		ext, validity sql.NullString
+	)
+	if err := row.Scan(&role.Name, &role.Description, &role.Combining, &ext, &validity); err != nil {
+		return nil, err
+	}
+	if ext.Valid && ext.String != `` {
+		if err := json.Unmarshal([]byte(ext.String), &role.Ext); err != nil {
+			return nil, fmt.Errorf(`role %s ext: %w`, role.Name, err)
+		}
+	}
+	if validity.Valid && validity.String != `` {
+		var v roleValidity
+		if err := json.Unmarshal([]byte(validity.String), &v); err != nil {
+			return nil, fmt.Errorf(`role %s validity: %w`, role.Name, err)
+		}
+		role.ValidFrom, role.ValidTo, role.Schedule = v.ValidFrom, v.ValidTo, v.Schedule
+	}
+	return &role, nil
+}
+
+// validateRole definition and returns encoded ext data and validity
+func validateRole(role *rbac.PolicyRole) (ext, validity sql.NullString, err error) {
+	if role == nil || role.Name == `` {
+		return ext, validity, fmt.Errorf(`role without name: %w`, rbac.ErrInvalidPolicy)
+	}
+	if role.Combining != `` {
+		if _, err := rbac.ParseCombiningAlgorithm(role.Combining); err != nil {
+			return ext, validity, fmt.Errorf(`role %s: %w`, role.Name, err)
+		}
+	}
+	for _, spec := range role.Schedule {
+		if _, err := rbac.ParseSchedule(spec); err != nil {
+			return ext, validity, fmt.Errorf(`role %s: %w`, role.Name, err)
+		}
+	}
+	if role.Ext != nil {
+		data, err := json.Marshal(role.Ext)
+		if err != nil {
+			return ext, validity, fmt.Errorf(`role %s ext: %w`, role.Name, err)
+		}
+		ext = sql.NullString{String: string(data), Valid: true}
+	}
+	if role.ValidFrom != nil || role.ValidTo != nil || len(role.Schedule) > 0 {
+		data, err := json.Marshal(roleValidity{ValidFrom: role.ValidFrom, ValidTo: role.ValidTo, Schedule: role.Schedule})
+		if err != nil {
+			return ext, validity, fmt.Errorf(`role %s validity: %w`, role.Name, err)
+		}
+		validity = sql.NullString{String: string(data), Valid: true}
+	}
+	return ext, validity, nil
+}
+
+func closeRows(rows *sql.Rows) error {
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	return rows.Close()
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
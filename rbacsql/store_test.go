@@ -0,0 +1,287 @@
+package rbacsql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/demdxx/rbac"
+)
+
+func newTestStore(t *testing.T, options ...Option) *Store {
+	db, err := sql.Open(`sqlite`, `:memory:`)
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	store := New(db, options...)
+	require.NoError(t, store.Migrate(context.TODO()))
+	return store
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.TODO()
+	store := newTestStore(t, WithTablePrefix(`acl_`))
+	version, err := store.SchemaVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	// Repeated migration is no-op
+	assert.NoError(t, store.Migrate(ctx))
+	roles, err := store.ListRoles(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, roles)
+}
+
+func TestStoreRoles(t *testing.T) {
+	ctx := context.TODO()
+	store := newTestStore(t)
+
+	require.NoError(t, store.CreateRole(ctx, &rbac.PolicyRole{Name: `viewer`, Permissions: []string{`doc.view`}}))
+	require.NoError(t, store.CreateRole(ctx, &rbac.PolicyRole{
+		Name:        `editor`,
+		Description: `Document editor`,
+		Combining:   `allow-overrides`,
+		Roles:       []string{`viewer`},
+		Permissions: []string{`doc.edit`, `!doc.delete`},
+		Conditions:  map[string]string{`doc.edit`: `resource.status == "draft"`},
+		Ext:         map[string]any{`level`: float64(2)},
+	}))
+	assert.ErrorIs(t, store.CreateRole(ctx, &rbac.PolicyRole{Name: `viewer`}), rbac.ErrRoleExists)
+	assert.ErrorIs(t, store.CreateRole(ctx, &rbac.PolicyRole{}), rbac.ErrInvalidPolicy)
+	assert.ErrorIs(t, store.CreateRole(ctx, &rbac.PolicyRole{Name: `x`, Combining: `any`}), rbac.ErrInvalidOptionParam)
+
+	role, err := store.GetRole(ctx, `editor`)
+	require.NoError(t, err)
+	assert.Equal(t, &rbac.PolicyRole{
+		Name:        `editor`,
+		Description: `Document editor`,
+		Combining:   `allow-overrides`,
+		Roles:       []string{`viewer`},
+		Permissions: []string{`doc.edit`, `!doc.delete`},
+		Conditions:  map[string]string{`doc.edit`: `resource.status == "draft"`},
+		Ext:         map[string]any{`level`: float64(2)},
+	}, role)
+	_, err = store.GetRole(ctx, `unknown`)
+	assert.ErrorIs(t, err, rbac.ErrUnknownRole)
+
+	// Permission assignments and inheritance
+	assert.NoError(t, store.AddRolePermissions(ctx, `viewer`, `doc.list`, `doc.view`))
+	assert.NoError(t, store.RemoveRolePermissions(ctx, `editor`, `!doc.delete`))
+	assert.ErrorIs(t, store.AddRolePermissions(ctx, `unknown`, `doc.list`), rbac.ErrUnknownRole)
+	require.NoError(t, store.CreateRole(ctx, &rbac.PolicyRole{Name: `admin`}))
+	assert.NoError(t, store.AddChildRoles(ctx, `admin`, `editor`, `viewer`))
+	assert.NoError(t, store.RemoveChildRoles(ctx, `admin`, `viewer`))
+
+	roles, err := store.ListRoles(ctx)
+	require.NoError(t, err)
+	if assert.Equal(t, 3, len(roles)) {
+		assert.Equal(t, `admin`, roles[0].Name)
+		assert.Equal(t, []string{`editor`}, roles[0].Roles)
+		assert.Equal(t, []string{`doc.edit`}, roles[1].Permissions)
+		assert.Equal(t, map[string]string{`doc.edit`: `resource.status == "draft"`}, roles[1].Conditions)
+		assert.Equal(t, []string{`doc.view`, `doc.list`}, roles[2].Permissions)
+	}
+
+	// Update replaces the definition
+	assert.NoError(t, store.UpdateRole(ctx, &rbac.PolicyRole{Name: `editor`, Permissions: []string{`doc.*`}}))
+	role, err = store.GetRole(ctx, `editor`)
+	require.NoError(t, err)
+	assert.Equal(t, &rbac.PolicyRole{Name: `editor`, Permissions: []string{`doc.*`}}, role)
+	assert.ErrorIs(t, store.UpdateRole(ctx, &rbac.PolicyRole{Name: `unknown`}), rbac.ErrUnknownRole)
+
+	// Delete removes the links
+	assert.NoError(t, store.DeleteRole(ctx, `editor`))
+	role, err = store.GetRole(ctx, `admin`)
+	require.NoError(t, err)
+	assert.Empty(t, role.Roles)
+}
+
+func TestStoreRoleInheritance(t *testing.T) {
+	ctx := context.TODO()
+	store := newTestStore(t)
+	require.NoError(t, store.CreateRole(ctx, &rbac.PolicyRole{Name: `viewer`}))
+	require.NoError(t, store.CreateRole(ctx, &rbac.PolicyRole{Name: `editor`, Roles: []string{`viewer`}}))
+	require.NoError(t, store.CreateRole(ctx, &rbac.PolicyRole{Name: `admin`, Roles: []string{`editor`}}))
+
+	// Unknown children are rejected
+	assert.ErrorIs(t, store.CreateRole(ctx, &rbac.PolicyRole{Name: `x`, Roles: []string{`unknown`}}), rbac.ErrUnknownChildRole)
+	assert.ErrorIs(t, store.AddChildRoles(ctx, `viewer`, `unknown`), rbac.ErrUnknownChildRole)
+	_, err := store.GetRole(ctx, `x`)
+	assert.ErrorIs(t, err, rbac.ErrUnknownRole)
+
+	// Cycles are rejected and the transaction is rolled back
+	var cycle *rbac.RoleCycleError
+	err = store.AddChildRoles(ctx, `viewer`, `admin`)
+	if assert.ErrorAs(t, err, &cycle) {
+		assert.Equal(t, []string{`viewer`, `admin`, `editor`, `viewer`}, cycle.Path)
+	}
+	assert.ErrorIs(t, store.AddChildRoles(ctx, `viewer`, `viewer`), rbac.ErrRoleCycle)
+	assert.ErrorIs(t, store.UpdateRole(ctx, &rbac.PolicyRole{Name: `viewer`, Roles: []string{`editor`}}), rbac.ErrRoleCycle)
+	role, err := store.GetRole(ctx, `viewer`)
+	require.NoError(t, err)
+	assert.Empty(t, role.Roles)
+
+	roles, err := rbac.NewStoreLoader(store).LoadRoles(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 3)
+}
+
+func TestStoreRoleValidity(t *testing.T) {
+	ctx := context.TODO()
+	store := newTestStore(t)
+	from, to := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	contractor := &rbac.PolicyRole{
+		Name:      `contractor`,
+		ValidFrom: &from,
+		ValidTo:   &to,
+		Schedule:  []string{`* 9-17 * * mon-fri`},
+	}
+	require.NoError(t, store.CreateRole(ctx, contractor))
+	role, err := store.GetRole(ctx, `contractor`)
+	require.NoError(t, err)
+	assert.Equal(t, contractor, role)
+
+	require.NoError(t, store.UpdateRole(ctx, &rbac.PolicyRole{Name: `contractor`}))
+	roles, err := store.ListRoles(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []rbac.PolicyRole{{Name: `contractor`}}, roles)
+
+	assert.ErrorIs(t, store.CreateRole(ctx, &rbac.PolicyRole{Name: `x`, Schedule: []string{`* *`}}), rbac.ErrInvalidSchedule)
+}
+
+func TestStoreBindings(t *testing.T) {
+	ctx := context.TODO()
+	acme := rbac.WithTenant(ctx, `acme`)
+	store := newTestStore(t)
+
+	require.NoError(t, store.BindRoles(ctx, rbac.SubjectPrincipal(1), `viewer`, `editor`, `viewer`))
+	require.NoError(t, store.BindRoles(ctx, rbac.SubjectPrincipal(1), `editor`, `admin`))
+	require.NoError(t, store.BindRoles(ctx, rbac.GroupPrincipal(`staff`), `viewer`))
+	require.NoError(t, store.BindRoles(acme, rbac.SubjectPrincipal(1), `auditor`))
+	assert.ErrorIs(t, store.BindRoles(ctx, rbac.Principal{}, `viewer`), rbac.ErrInvalidPrincipal)
+
+	roles, err := store.PrincipalRoles(ctx, rbac.SubjectPrincipal(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`viewer`, `editor`, `admin`}, roles)
+	roles, _ = store.PrincipalRoles(acme, rbac.SubjectPrincipal(1))
+	assert.Equal(t, []string{`auditor`}, roles)
+	principals, err := store.RoleBindings(ctx, `viewer`)
+	assert.NoError(t, err)
+	assert.Equal(t, []rbac.Principal{rbac.SubjectPrincipal(1), rbac.GroupPrincipal(`staff`)}, principals)
+
+	require.NoError(t, store.AddMembers(ctx, `staff`, rbac.SubjectPrincipal(2), rbac.GroupPrincipal(`support`), rbac.SubjectPrincipal(2)))
+	require.NoError(t, store.AddMembers(ctx, `support`, rbac.SubjectPrincipal(3)))
+	assert.ErrorIs(t, store.AddMembers(ctx, ``, rbac.SubjectPrincipal(3)), rbac.ErrInvalidPrincipal)
+	members, err := store.Members(ctx, `staff`)
+	assert.NoError(t, err)
+	assert.Equal(t, []rbac.Principal{rbac.SubjectPrincipal(2), rbac.GroupPrincipal(`support`)}, members)
+	groups, err := store.Groups(ctx, rbac.GroupPrincipal(`support`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`staff`}, groups)
+	members, _ = store.Members(acme, `staff`)
+	assert.Empty(t, members)
+
+	// Groups are resolved by the bindings registry
+	bindings := rbac.NewBindings(store)
+	subjects, err := bindings.RoleMembers(ctx, `viewer`)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3}, subjects)
+
+	require.NoError(t, store.UnbindRoles(ctx, rbac.SubjectPrincipal(1), `viewer`))
+	require.NoError(t, store.RemoveMembers(ctx, `staff`, rbac.GroupPrincipal(`support`)))
+	roles, _ = bindings.SubjectRoles(ctx, 3)
+	assert.Empty(t, roles)
+	roles, _ = bindings.SubjectRoles(ctx, 1)
+	assert.Equal(t, []string{`admin`, `editor`}, roles)
+
+	// Deleted role is unbound in all tenants
+	require.NoError(t, store.CreateRole(ctx, &rbac.PolicyRole{Name: `auditor`}))
+	require.NoError(t, store.DeleteRole(ctx, `auditor`))
+	roles, _ = store.PrincipalRoles(acme, rbac.SubjectPrincipal(1))
+	assert.Empty(t, roles)
+}
+
+type document struct{}
+
+func TestStoreSubjectRoles(t *testing.T) {
+	ctx := context.TODO()
+	acme := rbac.WithTenant(ctx, `acme`)
+	store := newTestStore(t)
+
+	// Nested groups with the cycle are resolved by the single query
+	require.NoError(t, store.BindRoles(ctx, rbac.SubjectPrincipal(1), `editor`))
+	require.NoError(t, store.BindRoles(ctx, rbac.GroupPrincipal(`staff`), `viewer`))
+	require.NoError(t, store.BindRoles(ctx, rbac.GroupPrincipal(`support`), `helper`, `viewer`))
+	require.NoError(t, store.BindRoles(ctx, rbac.GroupPrincipal(`other`), `admin`))
+	require.NoError(t, store.BindRoles(acme, rbac.GroupPrincipal(`staff`), `auditor`))
+	require.NoError(t, store.AddMembers(ctx, `support`, rbac.SubjectPrincipal(1)))
+	require.NoError(t, store.AddMembers(ctx, `staff`, rbac.GroupPrincipal(`support`)))
+	require.NoError(t, store.AddMembers(ctx, `support`, rbac.GroupPrincipal(`staff`)))
+	require.NoError(t, store.AddMembers(acme, `support`, rbac.SubjectPrincipal(1)))
+
+	roles, err := store.SubjectRoles(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`editor`, `helper`, `viewer`}, roles)
+	roles, err = store.SubjectRoles(acme, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, roles)
+	require.NoError(t, store.AddMembers(acme, `staff`, rbac.GroupPrincipal(`support`)))
+	roles, _ = store.SubjectRoles(acme, 1)
+	assert.Equal(t, []string{`auditor`}, roles)
+	roles, _ = rbac.NewBindings(store).SubjectRoles(ctx, 1)
+	assert.Equal(t, []string{`editor`, `helper`, `viewer`}, roles)
+}
+
+func TestManagerWithStore(t *testing.T) {
+	ctx := context.TODO()
+	store := newTestStore(t)
+	require.NoError(t, store.CreateRole(ctx, &rbac.PolicyRole{Name: `viewer`, Permissions: []string{`rbacsql.document.view`}}))
+	require.NoError(t, store.CreateRole(ctx, &rbac.PolicyRole{
+		Name:        `editor`,
+		Roles:       []string{`viewer`},
+		Permissions: []string{`rbacsql.document.*`, `!rbacsql.document.delete`},
+	}))
+
+	mng := rbac.NewManagerWithStore(store, time.Minute)
+	require.NoError(t, mng.RegisterNewPermissions((*document)(nil), []string{`view`, `edit`, `delete`}))
+
+	editor := rbac.NewSubject(1, 1, `editor`)
+	assert.True(t, mng.Check(ctx, editor, &document{}, `edit`).Allowed())
+	assert.False(t, mng.Check(ctx, editor, &document{}, `delete`).Allowed())
+	assert.True(t, mng.Check(ctx, rbac.NewSubject(2, 1, `viewer`), &document{}, `view`).Allowed())
+	assert.False(t, mng.Check(ctx, rbac.NewSubject(2, 1, `viewer`), &document{}, `edit`).Allowed())
+
+	// Changes of the store are visible without waiting for the cache lifetime
+	viewer := rbac.NewSubject(2, 1, `viewer`)
+	assert.NoError(t, store.RemoveRolePermissions(ctx, `viewer`, `rbacsql.document.view`))
+	assert.Eventually(t, func() bool { return !mng.Check(ctx, viewer, &document{}, `view`).Allowed() },
+		time.Second, time.Millisecond)
+	assert.NoError(t, store.AddRolePermissions(ctx, `viewer`, `rbacsql.document.edit`))
+	assert.Eventually(t, func() bool { return mng.Check(ctx, viewer, &document{}, `edit`).Allowed() },
+		time.Second, time.Millisecond)
+	assert.NoError(t, store.DeleteRole(ctx, `viewer`))
+	assert.Eventually(t, func() bool { return mng.Role(ctx, `viewer`) == nil }, time.Second, time.Millisecond)
+	assert.True(t, mng.Check(ctx, editor, &document{}, `edit`).Allowed())
+
+	// Role bindings are kept in the store
+	require.NoError(t, mng.Bindings().AssignGroup(ctx, `editors`, `editor`))
+	require.NoError(t, mng.Bindings().AddToGroup(ctx, `editors`, 3))
+	assert.True(t, mng.Check(ctx, rbac.NewSubject(3, 1), &document{}, `edit`).Allowed())
+	assert.Same(t, store, mng.Bindings().Store())
+	other := rbac.NewManagerWithStore(store, time.Minute)
+	require.NoError(t, other.RegisterNewPermissions((*document)(nil), []string{`view`, `edit`, `delete`}))
+	assert.True(t, other.Check(ctx, rbac.NewSubject(3, 1), &document{}, `edit`).Allowed())
+	assert.False(t, other.Check(rbac.WithTenant(ctx, `acme`), rbac.NewSubject(3, 1), &document{}, `edit`).Allowed())
+}
+
+func TestQueryPlaceholders(t *testing.T) {
+	store := New(nil, WithDollarPlaceholders(), WithTablePrefix(`acl_`))
+	assert.Equal(t, `DELETE FROM acl_roles WHERE name = $1 AND x = $2`,
+		store.query(`DELETE FROM {prefix}roles WHERE name = ? AND x = ?`))
+}
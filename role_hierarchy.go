@@ -0,0 +1,79 @@
+package rbac
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrRoleCycle if role includes itself directly or transitively
+	ErrRoleCycle = errors.New(`role cycle`)
+
+	// ErrRoleDepthExceeded if role hierarchy is deeper than MaxRoleDepth
+	ErrRoleDepthExceeded = errors.New(`role hierarchy depth exceeded`)
+)
+
+// MaxRoleDepth is the maximal depth of the role hierarchy
+//
+// Deeper child roles are rejected by validation and skipped by the checks.
+var MaxRoleDepth = 64
+
+// RoleCycleError describes the path of roles which includes the role itself
+type RoleCycleError struct {
+	Path []string
+}
+
+// Error returns the message with the path of the cycle
+func (err *RoleCycleError) Error() string {
+	return ErrRoleCycle.Error() + `: ` + strings.Join(err.Path, ` -> `)
+}
+
+// Unwrap returns ErrRoleCycle
+func (err *RoleCycleError) Unwrap() error {
+	return ErrRoleCycle
+}
+
+// ValidateRole checks the role hierarchy for cycles and depth limit
+func ValidateRole(role Role) error {
+	return validateRoleHierarchy(role, nil, map[string]bool{})
+}
+
+// validateRoleHierarchy walks the child roles, valid names contain the roles with already checked subtrees
+func validateRoleHierarchy(role Role, path []string, valid map[string]bool) error {
+	name := role.Name()
+	if i := indexOfRole(path, name); i >= 0 {
+		return &RoleCycleError{Path: append(append([]string{}, path[i:]...), name)}
+	}
+	if valid[name] {
+		return nil
+	}
+	if len(path) >= MaxRoleDepth {
+		return wrapError(ErrRoleDepthExceeded, `role `+name+` (max `+strconv.Itoa(MaxRoleDepth)+`)`)
+	}
+	path = append(path, name)
+	for _, child := range role.ChildRoles() {
+		if child == nil {
+			continue
+		}
+		if err := validateRoleHierarchy(child, path, valid); err != nil {
+			return err
+		}
+	}
+	valid[name] = true
+	return nil
+}
+
+// canEnterRole returns true if the role is not on the path and the depth limit is not reached
+func canEnterRole(path []string, name string) bool {
+	return len(path) < MaxRoleDepth && indexOfRole(path, name) < 0
+}
+
+func indexOfRole(path []string, name string) int {
+	for i, n := range path {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
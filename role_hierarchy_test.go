@@ -0,0 +1,108 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleCycleValidation(t *testing.T) {
+	ctx := context.TODO()
+
+	_, err := NewRole(`a`, WithChildRoles(MustNewRole(`b`, WithChildRoles(MustNewRole(`a`)))))
+	var cycleErr *RoleCycleError
+	if assert.True(t, errors.As(err, &cycleErr)) {
+		assert.Equal(t, []string{`a`, `b`, `a`}, cycleErr.Path)
+		assert.True(t, errors.Is(err, ErrRoleCycle))
+		assert.EqualError(t, err, `role cycle: a -> b -> a`)
+	}
+
+	// Diamond hierarchy is not a cycle
+	viewer := MustNewRole(`viewer`)
+	_, err = NewRole(`admin`, WithChildRoles(
+		MustNewRole(`editor`, WithChildRoles(viewer)),
+		MustNewRole(`auditor`, WithChildRoles(viewer)),
+	))
+	assert.NoError(t, err)
+
+	// Cycle created after construction is rejected by the manager
+	a := MustNewRole(`a`)
+	b := MustNewRole(`b`, WithChildRoles(a))
+	a.(*role).roles = []Role{b}
+	assert.True(t, errors.Is(NewManager(nil).RegisterRoleE(ctx, a), ErrRoleCycle))
+	assert.Panics(t, func() { NewManager(nil).RegisterRole(ctx, b) })
+}
+
+func TestRoleDepthLimit(t *testing.T) {
+	defer func(depth int) { MaxRoleDepth = depth }(MaxRoleDepth)
+	MaxRoleDepth = 3
+
+	leaf := MustNewRole(`r3`, WithPermissions(MustNewSimplePermission(`deep`)))
+	_, err := NewRole(`r0`, WithChildRoles(MustNewRole(`r1`, WithChildRoles(MustNewRole(`r2`, WithChildRoles(leaf))))))
+	assert.True(t, errors.Is(err, ErrRoleDepthExceeded))
+
+	role := MustNewRole(`r1`, WithChildRoles(MustNewRole(`r2`, WithChildRoles(leaf))))
+	assert.NoError(t, ValidateRole(role))
+	assert.True(t, role.CheckPermissions(context.TODO(), nil, `deep`))
+}
+
+func TestRoleCycleRuntimeGuard(t *testing.T) {
+	ctx := context.TODO()
+	a := MustNewRole(`a`, WithPermissions(MustNewSimplePermission(`perm.a`)))
+	b := MustNewRole(`b`, WithChildRoles(a), WithPermissions(MustNewSimplePermission(`perm.b`)))
+	a.(*role).roles = []Role{b}
+
+	assert.True(t, a.CheckPermissions(ctx, nil, `perm.b`))
+	assert.False(t, a.CheckPermissions(ctx, nil, `perm.c`))
+	assert.Equal(t, []string{`a`, `b`}, a.Decide(ctx, nil, `perm.b`).RolePath)
+	assert.NotNil(t, a.Permission(`perm.b`))
+	assert.Nil(t, a.Permission(`perm.c`))
+	assert.Equal(t, 2, len(a.Permissions()))
+	assert.True(t, a.HasRole(`b`))
+	assert.False(t, a.HasRole(`c`))
+	assert.Equal(t, a, a.(*role).Prepare(ctx, NewManager(nil)))
+}
+
+func TestRoleSharedHierarchy(t *testing.T) {
+	ctx := context.TODO()
+	obj := &testObject{}
+	calls := 0
+	deep := MustNewSimplePermission(`deep`, WithCheck(func(_ context.Context, _ *testObject, _ Permission) bool {
+		calls++
+		return false
+	}))
+
+	// Every role of the level inherits all roles of the next level
+	var level []Role
+	for i := 21; i >= 0; i-- {
+		next := make([]Role, 3)
+		for j := range next {
+			name := `r` + strconv.Itoa(i) + `.` + strconv.Itoa(j)
+			if level == nil {
+				next[j] = MustNewRole(name, WithPermissions(deep, MustNewSimplePermission(`leaf`)))
+			} else {
+				next[j] = MustNewRole(name, WithChildRoles(level...))
+			}
+		}
+		level = next
+	}
+	root := MustNewRole(`root`, WithChildRoles(level...))
+
+	assert.False(t, root.CheckPermissions(ctx, obj, `deep`))
+	assert.Equal(t, 3, calls, `shared roles are evaluated once`)
+	decision := root.Decide(ctx, obj, `leaf`)
+	assert.True(t, decision.Allowed())
+	assert.Len(t, decision.RolePath, 23)
+	assert.Len(t, root.Permissions(), 2)
+	assert.Nil(t, root.Permission(`unknown`))
+	assert.False(t, root.HasRole(`unknown`))
+
+	mng := NewManager(nil, WithFlattenedRoles())
+	assert.NoError(t, mng.RegisterRoleE(ctx, root))
+	calls = 0
+	assert.False(t, mng.Role(ctx, `root`).CheckPermissions(ctx, obj, `deep`))
+	assert.Equal(t, 3, calls, `shared roles are flattened once`)
+}
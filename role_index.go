@@ -0,0 +1,219 @@
+package rbac
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxIndexLookups is the number of pattern lookups memoized by the role index
+const maxIndexLookups = 1024
+
+// roleIndex is the flattened effective permissions of the role including child roles
+//
+// The index is used only if all roles of the hierarchy have the same combining algorithm,
+// so the result of the flat evaluation is equal to the evaluation of the tree.
+// Permissions are selected by names of the check patterns and evaluated in the order of the tree.
+type roleIndex struct {
+	// Generations of the flattened roles, the index is outdated if any of them is changed
+	stamps   []roleStamp
+	maxDepth int
+
+	// The hierarchy can't be flattened, the tree is evaluated
+	disabled bool
+
+	alg     CombiningAlgorithm
+	depth   int
+	roles   map[string]bool
+	entries []roleIndexEntry
+
+	// Entries which can't be selected by the name (custom permissions and roles)
+	always []int
+	names  nameTrie[[]int]
+
+	lookups     sync.Map
+	lookupCount atomic.Int32
+}
+
+// roleStamp is the generation of the role at the moment of the indexing
+type roleStamp struct {
+	role       *role
+	generation uint64
+}
+
+// roleIndexer is implemented by roles which can be flattened
+type roleIndexer interface {
+	enableIndex()
+}
+
+type roleIndexEntry struct {
+	perm Permission
+
+	// Path of roles below the indexed role to the permission
+	path []string
+}
+
+// newRoleIndex flattens the role hierarchy
+func newRoleIndex(r *role) *roleIndex {
+	idx := &roleIndex{
+		maxDepth: MaxRoleDepth,
+		alg:      r.combiningAlgorithm(),
+		roles:    map[string]bool{},
+	}
+	names := map[string][]int{}
+	if !idx.addRole(r, nil, map[*role]bool{}, names) {
+		return &roleIndex{stamps: idx.stamps, maxDepth: idx.maxDepth, disabled: true}
+	}
+	for name, entries := range names {
+		idx.names.put(name, entries)
+	}
+	return idx
+}
+
+// addRole entries of the role, roles shared by several parents are added once
+// (their later entries can't change the result of the combining)
+func (idx *roleIndex) addRole(r *role, path []string, visited map[*role]bool, names map[string][]int) bool {
+	if visited[r] || !canEnterRole(path, r.name) {
+		return true
+	}
+	visited[r] = true
+	idx.stamps = append(idx.stamps, roleStamp{role: r, generation: r.generation.Load()})
+	if r.combiningAlgorithm() != idx.alg {
+		return false
+	}
+	path = append(path, r.name)
+	idx.roles[r.name] = true
+	idx.depth = max(idx.depth, len(path))
+	for _, p := range r.permissions {
+		idx.addEntry(p, path[1:], names)
+	}
+	for _, child := range r.roles {
+		// Time-bound roles are evaluated as the whole
+		if cr, ok := child.(*role); ok && cr.window == nil {
+			if !idx.addRole(cr, path, visited, names) {
+				return false
+			}
+		} else {
+			idx.addEntry(child, path[1:], names)
+		}
+	}
+	return true
+}
+
+func (idx *roleIndex) addEntry(perm Permission, path []string, names map[string][]int) {
+	i := len(idx.entries)
+	idx.entries = append(idx.entries, roleIndexEntry{perm: perm, path: slices.Clone(path)})
+	keys := permissionIndexKeys(perm)
+	if keys == nil {
+		idx.always = append(idx.always, i)
+	}
+	for _, key := range keys {
+		names[key] = append(names[key], i)
+	}
+}
+
+// outdated returns true if any of the flattened roles is changed after the indexing
+func (idx *roleIndex) outdated() bool {
+	for _, stamp := range idx.stamps {
+		if stamp.role.generation.Load() != stamp.generation {
+			return true
+		}
+	}
+	return false
+}
+
+// applicable returns true if the index evaluates the role on the path as the tree
+// (no cycles through the path and the hierarchy is not cut by MaxRoleDepth)
+func (idx *roleIndex) applicable(path []string) bool {
+	if idx.disabled || idx.maxDepth != MaxRoleDepth || len(path)+idx.depth > MaxRoleDepth {
+		return false
+	}
+	for _, name := range path {
+		if idx.roles[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup returns the ordered indexes of entries which can match the patterns
+func (idx *roleIndex) lookup(patterns []string) []int {
+	key := patterns[0]
+	if len(patterns) > 1 {
+		key = strings.Join(patterns, "\n")
+	}
+	if list, ok := idx.lookups.Load(key); ok {
+		return list.([]int)
+	}
+	list := slices.Clone(idx.always)
+	for _, entries := range idx.names.match(patterns...) {
+		list = append(list, entries...)
+	}
+	slices.Sort(list)
+	list = slices.Compact(list)
+	if idx.lookupCount.Add(1) <= maxIndexLookups {
+		idx.lookups.Store(key, list)
+	}
+	return list
+}
+
+// permissionIndexKeys returns names which are matched by check patterns of the permission,
+// nil if the permission can't be selected by the name
+func permissionIndexKeys(perm Permission) []string {
+	switch p := perm.(type) {
+	case *SimplePermission:
+		if len(p.permissions) == 0 {
+			return []string{p.name}
+		}
+	case *ResourcePermission:
+		if len(p.permissions) == 0 {
+			keys := []string{p.Name(), p.name}
+			if p.owning != `` {
+				action := owningAction(p.name, p.owning)
+				keys = append(keys, p.resName+`.`+action, action)
+			}
+			return keys
+		}
+	case *deniedPermission:
+		return permissionIndexKeys(p.perm)
+	case *conditionalPermission:
+		return permissionIndexKeys(p.perm)
+	}
+	return nil
+}
+
+// actualIndex returns the flattened index of the indexed role, rebuilds the outdated index
+func (r *role) actualIndex() *roleIndex {
+	idx := r.index.Load()
+	if idx == nil || !idx.outdated() {
+		return idx
+	}
+	idx = newRoleIndex(r)
+	r.index.Store(idx)
+	return idx
+}
+
+// enableIndex builds the flattened index of the role if it's not built yet
+func (r *role) enableIndex() {
+	if idx := r.index.Load(); idx == nil || idx.outdated() {
+		r.index.Store(newRoleIndex(r))
+	}
+}
+
+// combineIndexed matches of the entered role by the flattened index
+func (r *role) combineIndexed(st *evalState, idx *roleIndex) (permissionMatch, bool) {
+	base := len(st.path)
+	m, ok := combineMatches(idx.alg, func(fn matchFunc) bool {
+		for _, i := range idx.lookup(st.patterns) {
+			entry := &idx.entries[i]
+			st.path = append(st.path[:base], entry.path...)
+			if !visitMatches(st, entry.perm, fn) {
+				return false
+			}
+		}
+		return true
+	})
+	st.path = st.path[:base]
+	return m, ok
+}
@@ -0,0 +1,160 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newIndexTestManager(t testing.TB, options ...Option) *Manager {
+	ctx := context.TODO()
+	mng := NewManager(nil, options...)
+	assert.NoError(t, mng.RegisterNewOwningPermissions((*testDocument)(nil), []string{`view`, `edit`, `delete`}))
+	mng.RegisterPermission(MustNewSimplePermission(`access`))
+	viewer := MustNewRole(`viewer`, WithPermissions(`rbac.testDocument.view.*`, `access`))
+	editor := MustNewRole(`editor`,
+		WithChildRoles(viewer),
+		WithPermissions(`rbac.testDocument.edit.owner`, `!rbac.testDocument.view.all`),
+	)
+	admin := MustNewRole(`admin`,
+		WithChildRoles(editor),
+		WithPermissions(
+			`rbac.testDocument.*.*`,
+			`!rbac.testDocument.delete.owner`,
+			MustNewSimplePermission(`report.export`, WithCustomCheck(func(ctx context.Context, _ any, _ Permission) bool {
+				return SubjectFromContext(ctx) != nil
+			})),
+		),
+	)
+	mng.RegisterRole(ctx, viewer, editor, admin)
+	return mng
+}
+
+func TestFlattenedRoles(t *testing.T) {
+	ctx := context.TODO()
+	subjects := []Subject{NewSubject(1, 10), NewSubject(2, 10), NewSubject(3, 11), nil}
+	patterns := [][]string{
+		{`view`}, {`edit`}, {`delete`}, {`view.*`}, {`*.owner`}, {`rbac.testDocument.**`},
+		{`access`}, {`report.export`}, {`*`}, {`delete.all`, `edit.account`}, {`unknown`},
+	}
+	for _, alg := range []CombiningAlgorithm{DenyOverrides, AllowOverrides, FirstApplicable} {
+		mng := newIndexTestManager(t, WithCombiningAlgorithm(alg), WithFlattenedRoles())
+		var roles []*role
+		for _, name := range []string{`admin`, `editor`, `viewer`} {
+			r := mng.Role(ctx, name).(*role)
+			if assert.NotNil(t, r.index.Load()) && assert.False(t, r.index.Load().disabled) {
+				roles = append(roles, r)
+			}
+		}
+		// The tree and the index are compared on the same roles because the order
+		// of preloaded permissions matters for FirstApplicable
+		decide := func(ctx context.Context, r *role, flatten bool, patterns []string) Decision {
+			var indexes []*roleIndex
+			for _, r := range roles {
+				indexes = append(indexes, r.index.Load())
+				if !flatten {
+					r.index.Store(nil)
+				}
+			}
+			defer func() {
+				for i, r := range roles {
+					r.index.Store(indexes[i])
+				}
+			}()
+			return r.Decide(ctx, &testDocument{ownerID: 1, accountID: 10}, patterns...)
+		}
+		for _, r := range roles {
+			for _, subject := range subjects {
+				sctx := ctx
+				if subject != nil {
+					sctx = WithSubject(ctx, subject)
+				}
+				for _, pattern := range patterns {
+					name := fmt.Sprintf(`%s/%s/%v/%v`, alg, r.name, subject, pattern)
+					expected := decide(sctx, r, false, pattern)
+					actual := decide(sctx, r, true, pattern)
+					assert.Equal(t, expected.Effect, actual.Effect, name)
+					assert.Equal(t, expected.PermissionName(), actual.PermissionName(), name)
+					assert.Equal(t, expected.RolePath, actual.RolePath, name)
+				}
+			}
+		}
+	}
+
+	t.Run(`rebuild`, func(t *testing.T) {
+		mng := newIndexTestManager(t, WithFlattenedRoles())
+		viewer := mng.Role(ctx, `viewer`)
+		admin := mng.Role(ctx, `admin`)
+		assert.False(t, admin.CheckPermissions(ctx, nil, `audit`))
+		viewer.(*role).AddPermissions(MustNewSimplePermission(`audit`))
+		assert.True(t, admin.CheckPermissions(ctx, nil, `audit`))
+	})
+
+	t.Run(`mixed-algorithms`, func(t *testing.T) {
+		mng := NewManager(nil, WithFlattenedRoles())
+		mng.RegisterRole(ctx, MustNewRole(`admin`,
+			WithCombiningAlgorithm(AllowOverrides),
+			WithChildRoles(MustNewRole(`viewer`, WithCombiningAlgorithm(DenyOverrides),
+				WithPermissions(MustNewSimplePermission(`view`), MustNewSimplePermission(`view`, WithEffect(Deny))))),
+			WithPermissions(MustNewSimplePermission(`view`)),
+		))
+		admin := mng.Role(ctx, `admin`)
+		assert.True(t, admin.(*role).index.Load().disabled)
+		assert.True(t, admin.CheckPermissions(ctx, nil, `view`))
+	})
+
+	t.Run(`nested-path`, func(t *testing.T) {
+		mng := newIndexTestManager(t, WithFlattenedRoles())
+		viewer := mng.Role(ctx, `viewer`).(*role)
+		idx := viewer.actualIndex()
+		assert.True(t, idx.applicable(nil))
+		assert.True(t, idx.applicable([]string{`admin`, `editor`}))
+		assert.False(t, idx.applicable([]string{`viewer`}))
+	})
+
+	t.Run(`outdated`, func(t *testing.T) {
+		mng := newIndexTestManager(t, WithFlattenedRoles())
+		admin := mng.Role(ctx, `admin`).(*role)
+		idx := admin.actualIndex()
+
+		// Changes of other roles keep the index
+		other := MustNewRole(`other`).(*role)
+		other.AddPermissions(MustNewSimplePermission(`access`))
+		assert.Same(t, idx, admin.actualIndex())
+
+		// Changes of the child role rebuild the index
+		viewer := mng.Role(ctx, `viewer`).(*role)
+		viewer.AddPermissions(MustNewSimplePermission(`extra`))
+		assert.NotSame(t, idx, admin.actualIndex())
+		assert.Same(t, viewer.actualIndex(), viewer.actualIndex())
+	})
+
+	assert.Error(t, WithFlattenedRoles()(&role{}))
+}
+
+func BenchmarkFlattenedRoles(b *testing.B) {
+	ctx := WithSubject(context.Background(), NewSubject(1, 10))
+	doc := &testDocument{ownerID: 1, accountID: 10}
+	for _, flatten := range []bool{false, true} {
+		var options []Option
+		if flatten {
+			options = append(options, WithFlattenedRoles())
+		}
+		mng := newIndexTestManager(b, options...)
+		for i := 0; i < 1000; i++ {
+			mng.RegisterPermission(MustNewSimplePermission(fmt.Sprintf(`object%d.view`, i)))
+		}
+		mng.RegisterRole(ctx, MustNewRole(`big`,
+			WithChildRoles(mng.Role(ctx, `admin`)),
+			WithPermissions(`object*.view`, `object1.**`, `object%r{^[0-9]+$}.*`),
+		))
+		role := mng.Role(ctx, `big`)
+		b.Run(fmt.Sprintf(`flatten=%t`, flatten), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = role.CheckPermissions(ctx, doc, `rbac.testDocument.view.owner`)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package rbac
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSchedule if the schedule expression can't be parsed
+var ErrInvalidSchedule = wrapError(ErrInvalidOptionParam, `invalid schedule`)
+
+// Schedule is the cron-like schedule of the time-bound roles and permissions
+//
+// The schedule has five fields separated by spaces: minute (0-59), hour (0-23),
+// day of month (1-31), month (1-12 or jan-dec) and day of week (0-6 or sun-sat, 7 is Sunday too).
+// Fields accept `*`, values, ranges `1-5`, steps `*/15`, `8-18/2` and lists `1,3,5`.
+// The optional `TZ=<location>` prefix defines the time zone of the schedule.
+// The grant is active during every minute which matches the schedule:
+//
+//	WithSchedule(`* 9-17 * * mon-fri`)              // working hours
+//	WithSchedule(`* * * * sat,sun`)                 // weekends
+//	WithSchedule(`TZ=Europe/Berlin * 0-6 * * *`)    // nights in Berlin
+//
+// If both day of month and day of week are restricted, the day matches any of them like in cron.
+type Schedule struct {
+	source   string
+	location *time.Location
+	fields   [5]uint64
+
+	// Day fields are not restricted
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+type scheduleField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var scheduleFields = [5]scheduleField{
+	{name: `minute`, min: 0, max: 59},
+	{name: `hour`, min: 0, max: 23},
+	{name: `day of month`, min: 1, max: 31},
+	{name: `month`, min: 1, max: 12,
+		names: []string{`jan`, `feb`, `mar`, `apr`, `may`, `jun`, `jul`, `aug`, `sep`, `oct`, `nov`, `dec`}},
+	{name: `day of week`, min: 0, max: 7,
+		names: []string{`sun`, `mon`, `tue`, `wed`, `thu`, `fri`, `sat`}},
+}
+
+// ParseSchedule parses the cron-like schedule expression
+func ParseSchedule(spec string) (*Schedule, error) {
+	s := &Schedule{source: spec}
+	expr := strings.TrimSpace(spec)
+	if strings.HasPrefix(expr, `TZ=`) {
+		tz, rest, _ := strings.Cut(expr[3:], ` `)
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, scheduleError(spec, err.Error())
+		}
+		s.location, expr = loc, rest
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != len(scheduleFields) {
+		return nil, scheduleError(spec, `expected 5 fields`)
+	}
+	for i, part := range parts {
+		bits, err := scheduleFields[i].parse(part)
+		if err != nil {
+			return nil, scheduleError(spec, scheduleFields[i].name+` `+err.Error())
+		}
+		s.fields[i] = bits
+	}
+	// Sunday is 0 and 7
+	if s.fields[4]&(1<<7) != 0 {
+		s.fields[4] |= 1
+	}
+	s.anyDayOfMonth, s.anyDayOfWeek = parts[2] == `*`, parts[4] == `*`
+	return s, nil
+}
+
+// MustParseSchedule or produce panic
+func MustParseSchedule(spec string) *Schedule {
+	s, err := ParseSchedule(spec)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// String returns the source of the schedule
+func (s *Schedule) String() string {
+	return s.source
+}
+
+// Match returns true if the minute of the time matches the schedule
+func (s *Schedule) Match(t time.Time) bool {
+	if s.location != nil {
+		t = t.In(s.location)
+	}
+	if !s.has(0, t.Minute()) || !s.has(1, t.Hour()) || !s.has(3, int(t.Month())) {
+		return false
+	}
+	dom, dow := s.has(2, t.Day()), s.has(4, int(t.Weekday()))
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (s *Schedule) has(field, value int) bool {
+	return s.fields[field]&(1<<uint(value)) != 0
+}
+
+func (f *scheduleField) parse(expr string) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(expr, `,`) {
+		rng, stepStr, hasStep := strings.Cut(item, `/`)
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, errors.New(`invalid step ` + stepStr)
+			}
+		}
+		from, to := f.min, f.max
+		if rng != `*` {
+			fromStr, toStr, isRange := strings.Cut(rng, `-`)
+			var err error
+			if from, err = f.value(fromStr); err != nil {
+				return 0, err
+			}
+			to = from
+			if isRange {
+				if to, err = f.value(toStr); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				to = f.max
+			}
+			if to < from {
+				return 0, errors.New(`invalid range ` + rng)
+			}
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f *scheduleField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return i + f.min, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, errors.New(`invalid value ` + strconv.Quote(s))
+	}
+	return v, nil
+}
+
+func scheduleError(spec, msg string) error {
+	return wrapError(ErrInvalidSchedule, msg+` in `+strconv.Quote(spec))
+}
+
+// timeWindow restricts the role or the permission by the validity period and the schedules
+type timeWindow struct {
+	// Validity period [from, to), zero time is not limited
+	from, to time.Time
+
+	// The grant is active if any schedule matches
+	schedules []*Schedule
+}
+
+// active returns true if the grant is active at the time, nil window is always active
+func (w *timeWindow) active(now time.Time) bool {
+	if w == nil {
+		return true
+	}
+	if !w.from.IsZero() && now.Before(w.from) || w.expired(now) {
+		return false
+	}
+	if len(w.schedules) == 0 {
+		return true
+	}
+	for _, s := range w.schedules {
+		if s.Match(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// expired returns true if the validity period is over
+func (w *timeWindow) expired(now time.Time) bool {
+	return w != nil && !w.to.IsZero() && !now.Before(w.to)
+}
+
+// timeWindowOf the role or the permission
+func timeWindowOf(p Permission) *timeWindow {
+	switch v := p.(type) {
+	case *role:
+		return v.window
+	case *SimplePermission:
+		return v.window
+	case *ResourcePermission:
+		return v.window
+	case *deniedPermission:
+		return timeWindowOf(v.perm)
+	case *conditionalPermission:
+		return timeWindowOf(v.perm)
+	}
+	return nil
+}
+
+// grantExpired returns true if the validity of the role or the permission is over
+func grantExpired(p Permission, now time.Time) bool {
+	return timeWindowOf(p).expired(now)
+}
@@ -0,0 +1,184 @@
+package rbac
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedule(t *testing.T) {
+	// 2024-05-01 is Wednesday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 5, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		spec  string
+		match []time.Time
+		miss  []time.Time
+	}{
+		{spec: `* * * * *`, match: []time.Time{at(1, 0, 0), at(31, 23, 59)}},
+		{spec: `* 9-17 * * mon-fri`, match: []time.Time{at(1, 9, 0), at(3, 17, 59)}, miss: []time.Time{at(1, 18, 0), at(4, 12, 0)}},
+		{spec: `*/15 * * * *`, match: []time.Time{at(1, 1, 0), at(1, 1, 45)}, miss: []time.Time{at(1, 1, 10)}},
+		{spec: `0 8-18/2 * * *`, match: []time.Time{at(1, 8, 0), at(1, 18, 0)}, miss: []time.Time{at(1, 9, 0), at(1, 8, 1)}},
+		{spec: `* * * * sat,7`, match: []time.Time{at(4, 1, 0), at(5, 1, 0)}, miss: []time.Time{at(6, 1, 0)}},
+		{spec: `* * 1,15 may *`, match: []time.Time{at(1, 1, 0), at(15, 1, 0)}, miss: []time.Time{at(2, 1, 0)}},
+		// Day of month or day of week if both are restricted
+		{spec: `* * 2 * mon`, match: []time.Time{at(2, 1, 0), at(6, 1, 0)}, miss: []time.Time{at(3, 1, 0)}},
+		{spec: `TZ=UTC * 12 * * *`, match: []time.Time{at(1, 12, 30)}, miss: []time.Time{at(1, 13, 0)}},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			s, err := ParseSchedule(test.spec)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, test.spec, s.String())
+			for _, tm := range test.match {
+				assert.True(t, s.Match(tm), tm)
+			}
+			for _, tm := range test.miss {
+				assert.False(t, s.Match(tm), tm)
+			}
+		})
+	}
+
+	for _, spec := range []string{``, `* * * *`, `60 * * * *`, `* 5-1 * * *`, `*/0 * * * *`, `* * * foo *`, `TZ=Nowhere/City * * * * *`} {
+		_, err := ParseSchedule(spec)
+		assert.ErrorIs(t, err, ErrInvalidSchedule, spec)
+		assert.ErrorIs(t, err, ErrInvalidOptionParam, spec)
+	}
+	assert.Panics(t, func() { MustParseSchedule(`*`) })
+}
+
+func TestTimeBoundGrants(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ctx := WithClock(context.TODO(), func() time.Time { return now })
+	later := WithClock(ctx, func() time.Time { return now.Add(48 * time.Hour) })
+	night := WithClock(ctx, func() time.Time { return now.Add(12 * time.Hour) })
+
+	oncall := MustNewRole(`oncall`,
+		WithPermissions(MustNewSimplePermission(`incident.resolve`)),
+		WithValidity(now.Add(-time.Hour), now.Add(time.Hour)))
+	engineer := MustNewRole(`engineer`,
+		WithChildRoles(oncall),
+		WithPermissions(
+			MustNewSimplePermission(`deploy`, WithSchedule(`* 9-17 * * mon-fri`)),
+			MustNewSimplePermission(`debug`, WithValidity(time.Time{}, now.Add(24*time.Hour))),
+			MustNewSimplePermission(`legacy`, WithValidity(time.Time{}, now.Add(-time.Hour))),
+			MustNewResourcePermission(`view`, &testObject{}, WithValidity(now, time.Time{})),
+		))
+
+	assert.True(t, engineer.CheckPermissions(ctx, nil, `incident.resolve`))
+	assert.False(t, engineer.CheckPermissions(later, nil, `incident.resolve`))
+	assert.True(t, engineer.CheckPermissions(ctx, nil, `deploy`))
+	assert.False(t, engineer.CheckPermissions(night, nil, `deploy`))
+	assert.True(t, engineer.CheckPermissions(night, nil, `debug`))
+	assert.False(t, engineer.CheckPermissions(later, nil, `debug`))
+	assert.False(t, engineer.CheckPermissions(ctx, nil, `legacy`))
+	assert.True(t, engineer.CheckPermissions(ctx, &testObject{}, `view`))
+	assert.False(t, engineer.CheckPermissions(WithClock(ctx, func() time.Time { return now.Add(-time.Second) }), &testObject{}, `view`))
+
+	// Expired grants are not listed
+	listed := MustNewRole(`listed`,
+		WithChildRoles(MustNewRole(`expired`, WithPermissions(MustNewSimplePermission(`old`)),
+			WithValidity(time.Time{}, time.Now().Add(-time.Minute)))),
+		WithPermissions(
+			MustNewSimplePermission(`current`, WithValidity(time.Time{}, time.Now().Add(time.Hour))),
+			MustNewSimplePermission(`future`, WithValidity(time.Now().Add(time.Hour), time.Time{})),
+			MustNewSimplePermission(`legacy`, WithValidity(time.Time{}, time.Now().Add(-time.Hour))),
+		))
+	var names []string
+	for _, p := range listed.Permissions() {
+		names = append(names, p.Name())
+	}
+	assert.Equal(t, []string{`current`, `future`}, names)
+	assert.False(t, listed.HasPermission(`legacy`))
+	assert.False(t, listed.HasPermission(`old`))
+
+	// Expired permissions are not listed by the manager
+	mng := NewManager(nil)
+	mng.RegisterPermission(listed.Permissions()...)
+	mng.RegisterPermission(MustNewSimplePermission(`legacy`, WithValidity(time.Time{}, time.Now().Add(-time.Hour))))
+	assert.Len(t, mng.Permissions(), 2)
+	assert.Empty(t, mng.Permissions(`legacy`))
+	assert.NotNil(t, mng.Permission(`legacy`))
+
+	// Permissions are listed by the clock of the context
+	past := WithClock(context.TODO(), func() time.Time { return time.Now().Add(-2 * time.Hour) })
+	assert.Len(t, mng.PermissionsContext(past, `legacy`), 1)
+	assert.Len(t, mng.PermissionsContext(context.TODO()), 2)
+	names = names[:0]
+	for _, p := range mng.ExportPolicy(past).Permissions {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{`current`, `future`, `legacy`}, names)
+
+	t.Run(`flattened`, func(t *testing.T) {
+		engineer.(roleIndexer).enableIndex()
+		defer engineer.(*role).index.Store(nil)
+		assert.True(t, engineer.CheckPermissions(ctx, nil, `incident.resolve`))
+		assert.False(t, engineer.CheckPermissions(later, nil, `incident.resolve`))
+		assert.False(t, engineer.CheckPermissions(night, nil, `deploy`))
+	})
+
+	_, err := NewRole(`test`, WithValidity(now, now))
+	assert.ErrorIs(t, err, ErrInvalidOptionParam)
+	_, err = NewRole(`test`, WithSchedule(`* *`))
+	assert.ErrorIs(t, err, ErrInvalidSchedule)
+	assert.ErrorIs(t, WithValidity(now, time.Time{})(&testObject{}), ErrInvalidOption)
+}
+
+func TestPolicyValidity(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ctx := WithClock(context.TODO(), func() time.Time { return now })
+	const policy = `
+roles:
+  - name: viewer
+    permissions: [rbac.testObject.view.*]
+  - name: contractor
+    roles: [viewer]
+    valid_from: 2024-04-01T00:00:00Z
+    valid_to: 2024-06-01T00:00:00Z
+    schedule: ["* 9-17 * * mon-fri"]
+  - name: former
+    roles: [viewer]
+    valid_to: 2024-01-01T00:00:00Z
+`
+	mng := newTestPolicyManager(t)
+	assert.NoError(t, mng.LoadPolicy(ctx, strings.NewReader(policy)))
+	obj := &testObject{}
+	assert.True(t, mng.Role(ctx, `contractor`).CheckPermissions(ctx, obj, `view.all`))
+	assert.False(t, mng.Role(ctx, `former`).CheckPermissions(ctx, obj, `view.all`))
+
+	exported := mng.ExportPolicy(ctx)
+	if assert.Len(t, exported.Roles, 2) {
+		contractor := exported.Roles[0]
+		assert.Equal(t, `contractor`, contractor.Name)
+		assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), *contractor.ValidTo)
+		assert.Equal(t, []string{`* 9-17 * * mon-fri`}, contractor.Schedule)
+		assert.Equal(t, `viewer`, exported.Roles[1].Name)
+
+		// Exported times are copies of the role validity
+		*contractor.ValidTo = time.Time{}
+		assert.False(t, mng.Role(ctx, `contractor`).CheckPermissions(WithClock(ctx, func() time.Time { return now.AddDate(1, 0, 0) }), obj, `view.all`))
+	}
+
+	// Expired permissions are skipped by the clock of the context
+	mng.RegisterPermission(
+		MustNewSimplePermission(`report.old`, WithValidity(time.Time{}, now.Add(-time.Hour))),
+		MustNewSimplePermission(`report.new`, WithValidity(time.Time{}, now.Add(time.Hour))),
+	)
+	var exportedNames []string
+	for _, perm := range mng.ExportPolicy(ctx).Permissions {
+		exportedNames = append(exportedNames, perm.Name)
+	}
+	assert.Contains(t, exportedNames, `report.new`)
+	assert.NotContains(t, exportedNames, `report.old`)
+	assert.NoError(t, mng.LoadPolicy(ctx, strings.NewReader(`{"roles": [{"name": "old", "permissions": ["report.old"]}]}`)))
+
+	err := newTestPolicyManager(t).LoadPolicy(ctx, strings.NewReader(`{"roles": [{"name": "a", "schedule": ["* *"]}]}`))
+	assert.ErrorIs(t, err, ErrInvalidSchedule)
+}
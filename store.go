@@ -0,0 +1,185 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRoleExists if role with the same name is already stored
+	ErrRoleExists = errors.New(`role already exists`)
+
+	// ErrUnknownChildRole if the stored role inherits the role which is not stored
+	ErrUnknownChildRole = errors.New(`unknown child role`)
+
+	// ErrPartialRoleLoading if some roles of the loader are skipped, the loaded roles are used
+	ErrPartialRoleLoading = errors.New(`some roles are not loaded`)
+)
+
+// RoleLoadingErrors of the roles skipped by the loader, matches ErrPartialRoleLoading
+type RoleLoadingErrors []error
+
+// Error returns the messages of the skipped roles
+func (errs RoleLoadingErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return ErrPartialRoleLoading.Error() + `: ` + strings.Join(msgs, `; `)
+}
+
+// Unwrap returns ErrPartialRoleLoading and the errors of the roles
+func (errs RoleLoadingErrors) Unwrap() []error {
+	return append([]error{ErrPartialRoleLoading}, errs...)
+}
+
+// Store of the roles, permission assignments and role inheritance
+//
+// Roles are stored as the policy role definitions, the permissions are the patterns
+// with optional `!` prefix for deny permissions. Stores which implement ChangeNotifier
+// invalidate the roles cached by the manager on changes.
+//
+// Stored roles are global, the tenant of the context is not used. Roles of tenants
+// are registered in the manager by RegisterTenantRole and OverrideRole.
+type Store interface {
+	// ListRoles returns all stored role definitions
+	ListRoles(ctx context.Context) ([]PolicyRole, error)
+
+	// GetRole returns role definition by name or ErrUnknownRole
+	GetRole(ctx context.Context, name string) (*PolicyRole, error)
+
+	// CreateRole with permissions and child roles, returns ErrRoleExists if the role is already stored
+	CreateRole(ctx context.Context, role *PolicyRole) error
+
+	// UpdateRole replaces the role definition, returns ErrUnknownRole if the role is not stored
+	UpdateRole(ctx context.Context, role *PolicyRole) error
+
+	// DeleteRole with its permissions and inheritance links
+	DeleteRole(ctx context.Context, name string) error
+
+	// AddRolePermissions assigns permission patterns to the role
+	AddRolePermissions(ctx context.Context, role string, patterns ...string) error
+
+	// RemoveRolePermissions unassigns permission patterns from the role
+	RemoveRolePermissions(ctx context.Context, role string, patterns ...string) error
+
+	// AddChildRoles to the role
+	AddChildRoles(ctx context.Context, role string, children ...string) error
+
+	// RemoveChildRoles from the role
+	RemoveChildRoles(ctx context.Context, role string, children ...string) error
+}
+
+// StoreLoader loads roles from the store and implements RoleLoader interface
+type StoreLoader struct {
+	store Store
+}
+
+// NewStoreLoader returns role loader of the store
+func NewStoreLoader(store Store) *StoreLoader {
+	return &StoreLoader{store: store}
+}
+
+// LoadRoles builds the roles of the store definitions
+//
+// Invalid roles (cycles, unknown children, bad patterns) and the roles which inherit them
+// are skipped, the other roles are returned with RoleLoadingErrors.
+func (l *StoreLoader) LoadRoles(ctx context.Context) ([]Role, error) {
+	defs, err := l.store.ListRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{Roles: defs}
+	builder, err := policy.builder(nil)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		roles = make([]Role, 0, len(defs))
+		errs  RoleLoadingErrors
+	)
+	for _, def := range defs {
+		role, err := builder.build(def.Name)
+		if err != nil {
+			errs = append(errs, wrapError(err, `role `+def.Name))
+			continue
+		}
+		roles = append(roles, role)
+	}
+	if len(errs) > 0 {
+		return roles, errs
+	}
+	return roles, nil
+}
+
+// ListRolesE implements RoleLoaderE interface
+func (l *StoreLoader) ListRolesE(ctx context.Context) ([]Role, error) {
+	return l.LoadRoles(ctx)
+}
+
+// ListRoles implements RoleLoader interface, returns nil if the store is failed
+func (l *StoreLoader) ListRoles(ctx context.Context) []Role {
+	roles, _ := l.LoadRoles(ctx)
+	return roles
+}
+
+// LoadRole builds the role with child roles of the store definitions, returns nil if the role is not stored
+func (l *StoreLoader) LoadRole(ctx context.Context, name string) Role {
+	role, _ := l.loadRole(ctx, name)
+	return role
+}
+
+// LoadRoleE implements IncrementalRoleLoaderE interface, returns ErrUnknownRole if the role is not stored
+// and ErrUnknownChildRole if the role inherits the role which is not stored
+func (l *StoreLoader) LoadRoleE(ctx context.Context, name string) (Role, error) {
+	return l.loadRole(ctx, name)
+}
+
+func (l *StoreLoader) loadRole(ctx context.Context, name string) (Role, error) {
+	var (
+		policy Policy
+		queue  = []string{name}
+		loaded = map[string]bool{}
+	)
+	for len(queue) > 0 {
+		roleName := queue[0]
+		queue = queue[1:]
+		if loaded[roleName] {
+			continue
+		}
+		loaded[roleName] = true
+		def, err := l.store.GetRole(ctx, roleName)
+		if err != nil {
+			// The missing child is not the missing role, the role is kept by the cache
+			if roleName != name && errors.Is(err, ErrUnknownRole) {
+				return nil, wrapError(ErrUnknownChildRole, `role `+name+` child `+roleName)
+			}
+			return nil, err
+		}
+		policy.Roles = append(policy.Roles, *def)
+		queue = append(queue, def.Roles...)
+	}
+	roles, err := policy.BuildRoles(nil)
+	if err != nil {
+		return nil, err
+	}
+	return roles[0], nil
+}
+
+// SubscribeRoleChanges of the store if it implements ChangeNotifier interface
+func (l *StoreLoader) SubscribeRoleChanges(fn func(names ...string)) {
+	if notifier, ok := l.store.(ChangeNotifier); ok {
+		notifier.SubscribeRoleChanges(fn)
+	}
+}
+
+// NewManagerWithStore creates new manager which reads roles from the store through the cache,
+// role bindings are kept in the store if it implements BindingStore (see WithBindings)
+func NewManagerWithStore(store Store, lifetimeCache time.Duration, options ...Option) *Manager {
+	if bindings, ok := store.(BindingStore); ok {
+		options = append([]Option{WithBindings(bindings)}, options...)
+	}
+	return NewManagerWithLoader(NewStoreLoader(store), lifetimeCache, options...)
+}
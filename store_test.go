@@ -0,0 +1,112 @@
+package rbac
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testStore struct {
+	Store
+	mx    sync.Mutex
+	roles map[string]*PolicyRole
+}
+
+func (s *testStore) delete(name string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	delete(s.roles, name)
+}
+
+func (s *testStore) ListRoles(context.Context) ([]PolicyRole, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	roles := make([]PolicyRole, 0, len(s.roles))
+	for _, role := range s.roles {
+		roles = append(roles, *role)
+	}
+	return roles, nil
+}
+
+func (s *testStore) GetRole(_ context.Context, name string) (*PolicyRole, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if role := s.roles[name]; role != nil {
+		return role, nil
+	}
+	return nil, wrapError(ErrUnknownRole, name)
+}
+
+func TestStoreLoaderMissingChild(t *testing.T) {
+	ctx := context.TODO()
+	store := &testStore{roles: map[string]*PolicyRole{
+		`viewer`: {Name: `viewer`},
+		`editor`: {Name: `editor`, Roles: []string{`viewer`}},
+	}}
+	loader := NewStoreLoader(store)
+	mng := NewManagerWithStore(store, time.Hour)
+	crl := mng.roleAccessors.(*cachedRoleLoader)
+	assert.NotNil(t, mng.Role(ctx, `editor`))
+
+	// The missing child doesn't remove the role from the cache
+	store.delete(`viewer`)
+	_, err := loader.LoadRoleE(ctx, `editor`)
+	assert.ErrorIs(t, err, ErrUnknownChildRole)
+	assert.NotErrorIs(t, err, ErrUnknownRole)
+	mng.Invalidate(`editor`)
+	assert.Eventually(t, func() bool {
+		return mng.Role(ctx, `editor`) != nil && crl.retryAt.Load() != 0 && !crl.refreshing.Load()
+	}, time.Second, time.Millisecond)
+	assert.NotNil(t, mng.Role(ctx, `editor`))
+
+	// The missing role is removed
+	_, err = loader.LoadRoleE(ctx, `viewer`)
+	assert.ErrorIs(t, err, ErrUnknownRole)
+	store.delete(`editor`)
+	crl.retryAt.Store(0)
+	assert.Eventually(t, func() bool { return mng.Role(ctx, `editor`) == nil }, time.Second, time.Millisecond)
+}
+
+func TestStoreLoaderSkipsInvalidRoles(t *testing.T) {
+	ctx := context.TODO()
+	store := &testStore{roles: map[string]*PolicyRole{
+		`viewer`: {Name: `viewer`},
+		`a`:      {Name: `a`, Roles: []string{`b`}},
+		`b`:      {Name: `b`, Roles: []string{`a`}},
+		`admin`:  {Name: `admin`, Roles: []string{`a`, `viewer`}},
+		`orphan`: {Name: `orphan`, Roles: []string{`unknown`}},
+	}}
+
+	// Roles of the cycle, the roles which inherit them and the roles with unknown children are skipped
+	roles, err := NewStoreLoader(store).LoadRoles(ctx)
+	assert.ErrorIs(t, err, ErrPartialRoleLoading)
+	assert.ErrorIs(t, err, ErrRoleCycle)
+	assert.ErrorIs(t, err, ErrUnknownRole)
+	if assert.Len(t, roles, 1) {
+		assert.Equal(t, `viewer`, roles[0].Name())
+	}
+
+	// The manager uses the loaded roles and reports the skipped ones
+	var (
+		mx       sync.Mutex
+		reported []error
+	)
+	mng := NewManagerWithStore(store, time.Hour, WithLoadErrorHook(func(_ context.Context, err error) {
+		mx.Lock()
+		defer mx.Unlock()
+		reported = append(reported, err)
+	}))
+	roles, err = mng.RolesE(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 1)
+	assert.NotNil(t, mng.Role(ctx, `viewer`))
+	assert.Nil(t, mng.Role(ctx, `admin`))
+	mx.Lock()
+	defer mx.Unlock()
+	if assert.Len(t, reported, 1) {
+		assert.ErrorIs(t, reported[0], ErrPartialRoleLoading)
+	}
+}
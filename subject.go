@@ -0,0 +1,130 @@
+package rbac
+
+import (
+	"context"
+	"slices"
+)
+
+// Subject is the actor (user, service, API client) which accesses the resources
+type Subject interface {
+	// RBACSubjectID returns unique identifier of the subject
+	RBACSubjectID() uint64
+
+	// RBACAccountID returns identifier of the account the subject belongs to
+	RBACAccountID() uint64
+
+	// RBACRoles returns names of the roles assigned to the subject
+	RBACRoles() []string
+
+	// RBACAttributes returns additional attributes of the subject
+	RBACAttributes() map[string]any
+}
+
+// SimpleSubject implements Subject interface with predefined values
+type SimpleSubject struct {
+	ID         uint64
+	AccountID  uint64
+	Tenant     string
+	Roles      []string
+	Attributes map[string]any
+}
+
+// NewSubject returns subject with identifiers and role names
+func NewSubject(id, accountID uint64, roles ...string) *SimpleSubject {
+	return &SimpleSubject{ID: id, AccountID: accountID, Roles: roles}
+}
+
+// RBACSubjectID returns unique identifier of the subject
+func (s *SimpleSubject) RBACSubjectID() uint64 { return s.ID }
+
+// RBACAccountID returns identifier of the account the subject belongs to
+func (s *SimpleSubject) RBACAccountID() uint64 { return s.AccountID }
+
+// RBACRoles returns names of the roles assigned to the subject
+func (s *SimpleSubject) RBACRoles() []string { return s.Roles }
+
+// RBACAttributes returns additional attributes of the subject
+func (s *SimpleSubject) RBACAttributes() map[string]any { return s.Attributes }
+
+// RBACTenant returns the tenant of the subject
+func (s *SimpleSubject) RBACTenant() string { return s.Tenant }
+
+// SubjectRoles returns roles of the subject resolved by the manager,
+// unknown role names are skipped
+func (mng *Manager) SubjectRoles(ctx context.Context, subject Subject) []Role {
+	roles, _ := mng.SubjectRolesE(ctx, subject)
+	return roles
+}
+
+// SubjectRolesE returns roles of the subject resolved by the manager
+// and error if some role can't be resolved because of the role accessors or the bindings failure
+//
+// Roles of the subject are combined with the roles bound to the subject and its groups (see Manager.Bindings).
+func (mng *Manager) SubjectRolesE(ctx context.Context, subject Subject) ([]Role, error) {
+	if subject == nil {
+		return nil, nil
+	}
+	names := subject.RBACRoles()
+	if mng.bindings != nil && subject.RBACSubjectID() != 0 {
+		bound, err := mng.bindings.SubjectRoles(ctx, subject.RBACSubjectID())
+		if err != nil {
+			return nil, err
+		}
+		names = appendUnique(slices.Clone(names), bound...)
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	return mng.RolesE(ctx, names...)
+}
+
+// Check access of the subject to the resource
+//
+// Roles of the subject are resolved through the role accessors and registered roles
+// of the manager and combined by the subject combining algorithm (see WithSubjectCombiningAlgorithm).
+// Subject without known roles gets NotApplicable decision which doesn't grant access.
+// The subject is available in the context of the check callbacks by SubjectFromContext,
+// nil subject is taken from the context. Roles are resolved in the scope of the tenant of the context
+// or the tenant of the subject (see TenantSubject).
+// Failure of the role loading denies access with the error of the decision.
+func (mng *Manager) Check(ctx context.Context, subject Subject, resource any, patterns ...string) Decision {
+	_, _, decision := mng.check(ctx, subject, resource, patterns)
+	return decision
+}
+
+// Authorize checks access of the subject like Check and returns the context of the check
+// with the subject, its tenant and the resolved roles (see RolesFromContext),
+// so the handlers of the request see the same roles which have been used by the decision
+func (mng *Manager) Authorize(ctx context.Context, subject Subject, resource any, patterns ...string) (context.Context, Decision) {
+	ctx, roles, decision := mng.check(ctx, subject, resource, patterns)
+	return WithRoles(ctx, roles...), decision
+}
+
+func (mng *Manager) check(ctx context.Context, subject Subject, resource any, patterns []string) (context.Context, []Role, Decision) {
+	if subject == nil {
+		subject = SubjectFromContext(ctx)
+	} else {
+		ctx = WithSubject(ctx, subject)
+	}
+	if ts, ok := subject.(TenantSubject); ok && ts.RBACTenant() != `` && TenantFromContext(ctx) == `` {
+		ctx = WithTenant(ctx, ts.RBACTenant())
+	}
+	roles, err := mng.SubjectRolesE(ctx, subject)
+	if err != nil {
+		return ctx, roles, loadErrorDecision(resource, patterns, err)
+	}
+	perms := make([]Permission, 0, len(roles))
+	for _, role := range roles {
+		perms = append(perms, role)
+	}
+	return ctx, roles, Decide(ctx, mng.SubjectCombiningAlgorithm(), perms, resource, patterns...)
+}
+
+// SubjectCombiningAlgorithm returns algorithm of combining decisions of the subject roles,
+// the manager combining algorithm by default
+func (mng *Manager) SubjectCombiningAlgorithm() CombiningAlgorithm {
+	if mng.subjectCombining.valid() {
+		return mng.subjectCombining
+	}
+	return mng.CombiningAlgorithm()
+}
@@ -0,0 +1,61 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagerCheck(t *testing.T) {
+	ctx := context.TODO()
+	obj := &testObject{name: `test`}
+	newManager := func(options ...Option) *Manager {
+		guest := MustNewRole(`guest`, WithPermissions(MustNewResourcePermission(`delete`, (*testObject)(nil), WithEffect(Deny))))
+		mng := NewManagerWithLoader(roleLoaderFunc(func(context.Context) []Role { return []Role{guest} }), time.Minute, options...)
+		assert.NoError(t, mng.RegisterNewPermissions((*testObject)(nil), []string{`view`, `delete`}))
+		mng.RegisterRole(ctx,
+			MustNewRole(`viewer`, WithPermissions(`rbac.testObject.view`)),
+			MustNewRole(`editor`, WithPermissions(`rbac.testObject.*`)),
+		)
+		return mng
+	}
+
+	mng := newManager()
+	assert.Equal(t, DenyOverrides, mng.SubjectCombiningAlgorithm())
+
+	decision := mng.Check(ctx, NewSubject(1, 1, `viewer`), obj, `view`)
+	assert.True(t, decision.Allowed())
+	assert.Equal(t, []string{`viewer`}, decision.RolePath)
+
+	assert.False(t, mng.Check(ctx, NewSubject(1, 1, `viewer`), obj, `delete`).Allowed())
+	assert.True(t, mng.Check(ctx, NewSubject(1, 1, `viewer`, `editor`), obj, `delete`).Allowed())
+
+	decision = mng.Check(ctx, NewSubject(1, 1, `editor`, `guest`), obj, `delete`)
+	assert.Equal(t, Deny, decision.Effect)
+	assert.Equal(t, []string{`guest`}, decision.RolePath)
+
+	assert.Equal(t, NotApplicable, mng.Check(ctx, NewSubject(1, 1, `unknown`), obj, `view`).Effect)
+	assert.Equal(t, NotApplicable, mng.Check(ctx, nil, obj, `view`).Effect)
+
+	t.Run(`allow-overrides`, func(t *testing.T) {
+		mng := newManager(WithSubjectCombiningAlgorithm(AllowOverrides))
+		assert.Equal(t, AllowOverrides, mng.SubjectCombiningAlgorithm())
+		assert.Equal(t, DenyOverrides, mng.CombiningAlgorithm())
+		assert.True(t, mng.Check(ctx, NewSubject(1, 1, `editor`, `guest`), obj, `delete`).Allowed())
+		assert.Equal(t, 2, len(mng.SubjectRoles(ctx, NewSubject(1, 1, `editor`, `guest`))))
+	})
+
+	authCtx, decision := mng.Authorize(ctx, NewSubject(1, 1, `viewer`), obj, `view`)
+	assert.True(t, decision.Allowed())
+	assert.Equal(t, []Role{mng.Role(ctx, `viewer`)}, RolesFromContext(authCtx))
+	assert.NotNil(t, SubjectFromContext(authCtx))
+
+	assert.Error(t, WithSubjectCombiningAlgorithm(0)(mng))
+	assert.Error(t, WithSubjectCombiningAlgorithm(AllowOverrides)(&role{}))
+}
+
+type roleLoaderFunc func(ctx context.Context) []Role
+
+func (f roleLoaderFunc) ListRoles(ctx context.Context) []Role { return f(ctx) }
@@ -0,0 +1,310 @@
+package rbac
+
+import (
+	"context"
+	"maps"
+	"slices"
+)
+
+type tenantCtxKey struct{}
+
+// TenantSubject is the subject which belongs to the tenant (customer account, domain),
+// the tenant of the subject is used by Manager.Check if it's not defined in the context
+type TenantSubject interface {
+	Subject
+
+	// RBACTenant returns the tenant of the subject
+	RBACTenant() string
+}
+
+// WithTenant puts the tenant of the request in the context,
+// roles are resolved by the manager in the scope of the tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant of the context or empty string (global scope)
+func TenantFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ``
+	}
+	tenant, _ := ctx.Value(tenantCtxKey{}).(string)
+	return tenant
+}
+
+// roleKey identifies the role registered in the manager, global roles have empty tenant
+type roleKey struct {
+	tenant string
+	name   string
+}
+
+// tenantView is the role of the global hierarchy rebuilt with the roles of the tenant
+type tenantView struct {
+	source Role
+	view   Role
+}
+
+// RegisterTenantRole in the scope of the tenant, panics if the role hierarchy is invalid
+func (mng *Manager) RegisterTenantRole(ctx context.Context, tenant string, roles ...Role) *Manager {
+	if err := mng.RegisterTenantRoleE(ctx, tenant, roles...); err != nil {
+		panic(err)
+	}
+	return mng
+}
+
+// RegisterTenantRoleE in the scope of the tenant
+//
+// Roles of the tenant override global roles with the same name in the context of the tenant
+// (see WithTenant) including child roles of the global hierarchy.
+func (mng *Manager) RegisterTenantRoleE(ctx context.Context, tenant string, roles ...Role) error {
+	return mng.registerRoles(ctx, tenant, roles)
+}
+
+// OverrideRole of the global role template in the scope of the tenant
+//
+// The tenant role inherits permissions, child roles and properties of the template
+// and extends them by the options: permissions and child roles are added, deny permissions
+// restrict the template by the combining algorithm, other options replace the properties.
+// The override is kept separately from the template and applied to its current version,
+// so changes of the template are visible in the tenant. Returns the composed role.
+//
+// Example:
+//
+//	mng.OverrideRole(ctx, `acme`, `editor`, rbac.WithPermissions(`!article.delete.*`, `report.view.*`))
+func (mng *Manager) OverrideRole(ctx context.Context, tenant, name string, options ...Option) (Role, error) {
+	if tenant == `` {
+		return nil, wrapError(ErrInvalidOptionParam, `OverrideRole (empty tenant)`)
+	}
+	template, err := mng.RoleE(WithTenant(ctx, ``), name)
+	if template == nil {
+		if err == nil {
+			err = ErrUnknownRole
+		}
+		return nil, wrapError(err, `OverrideRole `+name)
+	}
+	base, ok := template.(*role)
+	if !ok {
+		return nil, wrapError(ErrInvalidOption, `OverrideRole `+name+` (unsupported role type)`)
+	}
+	ext := &role{name: name}
+	for _, opt := range options {
+		if err := opt(ext); err != nil {
+			return nil, err
+		}
+	}
+	if err := ValidateRole(composeOverride(base, ext)); err != nil {
+		return nil, err
+	}
+	// Child roles of the override are shared by the compositions and prepared once
+	for i, child := range ext.roles {
+		ext.roles[i] = mng.prepareRole(ctx, child)
+	}
+
+	key := roleKey{tenant: tenant, name: name}
+	mng.mx.Lock()
+	if mng.overrides == nil {
+		mng.overrides = make(map[roleKey]*role)
+	}
+	mng.overrides[key] = ext
+	delete(mng.roles, key)
+	mng.tenants[tenant] = true
+	mng.resetViewsLocked()
+	mng.mx.Unlock()
+
+	if override := mng.overriddenRole(ctx, tenant, name); override != nil {
+		return override, nil
+	}
+	return nil, wrapError(ErrUnknownRole, `OverrideRole `+name)
+}
+
+// tenantRole returns the role registered in the scope of the tenant or composed by the override
+func (mng *Manager) tenantRole(ctx context.Context, tenant, name string) Role {
+	if ro := mng.registeredRole(roleKey{tenant: tenant, name: name}); ro != nil {
+		return ro
+	}
+	return mng.overriddenRole(ctx, tenant, name)
+}
+
+// hasTenantRole returns true if the role is registered or overridden in the scope of the tenant
+func (mng *Manager) hasTenantRole(key roleKey) bool {
+	mng.mx.RLock()
+	defer mng.mx.RUnlock()
+	return mng.roles[key] != nil || mng.overrides[key] != nil
+}
+
+// overriddenRole composes the override of the tenant with the current global template,
+// the composition is cached until the template is changed
+func (mng *Manager) overriddenRole(ctx context.Context, tenant, name string) Role {
+	key := roleKey{tenant: tenant, name: name}
+	mng.mx.RLock()
+	ext, generation, cached := mng.overrides[key], mng.viewsGeneration, mng.overrideViews[key]
+	mng.mx.RUnlock()
+	if ext == nil {
+		return nil
+	}
+	template, _ := mng.RoleE(WithTenant(ctx, ``), name)
+	base, ok := template.(*role)
+	if !ok {
+		// The template is removed, the override is not applicable
+		return nil
+	}
+	if cached != nil && cached.source == template {
+		return cached.view
+	}
+	view := mng.prepareRole(ctx, composeOverride(base, ext))
+
+	mng.mx.Lock()
+	defer mng.mx.Unlock()
+	if mng.viewsGeneration == generation {
+		if mng.overrideViews == nil {
+			mng.overrideViews = make(map[roleKey]*tenantView)
+		}
+		mng.overrideViews[key] = &tenantView{source: template, view: view}
+	}
+	return view
+}
+
+// composeOverride of the template, the template and the override are not changed
+func composeOverride(template, ext *role) *role {
+	override := template.clone()
+	override.extend(ext)
+	return override
+}
+
+// tenantRoleView returns the role with child roles replaced by the roles of the tenant
+//
+// The view is built and prepared out of the manager lock and cached only if the roles
+// are not changed during the building.
+func (mng *Manager) tenantRoleView(ctx context.Context, tenant string, ro Role) Role {
+	if tenant == `` || ro == nil {
+		return ro
+	}
+	key := roleKey{tenant: tenant, name: ro.Name()}
+	mng.mx.RLock()
+	known, generation, cached := mng.tenants[tenant], mng.viewsGeneration, mng.views[key]
+	mng.mx.RUnlock()
+	if !known {
+		return ro
+	}
+	if cached != nil && cached.source == ro {
+		return cached.view
+	}
+	view := mng.buildTenantView(ctx, tenant, ro, nil, map[*role]Role{})
+
+	mng.mx.Lock()
+	defer mng.mx.Unlock()
+	if mng.viewsGeneration == generation {
+		if mng.views == nil {
+			mng.views = make(map[roleKey]*tenantView)
+		}
+		mng.views[key] = &tenantView{source: ro, view: view}
+	}
+	return view
+}
+
+// buildTenantView of the role, views of the roles shared by several parents are built once
+func (mng *Manager) buildTenantView(ctx context.Context, tenant string, ro Role, path []string, built map[*role]Role) Role {
+	r, ok := ro.(*role)
+	if !ok || !canEnterRole(path, r.name) {
+		return ro
+	}
+	if view, ok := built[r]; ok {
+		return view
+	}
+	path = append(path, r.name)
+	children := make([]Role, len(r.roles))
+	changed := false
+	for i, child := range r.roles {
+		sub := child
+		if override := mng.tenantRole(ctx, tenant, child.Name()); override != nil {
+			sub = override
+		}
+		children[i] = mng.buildTenantView(ctx, tenant, sub, path, built)
+		changed = changed || children[i] != child
+	}
+	if !changed {
+		built[r] = ro
+		return ro
+	}
+	view := r.clone()
+	view.roles = children
+	built[r] = mng.prepareRole(ctx, view)
+	return built[r]
+}
+
+// tenantRoles returns roles of the scope, tenant roles replace global roles with the same name
+func (mng *Manager) tenantRoles(ctx context.Context, tenant string) []Role {
+	mng.mx.RLock()
+	roles := make([]Role, 0, len(mng.roles))
+	for key, ro := range mng.roles {
+		tenantKey := roleKey{tenant: tenant, name: key.name}
+		switch {
+		case key.tenant == tenant:
+			roles = append(roles, ro)
+		case key.tenant == `` && mng.roles[tenantKey] == nil && mng.overrides[tenantKey] == nil:
+			roles = append(roles, ro)
+		}
+	}
+	var overridden []string
+	for key := range mng.overrides {
+		if key.tenant == tenant {
+			overridden = append(overridden, key.name)
+		}
+	}
+	mng.mx.RUnlock()
+
+	// Overrides and views are built out of the lock
+	for _, name := range overridden {
+		if ro := mng.overriddenRole(ctx, tenant, name); ro != nil {
+			roles = append(roles, ro)
+		}
+	}
+	for i, ro := range roles {
+		roles[i] = mng.tenantRoleView(ctx, tenant, ro)
+	}
+	return roles
+}
+
+// clone of the role without the flattened index
+func (r *role) clone() *role {
+	return &role{
+		name:               r.name,
+		description:        r.description,
+		roles:              slices.Clone(r.roles),
+		permissions:        slices.Clone(r.permissions),
+		preloadPermissions: slices.Clone(r.preloadPermissions),
+		preloadedPatterns:  slices.Clone(r.preloadedPatterns),
+		preloaded:          maps.Clone(r.preloaded),
+		conditions:         maps.Clone(r.conditions),
+		combining:          r.combining,
+		window:             r.window,
+		extData:            r.extData,
+	}
+}
+
+// extend the role by permissions and child roles of the other role,
+// other defined properties replace the properties of the role
+func (r *role) extend(o *role) {
+	r.roles = append(r.roles, o.roles...)
+	r.AddPermissions(o.permissions...)
+	r.preloadPermissions = append(r.preloadPermissions, o.preloadPermissions...)
+	for pattern, cond := range o.conditions {
+		if r.conditions == nil {
+			r.conditions = map[string]*Condition{}
+		}
+		r.conditions[pattern] = cond
+	}
+	if o.description != `` {
+		r.description = o.description
+	}
+	if o.combining.valid() {
+		r.combining = o.combining
+	}
+	if o.window != nil {
+		r.window = o.window
+	}
+	if o.extData != nil {
+		r.extData = o.extData
+	}
+	r.generation.Add(1)
+}
@@ -0,0 +1,123 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagerTenantRoles(t *testing.T) {
+	ctx := context.TODO()
+	acme, globex := WithTenant(ctx, `acme`), WithTenant(ctx, `globex`)
+	obj := &testObject{}
+
+	mng := NewManager(nil)
+	assert.NoError(t, mng.RegisterNewOwningPermissions((*testObject)(nil), []string{`view`, `update`, `delete`}))
+	editor := MustNewRole(`editor`, WithPermissions(`rbac.testObject.view.*`, `rbac.testObject.update.*`))
+	mng.RegisterRole(ctx, editor)
+	mng.RegisterRole(ctx, MustNewRole(`admin`, WithChildRoles(editor), WithPermissions(`rbac.testObject.delete.*`)))
+
+	// Tenant role which is not available globally
+	mng.RegisterTenantRole(ctx, `acme`, MustNewRole(`auditor`, WithPermissions(`rbac.testObject.view.*`)))
+	assert.NotNil(t, mng.Role(acme, `auditor`))
+	assert.Nil(t, mng.Role(ctx, `auditor`))
+	assert.Nil(t, mng.Role(globex, `auditor`))
+
+	// Override of the global template restricts the role of the tenant and the global hierarchy
+	override, err := mng.OverrideRole(ctx, `acme`, `editor`, WithPermissions(`!rbac.testObject.update.*`))
+	assert.NoError(t, err)
+	assert.Equal(t, `editor`, override.Name())
+	assert.True(t, mng.Role(ctx, `editor`).CheckPermissions(ctx, obj, `update.all`))
+	assert.False(t, mng.Role(acme, `editor`).CheckPermissions(acme, obj, `update.all`))
+	assert.True(t, mng.Role(acme, `editor`).CheckPermissions(acme, obj, `view.all`))
+	assert.True(t, mng.Role(globex, `editor`).CheckPermissions(globex, obj, `update.all`))
+
+	admin := mng.Role(acme, `admin`)
+	assert.False(t, admin.CheckPermissions(acme, obj, `update.all`))
+	assert.True(t, admin.CheckPermissions(acme, obj, `delete.all`))
+	assert.Same(t, admin, mng.Role(acme, `admin`), `tenant view is cached`)
+	assert.True(t, mng.Role(ctx, `admin`).CheckPermissions(ctx, obj, `update.all`))
+
+	// Listing of the tenant scope
+	names := func(roles []Role) (list []string) {
+		for _, role := range roles {
+			list = append(list, role.Name())
+		}
+		return list
+	}
+	assert.ElementsMatch(t, []string{`admin`, `editor`, `auditor`}, names(mng.Roles(acme)))
+	assert.ElementsMatch(t, []string{`admin`, `editor`}, names(mng.Roles(ctx)))
+	assert.ElementsMatch(t, []string{`auditor`}, names(mng.RolesByFilter(acme,
+		func(_ context.Context, role Role) bool {
+			return !role.HasPermission(`rbac.testObject.delete.*`) && role.Name() != `editor`
+		})))
+
+	// Subject of the tenant
+	subject := &SimpleSubject{ID: 1, Tenant: `acme`, Roles: []string{`admin`}}
+	assert.False(t, mng.Check(ctx, subject, obj, `update.all`).Allowed())
+	assert.True(t, mng.Check(ctx, &SimpleSubject{ID: 2, Roles: []string{`admin`}}, obj, `update.all`).Allowed())
+
+	// Decision cache is scoped by the tenant
+	cached := WithDecisionCache(ctx)
+	assert.True(t, editor.CheckPermissions(cached, obj, `update.all`))
+	assert.False(t, mng.Role(acme, `editor`).CheckPermissions(WithTenant(cached, `acme`), obj, `update.all`))
+
+	// Changes of the template are applied to the override of the tenant
+	mng.RegisterRole(ctx, MustNewRole(`editor`, WithPermissions(`rbac.testObject.*.*`)))
+	assert.True(t, mng.Role(acme, `editor`).CheckPermissions(acme, obj, `delete.all`))
+	assert.False(t, mng.Role(acme, `editor`).CheckPermissions(acme, obj, `update.all`))
+	assert.Same(t, mng.Role(acme, `editor`), mng.Role(acme, `editor`), `override is cached`)
+
+	// Tenant role replaces the override
+	mng.RegisterTenantRole(ctx, `acme`, MustNewRole(`editor`, WithPermissions(`rbac.testObject.update.*`)))
+	assert.True(t, mng.Role(acme, `editor`).CheckPermissions(acme, obj, `update.all`))
+	assert.False(t, mng.Role(acme, `editor`).CheckPermissions(acme, obj, `delete.all`))
+
+	_, err = mng.OverrideRole(ctx, `acme`, `unknown`)
+	assert.ErrorIs(t, err, ErrUnknownRole)
+	_, err = mng.OverrideRole(ctx, `acme`, `editor`, WithChildRoles(mng.Role(ctx, `admin`)))
+	assert.ErrorIs(t, err, ErrRoleCycle)
+	_, err = mng.OverrideRole(ctx, ``, `editor`)
+	assert.ErrorIs(t, err, ErrInvalidOptionParam)
+}
+
+type testBlockingAccessors struct {
+	RoleAccessors
+	started, release chan struct{}
+}
+
+func (a *testBlockingAccessors) Role(_ context.Context, name string) Role {
+	close(a.started)
+	<-a.release
+	return MustNewRole(name, WithPermissions(`view`), WithChildRoles(MustNewRole(`viewer`)))
+}
+
+func TestManagerTenantRolesLocking(t *testing.T) {
+	ctx := context.TODO()
+	acme := WithTenant(ctx, `acme`)
+	accessors := &testBlockingAccessors{started: make(chan struct{}), release: make(chan struct{})}
+	mng := NewManager(accessors)
+	mng.RegisterPermission(MustNewSimplePermission(`view`))
+	mng.RegisterTenantRole(ctx, `acme`, MustNewRole(`viewer`, WithPermissions(`view`)))
+
+	// Loaded roles and views are prepared while the registration waits for the lock
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.True(t, mng.Role(acme, `loaded`).CheckPermissions(acme, nil, `view`))
+	}()
+	<-accessors.started
+	go mng.RegisterRole(ctx, MustNewRole(`other`))
+	time.Sleep(10 * time.Millisecond)
+	close(accessors.release)
+	assert.Eventually(t, func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+}
@@ -4,7 +4,7 @@ import (
 	"errors"
 	"path/filepath"
 	"reflect"
-	"regexp"
+	"runtime"
 	"strings"
 
 	"github.com/demdxx/xtypes"
@@ -28,66 +28,21 @@ func nextBlockIndex(pattern string, start int) int {
 // MatchName permission pattern
 // Example:
 // `*` or `**` matches any string
-// `test.*` matches `test.it`, `test.it.owner`, `test.it.admin
+// `test.*` matches `test.it`, `test.object`
 // `test.*.owner` matches `test.it.owner`, `test.object.owner`
 // `test.*.*` matches `test.it.owner`, `test.object.owner`
 // `test.*.?wner` matches `test.it.owner`, `test.object.owner
 // `test.*.{owner|admin}` matches `test.it.owner`, `test.object.admin`
-// `test.%r{[a-z]+}` matches `test.it.owner`, `test.object.admin` (regexp)
+// `test.%r{[a-z]+}` matches `test.it`, `test.object` (regexp)
 // `test.**` matches `test.it.owner`, `test.object.admin` (** must be at the end)
+//
+// The compiled pattern is taken from the package cache, see CompilePattern.
 func MatchName(pattern, name string) (ok bool, err error) {
-	if pattern == `*` || pattern == `**` {
-		return true, nil
-	}
-
-	for nsi, psi := 0, 0; ; {
-		// Search pattern block
-		nnpi := nextBlockIndex(name, nsi)
-		pnpi := nextBlockIndex(pattern, psi)
-
-		if pnpi <= psi {
-			return nnpi <= nsi, nil
-		}
-		if nnpi <= nsi {
-			return false, nil
-		}
-
-		curNamePart := name[nsi:nnpi]
-		curPattern := pattern[psi:pnpi]
-		if curPattern == `**` {
-			if pnpi == len(pattern) {
-				return true, nil
-			}
-			return false, wrapError(ErrInvalidPattern, `** must be at the end`)
-		}
-		if ok, err := matchPatternPart(curPattern, curNamePart); err != nil || !ok {
-			return false, err
-		}
-
-		nsi = nnpi + 1
-		psi = pnpi + 1
-		if nsi >= len(name) && psi >= len(pattern) {
-			return true, nil
-		}
-	}
-}
-
-func matchPatternPart(pattern, name string) (bool, error) {
-	if pattern == `*` || pattern == `**` {
-		return true, nil
-	}
-	if strings.HasPrefix(pattern, `%r{`) && strings.HasSuffix(pattern, `}`) {
-		return regexp.MatchString(pattern[3:len(pattern)-1], name)
-	}
-	if strings.HasPrefix(pattern, `{`) && strings.HasSuffix(pattern, `}`) {
-		parts := strings.Split(pattern[1:len(pattern)-1], `|`)
-		for _, p := range parts {
-			if p == name {
-				return true, nil
-			}
-		}
+	compiled, err := compilePatternCached(pattern)
+	if err != nil {
+		return false, err
 	}
-	return matchEqual(pattern, name), nil
+	return compiled.Match(name), nil
 }
 
 func matchEqual(pattern, name string) bool {
@@ -107,6 +62,12 @@ func matchEqual(pattern, name string) bool {
 	return true
 }
 
+// validatePattern checks the syntax of the permission pattern
+func validatePattern(pattern string) error {
+	_, err := compilePatternCached(pattern)
+	return err
+}
+
 // checkPattern checks if the string matches any of the patterns
 //
 // Example:
@@ -116,7 +77,7 @@ func matchEqual(pattern, name string) bool {
 // checkPattern(`test.it.admin`, `test.*.owner`) => false
 func checkPattern(name string, patterns ...string) bool {
 	for _, pattern := range patterns {
-		if ok, _ := MatchName(pattern, name); ok {
+		if compiled, _ := compilePatternCached(pattern); compiled != nil && compiled.Match(name) {
 			return true
 		}
 	}
@@ -132,10 +93,9 @@ func checkPattern(name string, patterns ...string) bool {
 func checkResourcePattern(resName, name string, patterns ...string) bool {
 	fullName := resName + `.` + name
 	for _, pattern := range patterns {
-		if ok, _ := MatchName(pattern, fullName); ok {
-			return true
-		}
-		if ok, _ := MatchName(resName+`.`+pattern, fullName); ok {
+		// The pattern relative to the resource matches the name without resource prefix
+		if compiled, _ := compilePatternCached(pattern); compiled != nil &&
+			(compiled.Match(fullName) || compiled.Match(name)) {
 			return true
 		}
 	}
@@ -182,6 +142,18 @@ func GetResType(resource any) (res reflect.Type) {
 	return res
 }
 
+// funcName returns full name of the function
+func funcName(f any) string {
+	v := reflect.ValueOf(f)
+	if v.Kind() != reflect.Func {
+		return ``
+	}
+	if fn := runtime.FuncForPC(v.Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return ``
+}
+
 func validatePermissionName(name string) error {
 	if name == `` {
 		return ErrEmptyPermissionName